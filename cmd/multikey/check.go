@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"path/filepath"
+
+	"github.com/adrianosela/multikey/policy"
+)
+
+func runCheck(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("check", "[-policy FILE] [-json]", stderr)
+	policyPath := fs.String("policy", "", "policy file (default "+policy.DefaultFile+" in the current directory or its parents)")
+	asJSON := fs.Bool("json", false, "output violations as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	p, err := loadPolicy(*policyPath)
+	if err != nil {
+		return err
+	}
+	violations, err := p.Check()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Violations []policy.Violation `json:"violations"`
+		}{violations}); err != nil {
+			return err
+		}
+	} else {
+		for _, v := range violations {
+			fmt.Fprintln(stdout, v)
+		}
+	}
+	if len(violations) > 0 {
+		return &codedError{code: exitPolicyViolation, err: fmt.Errorf("%d policy violations", len(violations))}
+	}
+	return nil
+}
+
+// loadPolicy loads the policy file at path, or found from the current
+// directory if path is empty
+func loadPolicy(path string) (*policy.Policy, error) {
+	if path == "" {
+		var err error
+		if path, err = policy.Find("."); err != nil {
+			return nil, err
+		}
+	}
+	p, err := policy.Load(path)
+	if errors.Is(err, iofs.ErrNotExist) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &codedError{code: exitMalformedInput, err: err}
+	}
+	return p, nil
+}
+
+// policyRule returns the rule of the policy found from the current
+// directory for the given file, to encrypt it with when no recipients are
+// given. It returns nil if there is no policy.
+func policyRule(file string) (*policy.Rule, error) {
+	path, err := policy.Find(".")
+	if errors.Is(err, policy.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := loadPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(p.Dir(), abs)
+	if err != nil {
+		return nil, err
+	}
+	r := p.Match(rel)
+	if r == nil {
+		return nil, fmt.Errorf("no rule of %s applies to %s", path, rel)
+	}
+	return r, nil
+}
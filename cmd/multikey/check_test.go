@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `rules:
+  - path: prod/**
+    recipients: [keys/]
+    require: 2
+    must_include: [keys/carol.pub]
+  - path: "**"
+    recipients: [keys/alice.pub]
+protected:
+  - "*.secret"
+`
+
+// policyRepo creates a directory holding the test recipients' public keys
+// under keys/ and the test policy, and changes into it for the duration
+// of the test
+func policyRepo(t *testing.T) {
+	keysDir, err := filepath.Abs(filepath.Join("testdata", "keys"))
+	assert.Nil(t, err)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	assert.Nil(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	assert.Nil(t, os.MkdirAll(filepath.Join("keys"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join("prod", "db"), 0755))
+	for _, name := range []string{"alice", "bob", "carol"} {
+		pub, err := os.ReadFile(filepath.Join(keysDir, name+pubKeyExt))
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(filepath.Join("keys", name+pubKeyExt), pub, 0644))
+	}
+	assert.Nil(t, os.WriteFile(policy.DefaultFile, []byte(testPolicy), 0644))
+}
+
+func TestEncryptWithPolicy(t *testing.T) {
+	policyRepo(t)
+
+	// recipients and threshold come from the rule matching the output file,
+	// from within subdirectories too
+	assert.Nil(t, os.Chdir("prod"))
+	code, _, stderr := runCLI(t, []byte("password=1\n"), "encrypt", "-out", filepath.Join("db", "app.secret"))
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Nil(t, os.Chdir(".."))
+	enc, err := os.ReadFile(filepath.Join("prod", "db", "app.secret"))
+	assert.Nil(t, err)
+	info, err := multikey.Inspect(string(enc))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, info.Threshold)
+	assert.Len(t, info.KeyIDs, 3)
+
+	// the threshold can still be given
+	code, _, stderr = runCLI(t, []byte("password=1\n"), "encrypt", "-require", "3", "-out", filepath.Join("prod", "strict.secret"))
+	assert.Equal(t, exitOK, code, stderr)
+	enc, err = os.ReadFile(filepath.Join("prod", "strict.secret"))
+	assert.Nil(t, err)
+	info, err = multikey.Inspect(string(enc))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, info.Threshold)
+
+	code, _, stderr = runCLI(t, []byte("password=1\n"), "encrypt", "-out", "dev.secret")
+	assert.Equal(t, exitOK, code, stderr)
+	enc, err = os.ReadFile("dev.secret")
+	assert.Nil(t, err)
+	info, err = multikey.Inspect(string(enc))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, info.Threshold)
+	assert.Len(t, info.KeyIDs, 1)
+
+	// the keys of the rule are pinned like those given with -r
+	bob, err := os.ReadFile(filepath.Join("keys", "bob"+pubKeyExt))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join("keys", "alice"+pubKeyExt), bob, 0644))
+	code, _, stderr = runCLI(t, []byte("password=1\n"), "encrypt", "-out", "dev.secret")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "key does not match its pinned fingerprint")
+
+	// output to stdout matches no rule
+	code, _, _ = runCLI(t, []byte("password=1\n"), "encrypt")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestCheck(t *testing.T) {
+	policyRepo(t)
+	code, stdout, stderr := runCLI(t, nil, "check")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "", stdout)
+
+	code, _, stderr = runCLI(t, []byte("password=1\n"), "encrypt", "-r", "keys/alice.pub", "-r", "keys/bob.pub", "-out", filepath.Join("prod", "app.secret"))
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Nil(t, os.WriteFile("plain.secret", []byte("password=1\n"), 0644))
+
+	code, stdout, _ = runCLI(t, nil, "check")
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Equal(t, "plain.secret: unencrypted: protected file is not encrypted\n"+
+		"prod/app.secret@0: threshold: threshold is 1, at least 2 is required\n"+
+		"prod/app.secret@0: missing-key: not encrypted for required key fb:3b:e1:5e:a5:98:1c:6c:6f:c3:41:37:75:c3:d5:c4\n", stdout)
+
+	code, stdout, _ = runCLI(t, nil, "check", "-json", "-policy", policy.DefaultFile)
+	assert.Equal(t, exitPolicyViolation, code)
+	var report struct {
+		Violations []policy.Violation `json:"violations"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Len(t, report.Violations, 3)
+	assert.Equal(t, policy.CodeMissingKey, report.Violations[2].Code)
+	assert.Equal(t, "prod/**", report.Violations[2].Rule)
+
+	// an invalid policy is malformed input
+	assert.Nil(t, os.WriteFile(policy.DefaultFile, []byte("rules: [{require: 1}]\n"), 0644))
+	code, _, _ = runCLI(t, nil, "check")
+	assert.Equal(t, exitMalformedInput, code)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keyring"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/policy"
+)
+
+const (
+	defaultKeyBits = 4096
+	minKeyBits     = 2048 // smaller keys can not hold an encrypted shard
+)
+
+func runKeygen(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keygen", "[-bits N] [-out NAME]", stderr)
+	bits := fs.Int("bits", defaultKeyBits, "size of the key in bits")
+	out := fs.String("out", "", "write the key pair to NAME"+privKeyExt+" and NAME"+pubKeyExt+" instead of the private key to stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	if *bits < minKeyBits {
+		return usagef(fs, "-bits must be at least %d", minKeyBits)
+	}
+	priv, err := rsa.GenerateKey(randReader, *bits)
+	if err != nil {
+		return fmt.Errorf("could not generate key: %s", err)
+	}
+	if *out == "" {
+		return writeOutput("", keys.EncodePrivKeyPEM(priv), 0, stdout)
+	}
+	if err := writeOutput(*out+privKeyExt, keys.EncodePrivKeyPEM(priv), 0600, stdout); err != nil {
+		return err
+	}
+	if err := writeOutput(*out+pubKeyExt, keys.EncodePubKeyPEM(&priv.PublicKey), 0644, stdout); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, keys.GetFingerprint(&priv.PublicKey))
+	return nil
+}
+
+func runEncrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("encrypt", "[-r KEY|DIR|NAME ...] [-keyring FILE [-force]] [-require N] [-sign KEY] [-context CONTEXT] [-name NAME] [-description TEXT] [-content-type TYPE] [-label KEY=VALUE ...] [-created-by KEY] [-rotate-by DATE] [-not-after DATE] [-in FILE] [-out FILE [-update -k KEY|DIR ...]]", stderr)
+	var recipients, keyPaths listFlag
+	fs.Var(&recipients, "r", "recipient public (or private) key file, directory of *"+pubKeyExt+" files, or name of a key or group in the keyring (repeatable, default from the "+policy.DefaultFile+" rule for the -out file)")
+	fs.Var(&recipients, "recipient", "same as -r")
+	keyringPath := fs.String("keyring", "", keyringFlagUsage)
+	force := fs.Bool("force", false, "encrypt for revoked or expired keys of the keyring")
+	require := fs.Int("require", 1, "number of recipient keys required to decrypt (default from the policy rule when recipients are)")
+	signKey := fs.String("sign", "", "private key file to sign the secret with as its author")
+	context := fs.String("context", "", contextFlagUsage)
+	meta := addMetadataFlags(fs)
+	in := fs.String("in", "", "file to read the secret from (default stdin)")
+	out := fs.String("out", "", "file to write the encrypted secret to (default stdout)")
+	update := fs.Bool("update", false, "update the -out file if it exists, changing as few of its lines as possible")
+	fs.Var(&keyPaths, "k", "with -update, private key file or directory of *"+privKeyExt+" files to decrypt the -out file (repeatable)")
+	fs.Var(&keyPaths, "key", "same as -k")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	if *update && (*out == "" || *out == "-" || len(keyPaths) == 0) {
+		return usagef(fs, "-update requires -out and at least one key")
+	}
+	ring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	var pubs []*rsa.PublicKey
+	var pinned []recipient
+	if len(recipients) > 0 {
+		if pinned, err = loadRecipients(recipients, ring, keyringFile(*keyringPath)); err != nil {
+			return err
+		}
+		pubs = publicKeys(pinned)
+	} else {
+		if *out == "" || *out == "-" {
+			return usagef(fs, "at least one recipient is required, unless -out is covered by a policy")
+		}
+		rule, err := policyRule(*out)
+		if err != nil {
+			return err
+		}
+		if rule == nil {
+			return usagef(fs, "at least one recipient is required, or a %s policy", policy.DefaultFile)
+		}
+		if _, err = rule.PublicKeys(); err != nil {
+			return fmt.Errorf("policy rule %s: %s", rule.Path, err)
+		}
+		if pinned, err = loadRecipients(rule.RecipientPaths(), nil, ""); err != nil {
+			return err
+		}
+		pubs = publicKeys(pinned)
+		if !flagSet(fs, "require") {
+			*require = rule.Require
+		}
+	}
+	if *require < 1 || *require > len(pubs) {
+		return usagef(fs, "-require must be between 1 and the number of recipients (%d)", len(pubs))
+	}
+	if err := checkRecipients(ring, pubs, *force, stderr); err != nil {
+		return err
+	}
+	if err := pinRecipients(pinned); err != nil {
+		return err
+	}
+	data, err := readInput(*in, stdin)
+	if err != nil {
+		return err
+	}
+	opts := &multikey.EncryptOptions{Rand: randReader}
+	if opts.Signer, err = loadSigner(*signKey); err != nil {
+		return err
+	}
+	if opts.Context, err = fileContext(*out, *context, flagSet(fs, "context")); err != nil {
+		return err
+	}
+	if opts.Metadata, err = meta.metadata(fs, nil, opts.Signer); err != nil {
+		return err
+	}
+	if *update {
+		existing, err := os.ReadFile(*out)
+		if err == nil {
+			if info, err := multikey.Inspect(string(existing)); err == nil {
+				if opts.Metadata, err = meta.metadata(fs, info, opts.Signer); err != nil {
+					return err
+				}
+			}
+			privs, err := loadPrivateKeys(keyPaths)
+			if err != nil {
+				return err
+			}
+			enc, err := multikey.UpdateWithOptions(string(existing), data, privs, pubs, *require, opts)
+			if err != nil {
+				return classify(explainContext(err, opts.Context))
+			}
+			if enc == string(existing) {
+				return nil
+			}
+			return replaceFile(*out, []byte(enc))
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	enc, err := multikey.EncryptWithOptions(data, pubs, *require, opts)
+	if err != nil {
+		return classify(err)
+	}
+	return writeOutput(*out, []byte(enc), 0644, stdout)
+}
+
+func runDecrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("decrypt", "-k KEY|DIR [-k ...] [-signer KEY|DIR|NAME ...] [-keyring FILE] [-context CONTEXT] [-allow-expired] [-in FILE] [-out FILE]", stderr)
+	var keyPaths, signers listFlag
+	fs.Var(&keyPaths, "k", "private key file, or directory of *"+privKeyExt+" files (repeatable)")
+	fs.Var(&keyPaths, "key", "same as -k")
+	fs.Var(&signers, "signer", "require the secret to be signed by this public key file, directory of *"+pubKeyExt+" files, or key or group of the keyring (repeatable)")
+	keyringPath := fs.String("keyring", "", keyringFlagUsage+", to warn about decrypting with revoked keys")
+	context := fs.String("context", "", contextFlagUsage)
+	allowExpired := fs.Bool("allow-expired", false, "decrypt the secret even if it has expired")
+	in := fs.String("in", "", "file to read the encrypted secret from (default stdin)")
+	out := fs.String("out", "", "file to write the secret to (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	if len(keyPaths) == 0 {
+		return usagef(fs, "at least one key is required")
+	}
+	privs, err := loadPrivateKeys(keyPaths)
+	if err != nil {
+		return err
+	}
+	ring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	opts, err := decryptOptions(signers, ring, keyringFile(*keyringPath))
+	if err != nil {
+		return err
+	}
+	if opts.Context, err = fileContext(*in, *context, flagSet(fs, "context")); err != nil {
+		return err
+	}
+	opts.AllowExpired = *allowExpired
+	enc, err := readInput(*in, stdin)
+	if err != nil {
+		return err
+	}
+	plain, err := multikey.DecryptWithOptions(string(enc), privs, opts)
+	if err != nil {
+		return classify(explainExpired(explainContext(err, opts.Context)))
+	}
+	if ring != nil {
+		info, _ := multikey.Inspect(string(enc))
+		warnRevoked(ring, privs, info, stderr)
+	}
+	return writeOutput(*out, plain, 0600, stdout)
+}
+
+func runInspect(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("inspect", "[-in FILE] [-keyring FILE]", stderr)
+	in := fs.String("in", "", "file to read the encrypted secret from (default stdin)")
+	keyringPath := fs.String("keyring", "", keyringFlagUsage+", to name the keys after")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	ring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	enc, err := readInput(*in, stdin)
+	if err != nil {
+		return err
+	}
+	info, err := multikey.Inspect(string(enc))
+	if err != nil {
+		return classify(err)
+	}
+	threshold := "unknown"
+	if info.Threshold > 0 {
+		threshold = fmt.Sprintf("%d of %d", info.Threshold, len(info.KeyIDs))
+	}
+	fmt.Fprintf(stdout, "version:   %d\n", info.Version)
+	fmt.Fprintf(stdout, "threshold: %s\n", threshold)
+	if info.Signer != "" {
+		fmt.Fprintf(stdout, "signer:    %s\n", keyName(ring, info.Signer))
+	}
+	if info.Bound {
+		fmt.Fprintf(stdout, "context:   bound\n")
+	}
+	printMetadata(info, ring, stdout)
+	fmt.Fprintf(stdout, "keys:\n")
+	for _, id := range info.KeyIDs {
+		fmt.Fprintf(stdout, "  %s\n", keyName(ring, id))
+	}
+	return nil
+}
+
+// keyName returns a fingerprint followed by the name of its key in the
+// keyring, if any
+func keyName(ring *keyring.Keyring, fp string) string {
+	if ring != nil {
+		if k, ok := ring.Lookup(fp); ok {
+			return fp + "  " + k.Name
+		}
+	}
+	return fp
+}
+
+// loadSigner loads the private key to sign secrets with, if any
+func loadSigner(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	privs, err := loadPrivateKeys([]string{path})
+	if err != nil {
+		return nil, err
+	}
+	if len(privs) != 1 {
+		return nil, &usageError{msg: "-sign must be a single private key file"}
+	}
+	return privs[0], nil
+}
+
+// decryptOptions returns the options requiring secrets to be signed by
+// one of the given signers, if any. Revoked keys of the keyring are not
+// trusted to sign.
+func decryptOptions(signers []string, ring *keyring.Keyring, ringPath string) (*multikey.DecryptOptions, error) {
+	if len(signers) == 0 {
+		return &multikey.DecryptOptions{}, nil
+	}
+	trusted, err := loadRecipients(signers, ring, ringPath)
+	if err != nil {
+		return nil, err
+	}
+	opts := &multikey.DecryptOptions{Signers: []*rsa.PublicKey{}}
+	for _, pub := range publicKeys(trusted) {
+		if ring != nil {
+			if _, revoked := ring.Revocation(keys.GetFingerprint(pub)); revoked {
+				continue
+			}
+		}
+		opts.Signers = append(opts.Signers, pub)
+	}
+	if len(opts.Signers) == 0 {
+		return nil, fmt.Errorf("every -signer key is revoked")
+	}
+	return opts, nil
+}
+
+func runFingerprint(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("fingerprint", "[KEY ...]", stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		raw, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		pub, err := parsePublicKey("stdin", raw)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, keys.GetFingerprint(pub))
+		return nil
+	}
+	for _, path := range fs.Args() {
+		pub, err := readPublicKey(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s  %s\n", keys.GetFingerprint(pub), path)
+	}
+	return nil
+}
+
+// classify associates errors returned by the multikey package
+// with their exit codes
+func classify(err error) error {
+	switch {
+	case errors.Is(err, multikey.ErrInsufficientKeys):
+		return &codedError{code: exitInsufficientKeys, err: err}
+	case errors.Is(err, multikey.ErrMalformedSecret):
+		return &codedError{code: exitMalformedInput, err: err}
+	case errors.Is(err, multikey.ErrInvalidMetadata):
+		return &usageError{msg: err.Error()}
+	case errors.Is(err, multikey.ErrContextMismatch),
+		errors.Is(err, multikey.ErrExpired),
+		errors.Is(err, multikey.ErrUnsigned),
+		errors.Is(err, multikey.ErrUntrustedSigner),
+		errors.Is(err, multikey.ErrInvalidSignature):
+		return &codedError{code: exitPolicyViolation, err: err}
+	}
+	return err
+}
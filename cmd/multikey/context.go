@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/adrianosela/multikey"
+)
+
+const contextFlagUsage = "context the secret is bound to (default the path of the file relative to its repository, \"\" for none)"
+
+// secretContext returns the context a secret kept in a file is bound to:
+// the slash separated path of the file relative to the root of its git
+// repository, or its name outside of one, so that it is the same
+// wherever the repository is checked out
+func secretContext(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			rel, err := filepath.Rel(dir, abs)
+			if err != nil {
+				return "", err
+			}
+			return filepath.ToSlash(rel), nil
+		}
+		if filepath.Dir(dir) == dir {
+			return filepath.Base(abs), nil
+		}
+	}
+}
+
+// fileContext returns the context of the secret in a file, or that given
+// with a -context flag
+func fileContext(path string, flagValue string, given bool) (string, error) {
+	if given || path == "" || path == "-" {
+		return flagValue, nil
+	}
+	return secretContext(path)
+}
+
+// boundContext returns the context of a file's secret if it is bound to
+// one, so that re-encrypting secrets keeps them bound or unbound
+func boundContext(path string, bound bool) (string, error) {
+	if !bound {
+		return "", nil
+	}
+	return secretContext(path)
+}
+
+// explainContext adds the context a secret failed to decrypt with to
+// context mismatch errors, and to errors of secrets failing authentication,
+// which a wrong context can not be told apart from
+func explainContext(err error, context string) error {
+	if errors.Is(err, multikey.ErrAuthenticationFailed) && context != "" {
+		return fmt.Errorf("%w; if it was moved from another file than %q, give the context it was encrypted for with -context", err, context)
+	}
+	if !errors.Is(err, multikey.ErrContextMismatch) {
+		return err
+	}
+	if context == "" {
+		return fmt.Errorf("%w, give it with -context or the secret's file with -in", err)
+	}
+	return fmt.Errorf("%w than %q, give the context it was encrypted for with -context", err, context)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+)
+
+const (
+	defaultEditor = "vi"
+
+	// tmpfsDir is a memory backed directory present on most Linux systems.
+	// Decrypted secrets are preferably kept there so they never hit disk.
+	tmpfsDir = "/dev/shm"
+)
+
+func runEdit(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("edit", "-k KEY|DIR [-k ...] [-r KEY|DIR ...] [-require N] [-sign KEY] [-allow-expired] FILE", stderr)
+	var keyPaths, recipients listFlag
+	fs.Var(&keyPaths, "k", "private key file, or directory of *"+privKeyExt+" files (repeatable)")
+	fs.Var(&keyPaths, "key", "same as -k")
+	fs.Var(&recipients, "r", "where to find the public keys of the file's recipients, as in encrypt (repeatable)")
+	fs.Var(&recipients, "recipient", "same as -r")
+	require := fs.Int("require", 0, "threshold to re-encrypt with, only needed for files which do not record it")
+	signKey := fs.String("sign", "", "private key file to sign the edited secret with as its author")
+	allowExpired := fs.Bool("allow-expired", false, "edit the secret even if it has expired")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef(fs, "exactly one file must be given")
+	}
+	if len(keyPaths) == 0 {
+		return usagef(fs, "at least one key is required")
+	}
+	path := fs.Arg(0)
+
+	privs, err := loadPrivateKeys(keyPaths)
+	if err != nil {
+		return err
+	}
+	signer, err := loadSigner(*signKey)
+	if err != nil {
+		return err
+	}
+	enc, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := multikey.Inspect(string(enc))
+	if err != nil {
+		return classify(err)
+	}
+	threshold := info.Threshold
+	if threshold == 0 {
+		if *require < 1 || *require > len(info.KeyIDs) {
+			return usagef(fs, "%s does not record its threshold, -require must be between 1 and %d", path, len(info.KeyIDs))
+		}
+		threshold = *require
+	}
+	pubs, err := resolveRecipients(info.KeyIDs, recipients, privs)
+	if err != nil {
+		return err
+	}
+	context, err := boundContext(path, info.Bound)
+	if err != nil {
+		return err
+	}
+	plain, err := multikey.DecryptWithOptions(string(enc), privs, &multikey.DecryptOptions{Context: context, AllowExpired: *allowExpired})
+	if err != nil {
+		return classify(explainExpired(explainContext(err, context)))
+	}
+
+	edited, err := editInTempFile(filepath.Base(path), plain, stdin, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, plain) {
+		fmt.Fprintf(stderr, "%s: no changes made\n", path)
+		return nil
+	}
+	reenc, err := multikey.UpdateWithOptions(string(enc), edited, privs, pubs, threshold, &multikey.EncryptOptions{Rand: randReader, Signer: signer, Context: context})
+	if err != nil {
+		return err
+	}
+	if info.Signer != "" && signer == nil {
+		fmt.Fprintf(stderr, "multikey: warning: %s was signed by %s and is no longer signed, sign it with -sign KEY\n", path, info.Signer)
+	}
+	return replaceFile(path, []byte(reenc))
+}
+
+// resolveRecipients finds the public key of each of the given fingerprints,
+// amongst the keys at the given paths and the given private keys
+func resolveRecipients(ids []string, paths []string, privs []*rsa.PrivateKey) ([]*rsa.PublicKey, error) {
+	known := map[string]*rsa.PublicKey{}
+	for _, priv := range privs {
+		known[keys.GetFingerprint(&priv.PublicKey)] = &priv.PublicKey
+	}
+	if len(paths) > 0 {
+		found, err := loadPinnedKeys(paths)
+		if err != nil {
+			return nil, err
+		}
+		for _, pub := range found {
+			known[keys.GetFingerprint(pub)] = pub
+		}
+	}
+	pubs := []*rsa.PublicKey{}
+	missing := []string{}
+	for _, id := range ids {
+		pub, ok := known[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		pubs = append(pubs, pub)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("public keys of recipients %s not found, provide them with -r", strings.Join(missing, ", "))
+	}
+	return pubs, nil
+}
+
+// editInTempFile writes data to a private temporary file, opens it in the
+// user's editor and returns its contents once the editor exits. The
+// temporary file is overwritten before being removed.
+func editInTempFile(name string, data []byte, stdin io.Reader, stdout, stderr io.Writer) ([]byte, error) {
+	dir, err := os.MkdirTemp(tempDir(), "multikey-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, name)
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, fmt.Errorf("could not write temporary file: %s", err)
+	}
+	defer shred(tmp)
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{defaultEditor}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], tmp)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor failed, leaving file unchanged: %s", err)
+	}
+	return os.ReadFile(tmp)
+}
+
+// tempDir returns the directory to keep decrypted files in, preferring tmpfs
+func tempDir() string {
+	if info, err := os.Stat(tmpfsDir); err == nil && info.IsDir() {
+		return tmpfsDir
+	}
+	return os.TempDir()
+}
+
+// shred overwrites a file with zeros before removing it. This is best
+// effort: editors may have written copies elsewhere, and file systems
+// may not overwrite in place.
+func shred(path string) {
+	if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		if info, err := f.Stat(); err == nil {
+			f.Write(make([]byte, info.Size()))
+			f.Sync()
+		}
+		f.Close()
+	}
+	os.Remove(path)
+}
+
+// replaceFile atomically replaces the contents of a file, keeping its permissions
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setEditor points $EDITOR at a shell script with the given body, which
+// receives the path of the file to edit as $1
+func setEditor(t *testing.T, body string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor scripts require a POSIX shell")
+	}
+	script := filepath.Join(t.TempDir(), "editor.sh")
+	assert.Nil(t, os.WriteFile(script, []byte("#!/bin/sh\n"+body+"\n"), 0700))
+	t.Setenv("EDITOR", script)
+}
+
+// encryptedFile writes a secret encrypted for alice, bob and carol with
+// a threshold of 2 to a temporary file and returns its path
+func encryptedFile(t *testing.T, secret string) string {
+	path := filepath.Join(t.TempDir(), "secret.mk")
+	code, _, stderr := runCLI(t, []byte(secret), "encrypt", "-r", "testdata/keys", "-require", "2", "-out", path)
+	assert.Equal(t, exitOK, code, stderr)
+	return path
+}
+
+func TestEdit(t *testing.T) {
+	path := encryptedFile(t, "old value\n")
+	seen := filepath.Join(t.TempDir(), "seen")
+	setEditor(t, `echo "$1" > `+seen+` && printf 'new value\n' > "$1"`)
+
+	code, _, stderr := runCLI(t, nil, "edit", "-k", "testdata/keys/alice.pem", "-k", "testdata/keys/bob.pem", "-r", "testdata/keys", path)
+	assert.Equal(t, exitOK, code, stderr)
+
+	// the decrypted temporary file is gone
+	tmp, err := os.ReadFile(seen)
+	assert.Nil(t, err)
+	_, err = os.Stat(string(tmp[:len(tmp)-1]))
+	assert.True(t, os.IsNotExist(err))
+
+	// recipients, threshold and context are kept
+	_, out, _ := runCLI(t, nil, "inspect", "-in", path)
+	golden, err := os.ReadFile(filepath.Join("testdata", "inspect.golden"))
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Replace(string(golden), "keys:", "context:   bound\nkeys:", 1), out)
+
+	code, plain, _ := runCLI(t, nil, "decrypt", "-k", "testdata/keys/carol.pem", "-k", "testdata/keys/bob.pem", "-in", path)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "new value\n", plain)
+}
+
+func TestEditLeavesFileUnchanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		editor string
+		args   []string
+		code   int
+	}{
+		{
+			name:   "no changes",
+			editor: "true",
+			args:   []string{"-k", "testdata/keys"},
+			code:   exitOK,
+		},
+		{
+			name:   "editor fails",
+			editor: `printf 'new value\n' > "$1"; exit 1`,
+			args:   []string{"-k", "testdata/keys"},
+			code:   exitError,
+		},
+		{
+			name:   "emptied",
+			editor: `: > "$1"`,
+			args:   []string{"-k", "testdata/keys"},
+			code:   exitError,
+		},
+		{
+			name:   "recipient public key missing",
+			editor: `printf 'new value\n' > "$1"`,
+			args:   []string{"-k", "testdata/keys/alice.pem", "-k", "testdata/keys/bob.pem"},
+			code:   exitError,
+		},
+		{
+			name:   "too few keys",
+			editor: `printf 'new value\n' > "$1"`,
+			args:   []string{"-k", "testdata/keys/alice.pem", "-r", "testdata/keys"},
+			code:   exitInsufficientKeys,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := encryptedFile(t, "old value\n")
+			before, err := os.ReadFile(path)
+			assert.Nil(t, err)
+
+			setEditor(t, test.editor)
+			code, _, _ := runCLI(t, nil, append(append([]string{"edit"}, test.args...), path)...)
+			assert.Equal(t, test.code, code)
+
+			after, err := os.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Equal(t, string(before), string(after))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/dotenv"
+)
+
+func runExec(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("exec", "-secrets FILE -k KEY|DIR [-k ...] [-signer KEY|DIR|NAME ...] [-keyring FILE] [-only NAME ...] [-allow-expired] -- COMMAND [ARGS ...]", stderr)
+	secrets := fs.String("secrets", "", "dotenv file holding the variables to set, either with encrypted values or encrypted as a whole")
+	var keyPaths, signers, only listFlag
+	fs.Var(&keyPaths, "k", "private key file, or directory of *"+privKeyExt+" files (repeatable)")
+	fs.Var(&keyPaths, "key", "same as -k")
+	fs.Var(&signers, "signer", "require the secrets file to be signed by this public key file, directory of *"+pubKeyExt+" files, or key or group of the keyring (repeatable)")
+	keyringPath := fs.String("keyring", "", keyringFlagUsage+", to name -signer keys after")
+	fs.Var(&only, "only", "only pass the named variables of the secrets file to the command (repeatable)")
+	allowExpired := fs.Bool("allow-expired", false, "decrypt the secrets file even if it has expired")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *secrets == "" {
+		return usagef(fs, "a secrets file is required")
+	}
+	if len(keyPaths) == 0 {
+		return usagef(fs, "at least one key is required")
+	}
+	if fs.NArg() == 0 {
+		return usagef(fs, "a command is required")
+	}
+
+	privs, err := loadPrivateKeys(keyPaths)
+	if err != nil {
+		return err
+	}
+	ring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	opts, err := decryptOptions(signers, ring, keyringFile(*keyringPath))
+	if err != nil {
+		return err
+	}
+	enc, err := os.ReadFile(*secrets)
+	if err != nil {
+		return err
+	}
+	if opts.Context, err = secretContext(*secrets); err != nil {
+		return err
+	}
+	opts.AllowExpired = *allowExpired
+	plain, err := decryptEnvFile(enc, privs, opts)
+	if err != nil {
+		return classify(explainExpired(explainContext(err, opts.Context)))
+	}
+	vars, err := dotenv.Parse(plain)
+	if err != nil {
+		return &codedError{code: exitMalformedInput, err: fmt.Errorf("%s: %s", *secrets, err)}
+	}
+	vars, err = allowVariables(vars, only)
+	if err != nil {
+		return err
+	}
+	env := os.Environ()
+	for _, v := range vars {
+		env = append(env, v.Name+"="+v.Value)
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	return runForwardingSignals(cmd)
+}
+
+// decryptEnvFile decrypts either an encrypted dotenv file, or a dotenv
+// file encrypted as a whole, which may be bound to the context of the
+// given options and must be signed by the signers they trust
+func decryptEnvFile(enc []byte, privs []*rsa.PrivateKey, opts *multikey.DecryptOptions) ([]byte, error) {
+	if dotenv.IsEncrypted(enc) {
+		return dotenv.DecryptWithOptions(enc, privs, &dotenv.Options{Context: opts.Context, Signers: opts.Signers, SignerPolicy: opts.SignerPolicy, AllowExpired: opts.AllowExpired})
+	}
+	return multikey.DecryptWithOptions(string(enc), privs, opts)
+}
+
+// allowVariables filters variables down to the allowed names, or returns
+// all of them if no names are given. Every allowed name must be present.
+func allowVariables(vars []dotenv.Variable, allowed []string) ([]dotenv.Variable, error) {
+	if len(allowed) == 0 {
+		return vars, nil
+	}
+	allow := map[string]bool{}
+	for _, name := range allowed {
+		allow[name] = true
+	}
+	filtered := []dotenv.Variable{}
+	for _, v := range vars {
+		if allow[v.Name] {
+			filtered = append(filtered, v)
+			delete(allow, v.Name)
+		}
+	}
+	if len(allow) > 0 {
+		missing := []string{}
+		for _, name := range allowed {
+			if allow[name] {
+				missing = append(missing, name)
+			}
+		}
+		return nil, fmt.Errorf("variables not in secrets file: %s", strings.Join(missing, ", "))
+	}
+	return filtered, nil
+}
+
+// runForwardingSignals runs a command, relaying the signals this process
+// receives to it, and returns its exit status as a childExit error
+func runForwardingSignals(cmd *exec.Cmd) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start command: %s", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		return &childExit{code: exitStatus(cmd.ProcessState)}
+	}
+	return err
+}
+
+// childExit carries the non-zero exit status of a command run by exec,
+// which becomes the exit status of multikey itself
+type childExit struct {
+	code int
+}
+
+func (e *childExit) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.code)
+}
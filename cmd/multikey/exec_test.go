@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey/dotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEnvFile = `# application secrets
+DB_USER=app
+DB_PASSWORD="s3cret pass"
+API_TOKEN='tok$en'
+`
+
+// testChild is run in place of the tests when the test binary is started
+// by exec or git. The mode is "env" to print the named variables and exit
+// with the status given as first argument, "signal" to wait for an
+// interrupt, or "main" to act as multikey itself.
+func testChild(mode string, args []string) int {
+	switch mode {
+	case "main":
+		return run(args, os.Stdin, os.Stdout, os.Stderr)
+	case "env":
+		code, _ := strconv.Atoi(args[0])
+		for _, name := range args[1:] {
+			if v, ok := os.LookupEnv(name); ok {
+				fmt.Printf("%s=%s\n", name, v)
+			} else {
+				fmt.Printf("%s unset\n", name)
+			}
+		}
+		return code
+	case "signal":
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt)
+		fmt.Println("ready")
+		<-sigs
+		fmt.Println("interrupted")
+		return 7
+	}
+	return 1
+}
+
+// encryptedEnvFile writes testEnvFile encrypted for alice, bob and carol
+// with a threshold of 2 to a temporary file and returns its path
+func encryptedEnvFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "app.env.mk")
+	code, _, stderr := runCLI(t, []byte(testEnvFile), "encrypt", "-r", "testdata/keys", "-require", "2", "-out", path)
+	assert.Equal(t, exitOK, code, stderr)
+	return path
+}
+
+func TestExec(t *testing.T) {
+	secrets := encryptedEnvFile(t)
+	t.Setenv(testChildEnv, "env")
+	t.Setenv("INHERITED", "yes")
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			name:   "all variables",
+			args:   []string{"-k", "testdata/keys", "--", os.Args[0], "0", "DB_USER", "DB_PASSWORD", "API_TOKEN", "INHERITED"},
+			code:   0,
+			stdout: "DB_USER=app\nDB_PASSWORD=s3cret pass\nAPI_TOKEN=tok$en\nINHERITED=yes\n",
+		},
+		{
+			name:   "allow listed variables",
+			args:   []string{"-k", "testdata/keys", "-only", "DB_USER", "--", os.Args[0], "0", "DB_USER", "DB_PASSWORD", "API_TOKEN"},
+			code:   0,
+			stdout: "DB_USER=app\nDB_PASSWORD unset\nAPI_TOKEN unset\n",
+		},
+		{
+			name:   "exit status is forwarded",
+			args:   []string{"-k", "testdata/keys", "--", os.Args[0], "42"},
+			code:   42,
+			stdout: "",
+		},
+		{
+			name: "allow listed variable missing",
+			args: []string{"-k", "testdata/keys", "-only", "NOPE", "--", os.Args[0], "0"},
+			code: exitError,
+		},
+		{
+			name: "too few keys",
+			args: []string{"-k", "testdata/keys/alice.pem", "--", os.Args[0], "0"},
+			code: exitInsufficientKeys,
+		},
+		{
+			name: "no command",
+			args: []string{"-k", "testdata/keys"},
+			code: exitUsage,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"exec", "-secrets", secrets}, test.args...)
+			code, stdout, stderr := runCLI(t, nil, args...)
+			assert.Equal(t, test.code, code, stderr)
+			assert.Equal(t, test.stdout, stdout)
+		})
+	}
+}
+
+func TestExecEncryptedDotenv(t *testing.T) {
+	pubs := loadCLITestKeys(t)
+	enc, err := dotenv.Encrypt([]byte(testEnvFile), pubs, 2, nil)
+	assert.Nil(t, err)
+	secrets := filepath.Join(t.TempDir(), "app.env")
+	assert.Nil(t, os.WriteFile(secrets, enc, 0644))
+	t.Setenv(testChildEnv, "env")
+
+	code, stdout, stderr := runCLI(t, nil, "exec", "-secrets", secrets, "-k", "testdata/keys", "--", os.Args[0], "0", "DB_PASSWORD")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "DB_PASSWORD=s3cret pass\n", stdout)
+
+	code, _, _ = runCLI(t, nil, "exec", "-secrets", secrets, "-k", "testdata/keys/alice.pem", "--", os.Args[0], "0")
+	assert.Equal(t, exitInsufficientKeys, code)
+}
+
+func TestExecSigner(t *testing.T) {
+	pubs := loadCLITestKeys(t)
+	alice, err := loadSigner("testdata/keys/alice.pem")
+	assert.Nil(t, err)
+	enc, err := dotenv.Encrypt([]byte(testEnvFile), pubs, 2, &dotenv.Options{Signer: alice})
+	assert.Nil(t, err)
+	signed := filepath.Join(t.TempDir(), "app.env")
+	assert.Nil(t, os.WriteFile(signed, enc, 0644))
+	unsigned := encryptedEnvFile(t)
+	t.Setenv(testChildEnv, "env")
+
+	tests := []struct {
+		name    string
+		secrets string
+		signer  string
+		code    int
+	}{
+		{name: "trusted signer", secrets: signed, signer: "testdata/keys/alice.pub", code: exitOK},
+		{name: "untrusted signer", secrets: signed, signer: "testdata/keys/bob.pub", code: exitPolicyViolation},
+		{name: "unsigned", secrets: unsigned, signer: "testdata/keys/alice.pub", code: exitPolicyViolation},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, nil, "exec", "-secrets", test.secrets, "-k", "testdata/keys", "-signer", test.signer, "--", os.Args[0], "0", "DB_USER")
+			assert.Equal(t, test.code, code, stderr)
+			if test.code == exitOK {
+				assert.Equal(t, "DB_USER=app\n", stdout)
+			}
+		})
+	}
+}
+
+// loadCLITestKeys loads the public keys of the CLI's test key pairs
+func loadCLITestKeys(t *testing.T) []*rsa.PublicKey {
+	pubs, err := loadPublicKeys([]string{"testdata/keys"})
+	assert.Nil(t, err)
+	return pubs
+}
+
+func TestExecMalformedEnvFile(t *testing.T) {
+	secrets := filepath.Join(t.TempDir(), "bad.env.mk")
+	code, _, _ := runCLI(t, []byte("NOT A DOTENV FILE\n"), "encrypt", "-r", "testdata/keys", "-out", secrets)
+	assert.Equal(t, exitOK, code)
+
+	code, _, stderr := runCLI(t, nil, "exec", "-secrets", secrets, "-k", "testdata/keys", "--", "true")
+	assert.Equal(t, exitMalformedInput, code)
+	assert.True(t, strings.Contains(stderr, "line 1"), stderr)
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecForwardsSignals(t *testing.T) {
+	secrets := encryptedEnvFile(t)
+	t.Setenv(testChildEnv, "signal")
+
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+
+	codes := make(chan int)
+	go func() {
+		defer w.Close()
+		var stderr bytes.Buffer
+		codes <- run([]string{"exec", "-secrets", secrets, "-k", "testdata/keys", "--", os.Args[0]}, nil, w, &stderr)
+	}()
+
+	out := bufio.NewReader(r)
+	line, err := out.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "ready\n", line)
+
+	// exec relays the interrupt rather than dying of it
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	line, err = out.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "interrupted\n", line)
+	assert.Equal(t, 7, <-codes)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adrianosela/multikey"
+)
+
+// Files are encrypted in git through a filter driver named "multikey",
+// configured by git-setup. The filter finds its recipients, threshold,
+// private keys and the key to sign files with, if any, in the git config:
+//
+//	[multikey]
+//		recipient = keys/alice.pub
+//		recipient = keys/bob.pub
+//		require = 2
+//		key = ~/.multikey/alice.pem
+//		sign = ~/.multikey/alice.pem
+const (
+	gitDriverName      = "multikey"
+	gitConfigRecipient = "multikey.recipient"
+	gitConfigRequire   = "multikey.require"
+	gitConfigKey       = "multikey.key"
+	gitConfigSign      = "multikey.sign"
+	gitAttributesFile  = ".gitattributes"
+)
+
+func runGitFilter(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("git-filter", "clean|smudge [PATH]", stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return usagef(fs, "expected clean or smudge, and optionally the path of the file")
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	switch fs.Arg(0) {
+	case "clean":
+		out, err = gitClean(fs.Arg(1), data, stderr)
+	case "smudge":
+		var context string
+		if context, err = gitContext(fs.Arg(1)); err == nil {
+			out = gitSmudge(fs.Arg(1), data, []string{context}, stderr)
+		}
+	default:
+		return usagef(fs, "unknown filter %q", fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+// gitClean encrypts a file as it is staged. Where the staged version of
+// the file can be decrypted, it is updated rather than re-encrypted, so
+// that unchanged files do not show as modified and changes diff well.
+// Files are signed with the configured key, if any.
+func gitClean(path string, data []byte, stderr io.Writer) ([]byte, error) {
+	if isEncrypted(data) {
+		return data, nil // could not be decrypted on checkout
+	}
+	recipients, err := gitConfigAll(gitConfigRecipient, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients are configured, run multikey git-setup")
+	}
+	require, err := gitRequire()
+	if err != nil {
+		return nil, err
+	}
+	pubs, err := loadPinnedKeys(recipients)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := gitSigner()
+	if err != nil {
+		return nil, err
+	}
+	opts := &multikey.EncryptOptions{Rand: randReader, Signer: signer}
+	if opts.Context, err = gitContext(path); err != nil {
+		return nil, err
+	}
+	var staged []byte
+	if path != "" {
+		// update the staged version of the file, if we can decrypt it
+		staged, err = exec.Command("git", "cat-file", "blob", ":"+path).Output()
+		if err == nil && isEncrypted(staged) {
+			if privs, err := gitPrivateKeys(); err == nil {
+				if enc, err := multikey.UpdateWithOptions(string(staged), data, privs, pubs, require, opts); err == nil {
+					warnUnsigned(path, staged, []byte(enc), signer, stderr)
+					return []byte(enc), nil
+				}
+			}
+		}
+	}
+	enc, err := multikey.EncryptWithOptions(data, pubs, require, opts)
+	if err != nil {
+		return nil, err
+	}
+	warnUnsigned(path, staged, []byte(enc), signer, stderr)
+	return []byte(enc), nil
+}
+
+// warnUnsigned warns when a signed version of a file is replaced by an
+// unsigned one, for lack of a configured key to sign it with, and reports
+// whether it did
+func warnUnsigned(path string, previous, replaced []byte, signer *rsa.PrivateKey, stderr io.Writer) bool {
+	if signer != nil || bytes.Equal(previous, replaced) {
+		return false
+	}
+	info, err := multikey.Inspect(string(previous))
+	if err != nil || info.Signer == "" {
+		return false
+	}
+	fmt.Fprintf(stderr, "multikey: warning: %s was signed by %s and is no longer signed, sign it by setting %s\n", path, info.Signer, gitConfigSign)
+	return true
+}
+
+// gitSmudge decrypts a file as it is checked out, with the first of the
+// given contexts its secret is bound to. Files which can not be decrypted
+// with the configured keys are checked out encrypted, so that checkouts
+// never fail for those without enough keys.
+func gitSmudge(path string, data []byte, contexts []string, stderr io.Writer) []byte {
+	if !isEncrypted(data) {
+		return data
+	}
+	privs, err := gitPrivateKeys()
+	if err != nil {
+		fmt.Fprintf(stderr, "multikey: leaving %s encrypted: %s\n", path, err)
+		return data
+	}
+	for i, context := range contexts {
+		plain, err := multikey.DecryptWithOptions(string(data), privs, &multikey.DecryptOptions{Context: context})
+		if err == nil {
+			return plain
+		}
+		if errors.Is(err, multikey.ErrAuthenticationFailed) && i < len(contexts)-1 {
+			continue
+		}
+		if !errors.Is(err, multikey.ErrInsufficientKeys) {
+			fmt.Fprintf(stderr, "multikey: leaving %s encrypted: %s\n", path, err)
+		}
+		break
+	}
+	return data
+}
+
+func runGitTextconv(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("git-textconv", "FILE", stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef(fs, "exactly one file must be given")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	contexts, err := textconvContexts(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(gitSmudge(fs.Arg(0), data, contexts, io.Discard))
+	return err
+}
+
+// gitContext returns the context the secret of a file given to a git
+// filter or merge driver is bound to: its path in the repository, which
+// git runs them from the top of
+func gitContext(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	return secretContext(path)
+}
+
+// textconvContexts returns the contexts the secret of a file given to
+// git-textconv may be bound to. Files of the work tree are bound to their
+// path, but git gives the versions of files it diffs from its objects as
+// temporary files named after them, which may be of any tracked file of
+// the same name.
+func textconvContexts(path string) ([]string, error) {
+	top, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(top, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return []string{filepath.ToSlash(rel)}, nil
+	}
+	files, err := gitOutput("ls-files", "--full-name", "--", top)
+	if err != nil {
+		return nil, err
+	}
+	contexts := []string{}
+	name := filepath.Base(path)
+	for _, f := range strings.Split(files, "\n") {
+		if base := filepath.Base(f); f != "" && (name == base || strings.HasSuffix(name, "_"+base)) {
+			contexts = append(contexts, f)
+		}
+	}
+	if len(contexts) == 0 {
+		contexts = append(contexts, "")
+	}
+	return contexts, nil
+}
+
+func runGitSetup(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("git-setup", "-r KEY|DIR [-r ...] [-require N] [-k KEY|DIR ...] [-sign KEY] [-merge-recipients union|intersection] PATTERN ...", stderr)
+	var recipients, keyPaths listFlag
+	fs.Var(&recipients, "r", "recipient public key file, or directory of *"+pubKeyExt+" files, within the repository (repeatable)")
+	fs.Var(&recipients, "recipient", "same as -r")
+	fs.Var(&keyPaths, "k", "private key file, or directory of *"+privKeyExt+" files, to decrypt files on checkout (repeatable)")
+	fs.Var(&keyPaths, "key", "same as -k")
+	require := fs.Int("require", 1, "number of recipient keys required to decrypt")
+	signKey := fs.String("sign", "", "private key file to sign committed and merged files with as their author")
+	command := fs.String("command", "multikey", "how git should invoke multikey")
+	mergeRecipients := fs.String("merge-recipients", recipientsUnion, "recipients of merged files whose base version is not encrypted, the "+recipientsUnion+" or "+recipientsIntersection+" of those of both sides")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usagef(fs, "at least one pattern of files to encrypt is required")
+	}
+	if len(recipients) == 0 {
+		return usagef(fs, "at least one recipient is required")
+	}
+	pubs, err := loadPinnedKeys(recipients)
+	if err != nil {
+		return err
+	}
+	if *require < 1 || *require > len(pubs) {
+		return usagef(fs, "-require must be between 1 and the number of recipients (%d)", len(pubs))
+	}
+	if *mergeRecipients != recipientsUnion && *mergeRecipients != recipientsIntersection {
+		return usagef(fs, "-merge-recipients must be %s or %s", recipientsUnion, recipientsIntersection)
+	}
+	if _, err := loadPrivateKeys(keyPaths); err != nil {
+		return err
+	}
+	if _, err := loadSigner(*signKey); err != nil {
+		return err
+	}
+	top, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+
+	// filters run from the top of the work tree, paths are stored
+	// relative to it when within it, and absolute otherwise
+	relRecipients, err := gitPaths(top, recipients)
+	if err != nil {
+		return err
+	}
+	absKeys, err := gitPaths("", keyPaths)
+	if err != nil {
+		return err
+	}
+	signKeys := []string{}
+	if *signKey != "" {
+		if signKeys, err = gitPaths("", []string{*signKey}); err != nil {
+			return err
+		}
+	}
+	cmd := shellQuote(*command)
+	settings := [][2]string{
+		{"filter." + gitDriverName + ".clean", cmd + " git-filter clean %f"},
+		{"filter." + gitDriverName + ".smudge", cmd + " git-filter smudge %f"},
+		{"filter." + gitDriverName + ".required", "true"},
+		{"diff." + gitDriverName + ".textconv", cmd + " git-textconv"},
+		{"merge." + gitDriverName + ".name", "merge of multikey encrypted files"},
+		{"merge." + gitDriverName + ".driver", cmd + " git-merge -recipients " + *mergeRecipients + " -marker-size %L %O %A %B %P"},
+		{gitConfigRequire, strconv.Itoa(*require)},
+	}
+	for _, s := range settings {
+		if _, err := gitOutput("config", s[0], s[1]); err != nil {
+			return err
+		}
+	}
+	if err := gitConfigReplaceAll(gitConfigRecipient, relRecipients); err != nil {
+		return err
+	}
+	if err := gitConfigReplaceAll(gitConfigKey, absKeys); err != nil {
+		return err
+	}
+	if err := gitConfigReplaceAll(gitConfigSign, signKeys); err != nil {
+		return err
+	}
+	if err := addGitAttributes(filepath.Join(top, gitAttributesFile), fs.Args()); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "configured %s, run \"git add --renormalize .\" to encrypt files already in the repository\n", gitAttributesFile)
+	return nil
+}
+
+// addGitAttributes routes files matching the given patterns through the
+// multikey filter, diff and merge drivers
+func addGitAttributes(path string, patterns []string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(string(existing), "\n")
+	out := string(existing)
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	for _, p := range patterns {
+		entry := fmt.Sprintf("%s filter=%s diff=%s merge=%s", p, gitDriverName, gitDriverName, gitDriverName)
+		found := false
+		for _, l := range lines {
+			if strings.TrimSpace(l) == entry {
+				found = true
+			}
+		}
+		if !found {
+			out += entry + "\n"
+		}
+	}
+	return os.WriteFile(path, []byte(out), 0644)
+}
+
+// gitRequire returns the configured threshold, 1 if not set
+func gitRequire() (int, error) {
+	values, err := gitConfigAll(gitConfigRequire, false)
+	if err != nil || len(values) == 0 {
+		return 1, err
+	}
+	require, err := strconv.Atoi(values[len(values)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", gitConfigRequire, err)
+	}
+	return require, nil
+}
+
+// gitPrivateKeys loads the private keys configured for the repository
+func gitPrivateKeys() ([]*rsa.PrivateKey, error) {
+	paths, err := gitConfigAll(gitConfigKey, true)
+	if err != nil {
+		return nil, err
+	}
+	return loadPrivateKeys(paths)
+}
+
+// gitSigner loads the private key configured to sign files with, if any
+func gitSigner() (*rsa.PrivateKey, error) {
+	paths, err := gitConfigAll(gitConfigSign, true)
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	return loadSigner(paths[len(paths)-1])
+}
+
+// gitConfigAll returns every value of a git config key, expanding ~ in
+// paths if path is set
+func gitConfigAll(key string, path bool) ([]string, error) {
+	args := []string{"config", "--get-all", key}
+	if path {
+		args = []string{"config", "--path", "--get-all", key}
+	}
+	out, err := exec.Command("git", args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return nil, nil // not set
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read git config %s: %s", key, err)
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+}
+
+// gitConfigReplaceAll sets the values of a multi-valued git config key
+func gitConfigReplaceAll(key string, values []string) error {
+	if err := exec.Command("git", "config", "--unset-all", key).Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 5 { // 5 is not set
+			return fmt.Errorf("could not unset git config %s: %s", key, err)
+		}
+	}
+	for _, v := range values {
+		if _, err := gitOutput("config", "--add", key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitOutput runs a git command, returning its trimmed output
+func gitOutput(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitPaths makes paths relative to top where they are within it, and
+// absolute otherwise
+func gitPaths(top string, paths []string) ([]string, error) {
+	out := []string{}
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		if top != "" {
+			if rel, err := filepath.Rel(top, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				abs = filepath.ToSlash(rel)
+			}
+		}
+		out = append(out, abs)
+	}
+	return out, nil
+}
+
+// isEncrypted reports whether data is a multikey encrypted secret
+func isEncrypted(data []byte) bool {
+	_, err := multikey.Inspect(string(data))
+	return err == nil
+}
+
+// shellQuote quotes a word for sh, as git runs filter commands with it
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_-./", c))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
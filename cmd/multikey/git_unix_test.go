@@ -0,0 +1,324 @@
+//go:build unix
+
+package main
+
+import (
+	"crypto/rsa"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// gitRepo creates a git repository holding the test recipients' public
+// keys under keys/, and changes into it for the duration of the test
+func gitRepo(t *testing.T) string {
+	keysDir, err := filepath.Abs(filepath.Join("testdata", "keys"))
+	assert.Nil(t, err)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	assert.Nil(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv(testChildEnv, "main") // git runs the test binary as multikey
+
+	git(t, "init", "-q")
+	git(t, "config", "user.email", "test@example.com")
+	git(t, "config", "user.name", "test")
+	assert.Nil(t, os.Mkdir("keys", 0755))
+	for _, name := range []string{"alice", "bob", "carol"} {
+		pub, err := os.ReadFile(filepath.Join(keysDir, name+pubKeyExt))
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(filepath.Join("keys", name+pubKeyExt), pub, 0644))
+	}
+	return keysDir
+}
+
+func git(t *testing.T, args ...string) string {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	assert.Nil(t, err, string(out))
+	return string(out)
+}
+
+func TestGitFilter(t *testing.T) {
+	keysDir := gitRepo(t)
+	code, _, stderr := runCLI(t, nil, "git-setup", "-r", "keys", "-require", "2",
+		"-k", filepath.Join(keysDir, "alice.pem"), "-k", filepath.Join(keysDir, "bob.pem"),
+		"-command", os.Args[0], "*.secret")
+	assert.Equal(t, exitOK, code, stderr)
+
+	attrs, err := os.ReadFile(gitAttributesFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "*.secret filter=multikey diff=multikey merge=multikey\n", string(attrs))
+	assert.Equal(t, "keys\n", git(t, "config", "--get-all", gitConfigRecipient))
+
+	// committed files are encrypted, the work tree keeps the plaintext
+	assert.Nil(t, os.WriteFile("app.secret", []byte("password=1\n"), 0644))
+	git(t, "add", ".")
+	git(t, "commit", "-q", "-m", "add secret")
+	committed := git(t, "cat-file", "blob", "HEAD:app.secret")
+	info, err := multikey.Inspect(committed)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, info.Threshold)
+	assert.Len(t, info.KeyIDs, 3)
+
+	// bound to their path, so that they are not decrypted elsewhere
+	assert.True(t, info.Bound)
+	code, out, stderr := runCLI(t, []byte(committed), "git-filter", "smudge", "other.secret")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, committed, out)
+	assert.Contains(t, stderr, "leaving other.secret encrypted")
+
+	// re-cleaning an unchanged file reuses the committed ciphertext
+	future := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes("app.secret", future, future))
+	assert.Equal(t, "", git(t, "status", "--porcelain"))
+	code, cleaned, stderr := runCLI(t, []byte("password=1\n"), "git-filter", "clean", "app.secret")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, committed, cleaned)
+
+	// diffs are of the plaintext
+	assert.Nil(t, os.WriteFile("app.secret", []byte("password=2\n"), 0644))
+	diff := git(t, "diff")
+	assert.Contains(t, diff, "-password=1\n+password=2\n")
+	blob := filepath.Join(t.TempDir(), "Ab12Cd_app.secret") // as git names them
+	assert.Nil(t, os.WriteFile(blob, []byte(committed), 0644))
+	code, out, stderr = runCLI(t, nil, "git-textconv", blob)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "password=1\n", out)
+
+	// checkouts decrypt with enough keys...
+	assert.Nil(t, os.Remove("app.secret"))
+	git(t, "checkout", "--", "app.secret")
+	plain, err := os.ReadFile("app.secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "password=1\n", string(plain))
+
+	// ...and leave files encrypted otherwise
+	git(t, "config", "--unset-all", gitConfigKey)
+	git(t, "config", gitConfigKey, filepath.Join(keysDir, "carol.pem"))
+	assert.Nil(t, os.Remove("app.secret"))
+	git(t, "checkout", "--", "app.secret")
+	enc, err := os.ReadFile("app.secret")
+	assert.Nil(t, err)
+	assert.Equal(t, committed, string(enc))
+	assert.Equal(t, "", git(t, "status", "--porcelain"))
+}
+
+func TestGitMerge(t *testing.T) {
+	keysDir := gitRepo(t)
+	code, _, stderr := runCLI(t, nil, "git-setup", "-r", "keys", "-require", "2",
+		"-k", filepath.Join(keysDir, "alice.pem"), "-k", filepath.Join(keysDir, "bob.pem"),
+		"-command", os.Args[0], "*.secret")
+	assert.Equal(t, exitOK, code, stderr)
+
+	assert.Nil(t, os.WriteFile("app.secret", []byte("a=1\nb=1\nc=1\n"), 0644))
+	git(t, "add", ".")
+	git(t, "commit", "-q", "-m", "base")
+	git(t, "branch", "other")
+
+	assert.Nil(t, os.WriteFile("app.secret", []byte("a=2\nb=1\nc=1\n"), 0644))
+	git(t, "commit", "-q", "-am", "ours")
+	git(t, "checkout", "-q", "other")
+	assert.Nil(t, os.WriteFile("app.secret", []byte("a=1\nb=1\nc=2\n"), 0644))
+	git(t, "commit", "-q", "-am", "theirs")
+	git(t, "checkout", "-q", "-")
+
+	// changes to different lines merge cleanly
+	git(t, "merge", "-q", "--no-edit", "other")
+	plain, err := os.ReadFile("app.secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "a=2\nb=1\nc=2\n", string(plain))
+	assert.True(t, isEncrypted([]byte(git(t, "cat-file", "blob", "HEAD:app.secret"))))
+
+	// conflicting changes leave conflict markers in the plaintext
+	assert.Nil(t, os.WriteFile("app.secret", []byte("a=2\nb=2\nc=2\n"), 0644))
+	git(t, "commit", "-q", "-am", "ours")
+	git(t, "checkout", "-q", "other")
+	assert.Nil(t, os.WriteFile("app.secret", []byte("a=1\nb=3\nc=2\n"), 0644))
+	git(t, "commit", "-q", "-am", "theirs")
+	git(t, "checkout", "-q", "-")
+	out, err := exec.Command("git", "merge", "--no-edit", "other").CombinedOutput()
+	assert.NotNil(t, err)
+	assert.Contains(t, string(out), "CONFLICT")
+	plain, err = os.ReadFile("app.secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "<<<<<<< ours\na=2\nb=2\n=======\na=1\nb=3\n>>>>>>> theirs\nc=2\n", string(plain))
+}
+
+func TestGitMergeRecipients(t *testing.T) {
+	keysDir := gitRepo(t)
+	git(t, "config", gitConfigRecipient, "keys")
+	git(t, "config", "--add", gitConfigKey, filepath.Join(keysDir, "alice.pem"))
+	git(t, "config", "--add", gitConfigKey, filepath.Join(keysDir, "bob.pem"))
+	pubs, err := loadPublicKeys([]string{"keys"})
+	assert.Nil(t, err)
+	alice, bob, carol := pubs[0], pubs[1], pubs[2]
+
+	encrypt := func(name, data string, pubs ...*rsa.PublicKey) string {
+		if len(pubs) == 0 {
+			assert.Nil(t, os.WriteFile(name, []byte(data), 0644))
+			return name
+		}
+		enc, err := multikey.Encrypt([]byte(data), pubs, 1)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(name, []byte(enc), 0644))
+		return name
+	}
+	tests := []struct {
+		name      string
+		mode      string
+		base      []*rsa.PublicKey // not encrypted if empty
+		theirs    string
+		expCode   int
+		expPlain  string
+		expKeyIDs []string
+	}{
+		{
+			name:      "union",
+			mode:      recipientsUnion,
+			theirs:    "a=1\nb=1\nc=2\n",
+			expCode:   exitOK,
+			expPlain:  "a=2\nb=1\nc=2\n",
+			expKeyIDs: []string{keys.GetFingerprint(alice), keys.GetFingerprint(bob), keys.GetFingerprint(carol)},
+		},
+		{
+			name:      "intersection",
+			mode:      recipientsIntersection,
+			theirs:    "a=1\nb=1\nc=2\n",
+			expCode:   exitOK,
+			expPlain:  "a=2\nb=1\nc=2\n",
+			expKeyIDs: []string{keys.GetFingerprint(bob)},
+		},
+		{
+			name:      "added and removed since base",
+			mode:      recipientsUnion,
+			base:      []*rsa.PublicKey{alice, bob},
+			theirs:    "a=1\nb=1\nc=2\n",
+			expCode:   exitOK,
+			expPlain:  "a=2\nb=1\nc=2\n",
+			expKeyIDs: []string{keys.GetFingerprint(bob), keys.GetFingerprint(carol)},
+		},
+		{
+			name:      "added and removed since base with intersection",
+			mode:      recipientsIntersection,
+			base:      []*rsa.PublicKey{alice, bob},
+			theirs:    "a=1\nb=1\nc=2\n",
+			expCode:   exitOK,
+			expPlain:  "a=2\nb=1\nc=2\n",
+			expKeyIDs: []string{keys.GetFingerprint(bob), keys.GetFingerprint(carol)},
+		},
+		{
+			name:      "conflict markers are encrypted",
+			mode:      recipientsUnion,
+			theirs:    "a=3\nb=1\nc=1\n",
+			expCode:   exitError,
+			expPlain:  "<<<<<<< ours\na=2\n=======\na=3\n>>>>>>> theirs\nb=1\nc=1\n",
+			expKeyIDs: []string{keys.GetFingerprint(alice), keys.GetFingerprint(bob), keys.GetFingerprint(carol)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := encrypt("base", "a=1\nb=1\nc=1\n", test.base...)
+			ours := encrypt("ours", "a=2\nb=1\nc=1\n", alice, bob)
+			theirs := encrypt("theirs", test.theirs, bob, carol)
+			code, _, stderr := runCLI(t, nil, "git-merge", "-recipients", test.mode, base, ours, theirs, "app.secret")
+			assert.Equal(t, test.expCode, code, stderr)
+
+			merged, err := os.ReadFile(ours)
+			assert.Nil(t, err)
+			info, err := multikey.Inspect(string(merged))
+			assert.Nil(t, err)
+			sort.Strings(test.expKeyIDs)
+			sort.Strings(info.KeyIDs)
+			assert.Equal(t, test.expKeyIDs, info.KeyIDs)
+			assert.Equal(t, 1, info.Threshold)
+			assert.True(t, info.Bound)
+			plain, err := multikey.DecryptWithOptions(string(merged), []*rsa.PrivateKey{loadCLITestPrivateKey(t, keysDir, "bob")}, &multikey.DecryptOptions{Context: "app.secret"})
+			assert.Nil(t, err)
+			assert.Equal(t, test.expPlain, string(plain))
+		})
+	}
+}
+
+func TestGitSign(t *testing.T) {
+	keysDir := gitRepo(t)
+	code, _, stderr := runCLI(t, nil, "git-setup", "-r", "keys", "-k", filepath.Join(keysDir, "alice.pem"),
+		"-sign", filepath.Join(keysDir, "alice.pem"), "-command", os.Args[0], "*.secret")
+	assert.Equal(t, exitOK, code, stderr)
+	alice := keys.GetFingerprint(&loadCLITestPrivateKey(t, keysDir, "alice").PublicKey)
+
+	// committed files are signed with the configured key
+	assert.Nil(t, os.WriteFile("app.secret", []byte("a=1\nb=1\nc=1\n"), 0644))
+	git(t, "add", ".")
+	git(t, "commit", "-q", "-m", "add secret")
+	committed := git(t, "cat-file", "blob", "HEAD:app.secret")
+	info, err := multikey.Inspect(committed)
+	assert.Nil(t, err)
+	assert.Equal(t, alice, info.Signer)
+
+	// and so are merged files
+	clean := func(name, data string) string {
+		code, enc, stderr := runCLI(t, []byte(data), "git-filter", "clean", "app.secret")
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Nil(t, os.WriteFile(name, []byte(enc), 0644))
+		return enc
+	}
+	assert.Nil(t, os.WriteFile("base", []byte(committed), 0644))
+	ours := clean("ours", "a=2\nb=1\nc=1\n")
+	clean("theirs", "a=1\nb=1\nc=2\n")
+	code, _, stderr = runCLI(t, nil, "git-merge", "base", "ours", "theirs", "app.secret")
+	assert.Equal(t, exitOK, code, stderr)
+	merged, err := os.ReadFile("ours")
+	assert.Nil(t, err)
+	info, err = multikey.Inspect(string(merged))
+	assert.Nil(t, err)
+	assert.Equal(t, alice, info.Signer)
+
+	// without a key to sign with, signed files lose their signature
+	git(t, "config", "--unset-all", gitConfigSign)
+	code, cleaned, stderr := runCLI(t, []byte("a=2\n"), "git-filter", "clean", "app.secret")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "app.secret was signed by "+alice+" and is no longer signed, sign it by setting "+gitConfigSign)
+	info, err = multikey.Inspect(cleaned)
+	assert.Nil(t, err)
+	assert.Equal(t, "", info.Signer)
+	assert.Nil(t, os.WriteFile("ours", []byte(ours), 0644))
+	code, _, stderr = runCLI(t, nil, "git-merge", "base", "ours", "theirs", "app.secret")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "app.secret was signed by "+alice+" and is no longer signed")
+}
+
+func TestGitFilterWithoutRecipients(t *testing.T) {
+	gitRepo(t)
+	code, _, stderr := runCLI(t, []byte("password=1\n"), "git-filter", "clean", "app.secret")
+	assert.Equal(t, exitError, code)
+	assert.True(t, strings.Contains(stderr, "no recipients are configured"), stderr)
+
+	// files which are not encrypted are checked out as is
+	code, out, _ := runCLI(t, []byte("not encrypted\n"), "git-filter", "smudge", "app.secret")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "not encrypted\n", out)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "multikey", shellQuote("multikey"))
+	assert.Equal(t, "/usr/local/bin/multikey", shellQuote("/usr/local/bin/multikey"))
+	assert.Equal(t, `'/tmp/my dir/it'\''s'`, shellQuote("/tmp/my dir/it's"))
+}
+
+func loadCLITestPrivateKey(t *testing.T, dir, name string) *rsa.PrivateKey {
+	privs, err := loadPrivateKeys([]string{filepath.Join(dir, name+privKeyExt)})
+	assert.Nil(t, err)
+	return privs[0]
+}
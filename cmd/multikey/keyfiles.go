@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adrianosela/multikey/keys"
+)
+
+// file extensions of key files looked up within directories
+const (
+	privKeyExt = ".pem"
+	pubKeyExt  = ".pub"
+)
+
+// loadPublicKeys loads recipient public keys from the given paths. A path
+// may be a public or private key file, or a directory in which case every
+// *.pub file within it is loaded. Keys are deduplicated by fingerprint.
+func loadPublicKeys(paths []string) ([]*rsa.PublicKey, error) {
+	files, err := expandKeyPaths(paths, pubKeyExt)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	pubs := []*rsa.PublicKey{}
+	for _, f := range files {
+		pub, err := readPublicKey(f)
+		if err != nil {
+			return nil, err
+		}
+		if fp := keys.GetFingerprint(pub); !seen[fp] {
+			seen[fp] = true
+			pubs = append(pubs, pub)
+		}
+	}
+	return pubs, nil
+}
+
+// loadPrivateKeys loads private keys from the given paths. A path may be
+// a private key file, or a directory in which case every *.pem file
+// within it is loaded.
+func loadPrivateKeys(paths []string) ([]*rsa.PrivateKey, error) {
+	files, err := expandKeyPaths(paths, privKeyExt)
+	if err != nil {
+		return nil, err
+	}
+	privs := []*rsa.PrivateKey{}
+	for _, f := range files {
+		raw, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		priv, err := keys.DecodePrivKeyPEM(raw)
+		if err != nil {
+			return nil, &codedError{code: exitMalformedInput, err: fmt.Errorf("%s: %s", f, err)}
+		}
+		privs = append(privs, priv)
+	}
+	return privs, nil
+}
+
+// readPublicKey reads a public key from a file holding either a
+// public key or a private key
+func readPublicKey(path string) (*rsa.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePublicKey(path, raw)
+}
+
+func parsePublicKey(name string, raw []byte) (*rsa.PublicKey, error) {
+	if pub, err := keys.DecodePubKeyPEM(raw); err == nil {
+		return pub, nil
+	}
+	if priv, err := keys.DecodePrivKeyPEM(raw); err == nil {
+		return &priv.PublicKey, nil
+	}
+	return nil, &codedError{code: exitMalformedInput, err: fmt.Errorf("%s: not an RSA public or private key", name)}
+}
+
+// expandKeyPaths replaces directories in paths with the files in them
+// which have the given extension, in lexical order
+func expandKeyPaths(paths []string, ext string) ([]string, error) {
+	files := []string{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		matched := []string{}
+		for _, e := range entries {
+			if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ext) {
+				matched = append(matched, filepath.Join(p, e.Name()))
+			}
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("%s: no %s key files in directory", p, ext)
+		}
+		sort.Strings(matched)
+		files = append(files, matched...)
+	}
+	return files, nil
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keyring"
+	"github.com/adrianosela/multikey/keys"
+)
+
+// envKeyring names the environment variable holding the default keyring file
+const envKeyring = "MULTIKEY_KEYRING"
+
+const keyringFlagUsage = "keyring file naming keys and groups (default $" + envKeyring + ")"
+
+func runKeyring(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "add":
+			return runKeyringAdd(args[1:], stdout, stderr)
+		case "list":
+			return runKeyringList(args[1:], stdout, stderr)
+		case "revoke":
+			return runKeyringRevoke(args[1:], stdout, stderr)
+		case "verify":
+			return runKeyringVerify(args[1:], stdout, stderr)
+		case "admins":
+			return runKeyringAdmins(args[1:], stdout, stderr)
+		case "sign":
+			return runKeyringSign(args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprint(stderr, "usage: multikey keyring add|list|revoke|verify|admins|sign [flags]\n")
+	return &usageError{msg: "expected add, list, revoke, verify, admins or sign"}
+}
+
+func runKeyringAdd(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keyring add", "[-keyring FILE] -name NAME [-owner OWNER] [-group GROUP ...] [-tag TAG ...] [-created DATE] [-expires DATE] KEY", stderr)
+	path := fs.String("keyring", "", keyringFlagUsage+", created if it does not exist")
+	name := fs.String("name", "", "name of the key")
+	owner := fs.String("owner", "", "owner of the key")
+	var groups, tags listFlag
+	fs.Var(&groups, "group", "group the key belongs to (repeatable)")
+	fs.Var(&tags, "tag", "tag of the key (repeatable)")
+	created := fs.String("created", "", "date the key was created, as YYYY-MM-DD (default today)")
+	expires := fs.String("expires", "", "date the key expires, as YYYY-MM-DD")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef(fs, "exactly one public key file is required")
+	}
+	if *path == "" {
+		*path = os.Getenv(envKeyring)
+	}
+	if *path == "" {
+		return usagef(fs, "a keyring file is required, give it with -keyring or $%s", envKeyring)
+	}
+	if *name == "" {
+		return usagef(fs, "-name is required")
+	}
+	k := &keyring.Key{Name: *name, Owner: *owner, Groups: groups, Tags: tags, Created: time.Now().UTC().Truncate(24 * time.Hour)}
+	var err error
+	if *created != "" {
+		if k.Created, err = time.Parse(keyring.DateFormat, *created); err != nil {
+			return usagef(fs, "-created must be formatted as YYYY-MM-DD")
+		}
+	}
+	if *expires != "" {
+		if k.Expires, err = time.Parse(keyring.DateFormat, *expires); err != nil {
+			return usagef(fs, "-expires must be formatted as YYYY-MM-DD")
+		}
+	}
+	if k.PublicKey, err = readPublicKey(fs.Arg(0)); err != nil {
+		return err
+	}
+
+	ring, err := keyring.Load(*path)
+	if errors.Is(err, iofs.ErrNotExist) {
+		ring, err = keyring.New(), nil
+	}
+	if err != nil {
+		return &codedError{code: exitMalformedInput, err: err}
+	}
+	if err := ring.Add(k); err != nil {
+		return err
+	}
+	data, err := ring.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*path, data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "added %s  %s\n", k.Fingerprint(), k.Name)
+	printSignatureStatus(stdout, ring)
+	return nil
+}
+
+func runKeyringList(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keyring list", "[-keyring FILE] [NAME|GROUP ...]", stderr)
+	path := fs.String("keyring", "", keyringFlagUsage)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	ring, err := loadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	if ring == nil {
+		return usagef(fs, "a keyring file is required, give it with -keyring or $%s", envKeyring)
+	}
+	ks := ring.Keys()
+	if fs.NArg() > 0 {
+		if ks, err = ring.Resolve(fs.Args()...); err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFINGERPRINT\tOWNER\tGROUPS\tTAGS\tCREATED\tEXPIRES\tSTATUS")
+	now := time.Now()
+	for _, k := range ks {
+		status := "valid"
+		if rv, ok := ring.Revocation(k.Fingerprint()); ok {
+			status = fmt.Sprintf("revoked on %s by %s: %s", formatDate(rv.Date), rv.By, rv.Reason)
+		} else if k.Expired(now) {
+			status = "expired"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", k.Name, k.Fingerprint(), orDash(k.Owner),
+			orDash(strings.Join(k.Groups, ",")), orDash(strings.Join(k.Tags, ",")), formatDate(k.Created), formatDate(k.Expires), status)
+	}
+	return tw.Flush()
+}
+
+func runKeyringRevoke(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keyring revoke", "[-keyring FILE] -k KEY -reason REASON [-date DATE] NAME|FINGERPRINT", stderr)
+	path := fs.String("keyring", "", keyringFlagUsage)
+	keyPath := fs.String("k", "", "private key of an admin of the keyring, or of the revoked key itself, to sign the revocation with")
+	reason := fs.String("reason", "", "why the key is revoked")
+	date := fs.String("date", "", "date the key is revoked from, as YYYY-MM-DD (default today)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef(fs, "exactly one key to revoke is required")
+	}
+	if *keyPath == "" || *reason == "" {
+		return usagef(fs, "-k and -reason are required")
+	}
+	revokedOn := time.Now().UTC().Truncate(24 * time.Hour)
+	if *date != "" {
+		var err error
+		if revokedOn, err = time.Parse(keyring.DateFormat, *date); err != nil {
+			return usagef(fs, "-date must be formatted as YYYY-MM-DD")
+		}
+	}
+	if *path == "" {
+		*path = os.Getenv(envKeyring)
+	}
+	ring, err := loadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	if ring == nil {
+		return usagef(fs, "a keyring file is required, give it with -keyring or $%s", envKeyring)
+	}
+	privs, err := loadPrivateKeys([]string{*keyPath})
+	if err != nil {
+		return err
+	}
+	signer, ok := ring.Lookup(keys.GetFingerprint(&privs[0].PublicKey))
+	if !ok {
+		return fmt.Errorf("%s: signing key is not in the keyring", *keyPath)
+	}
+	fingerprint := fs.Arg(0)
+	if k, ok := ring.Key(fingerprint); ok {
+		fingerprint = k.Fingerprint()
+	}
+	rv, err := keyring.NewRevocation(fingerprint, *reason, revokedOn, signer.Name, privs[0])
+	if err != nil {
+		return err
+	}
+	if err := ring.Revoke(rv); err != nil {
+		return err
+	}
+	data, err := ring.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(*path, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "revoked %s  %s\n", fingerprint, ring.Name(fingerprint))
+	printSignatureStatus(stdout, ring)
+	return nil
+}
+
+func runKeyringVerify(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keyring verify", "[-keyring FILE] [-previous FILE]", stderr)
+	path := fs.String("keyring", "", keyringFlagUsage)
+	previousPath := fs.String("previous", "", "previous version of the keyring, whose revocations it must keep")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	ring, err := loadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	if ring == nil {
+		return usagef(fs, "a keyring file is required, give it with -keyring or $%s", envKeyring)
+	}
+	if *previousPath != "" {
+		previous, err := readKeyring(*previousPath)
+		if err != nil {
+			return err
+		}
+		dropped := ring.Dropped(previous)
+		for _, rv := range dropped {
+			fmt.Fprintf(stdout, "dropped revocation of %s  %s\n", rv.Fingerprint, previous.Name(rv.Fingerprint))
+		}
+		if len(dropped) > 0 {
+			return &codedError{code: exitPolicyViolation, err: fmt.Errorf("%d revocations were dropped", len(dropped))}
+		}
+		if err := ring.VerifyUpdate(previous); err != nil {
+			return &codedError{code: exitPolicyViolation, err: err}
+		}
+	}
+	printSignatureStatus(stdout, ring)
+	if err := ring.CheckSigned(); err != nil {
+		return &codedError{code: exitPolicyViolation, err: err}
+	}
+	fmt.Fprintf(stdout, "%d keys, %d revocations verified\n", len(ring.Keys()), len(ring.Revocations()))
+	return nil
+}
+
+func runKeyringAdmins(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keyring admins", "[-keyring FILE] -require N NAME ...", stderr)
+	path := fs.String("keyring", "", keyringFlagUsage)
+	require := fs.Int("require", 1, "number of admins who must sign the keyring")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *path == "" {
+		*path = os.Getenv(envKeyring)
+	}
+	ring, err := loadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	if ring == nil {
+		return usagef(fs, "a keyring file is required, give it with -keyring or $%s", envKeyring)
+	}
+	if err := ring.SetAdmins(fs.Args(), *require); err != nil {
+		return err
+	}
+	data, err := ring.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(*path, data); err != nil {
+		return err
+	}
+	printSignatureStatus(stdout, ring)
+	return nil
+}
+
+func runKeyringSign(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keyring sign", "[-keyring FILE] -k KEY", stderr)
+	path := fs.String("keyring", "", keyringFlagUsage)
+	keyPath := fs.String("k", "", "private key of an admin of the keyring")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	if *keyPath == "" {
+		return usagef(fs, "-k is required")
+	}
+	if *path == "" {
+		*path = os.Getenv(envKeyring)
+	}
+	ring, err := loadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	if ring == nil {
+		return usagef(fs, "a keyring file is required, give it with -keyring or $%s", envKeyring)
+	}
+	privs, err := loadPrivateKeys([]string{*keyPath})
+	if err != nil {
+		return err
+	}
+	if err := ring.Sign(privs[0]); err != nil {
+		return fmt.Errorf("%s: %s", *keyPath, err)
+	}
+	data, err := ring.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(*path, data); err != nil {
+		return err
+	}
+	printSignatureStatus(stdout, ring)
+	return nil
+}
+
+// printSignatureStatus describes how many of the admins of a keyring
+// have signed it, if it has admins
+func printSignatureStatus(w io.Writer, ring *keyring.Keyring) {
+	admins, require := ring.Admins()
+	if require == 0 {
+		return
+	}
+	signers := ring.Signers()
+	fmt.Fprintf(w, "signed by %d of %d required admins", len(signers), require)
+	if len(signers) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(signers, ", "))
+	}
+	fmt.Fprintf(w, " of %s\n", strings.Join(admins, ", "))
+}
+
+// checkRecipients refuses to encrypt for revoked or expired keys of the
+// keyring, if any, unless forced to, in which case it only warns about them
+func checkRecipients(ring *keyring.Keyring, pubs []*rsa.PublicKey, force bool, stderr io.Writer) error {
+	if ring == nil {
+		return nil
+	}
+	err := ring.CheckRecipients(pubs, time.Now())
+	if err == nil {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("%s, encrypt for it anyway with -force", err)
+	}
+	fmt.Fprintf(stderr, "multikey: warning: %s\n", err)
+	return nil
+}
+
+// warnRevoked warns about the revoked keys amongst those given to decrypt
+// a secret
+func warnRevoked(ring *keyring.Keyring, privs []*rsa.PrivateKey, info *multikey.Info, stderr io.Writer) {
+	if ring == nil || info == nil {
+		return
+	}
+	recipients := map[string]bool{}
+	for _, id := range info.KeyIDs {
+		recipients[id] = true
+	}
+	for _, priv := range privs {
+		fp := keys.GetFingerprint(&priv.PublicKey)
+		if rv, ok := ring.Revocation(fp); ok && recipients[fp] {
+			fmt.Fprintf(stderr, "multikey: warning: decrypting with %s, which was revoked on %s: %s\n", ring.Name(fp), formatDate(rv.Date), rv.Reason)
+		}
+	}
+}
+
+// keyringFile returns the keyring file given by a -keyring flag, or
+// named by $MULTIKEY_KEYRING if the flag is empty
+func keyringFile(path string) string {
+	if path == "" {
+		return os.Getenv(envKeyring)
+	}
+	return path
+}
+
+// loadKeyring loads the keyring file at path, or named by $MULTIKEY_KEYRING
+// if path is empty, anchored to the admins pinned for it. It returns nil if
+// neither is given.
+func loadKeyring(path string) (*keyring.Keyring, error) {
+	path = keyringFile(path)
+	if path == "" {
+		return nil, nil
+	}
+	ring, err := readKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := anchorKeyring(ring, path); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// readKeyring loads the keyring file at path without anchoring it
+func readKeyring(path string) (*keyring.Keyring, error) {
+	ring, err := keyring.Load(path)
+	if errors.Is(err, iofs.ErrNotExist) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &codedError{code: exitMalformedInput, err: err}
+	}
+	return ring, nil
+}
+
+// anchorKeyring anchors a keyring to the admins pinned for it, so that
+// whoever can change the file can not replace them. The admins of a keyring
+// are pinned the first time it is found signed by them, and changes of its
+// admins are followed once signed by the pinned ones.
+func anchorKeyring(ring *keyring.Keyring, path string) error {
+	name, err := adminsPinName(path)
+	if err != nil {
+		return err
+	}
+	store, err := loadPins()
+	if err != nil {
+		return err
+	}
+	pinned, pinnedRequire, _ := store.Admins(name)
+	ring.Anchor(pinned, pinnedRequire)
+	_, require := ring.Admins()
+	if require == 0 || ring.CheckSigned() != nil {
+		return nil
+	}
+	admins := ring.AdminFingerprints()
+	sort.Strings(pinned)
+	sort.Strings(admins)
+	if require != pinnedRequire || strings.Join(admins, ",") != strings.Join(pinned, ",") {
+		store.AcceptAdmins(name, admins, require)
+	}
+	return store.Save()
+}
+
+// adminsPinName returns the name the admins of the keyring at path are
+// pinned under, which no recipient is pinned under
+func adminsPinName(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return abs + " admins", nil
+}
+
+// recipient is a public key, along with the argument it was given as and
+// the name to pin it under: the absolute path of its file, or that of the
+// keyring it was resolved through followed by its name in it
+type recipient struct {
+	ref  string
+	name string
+	pub  *rsa.PublicKey
+}
+
+// loadRecipients loads recipient public keys as loadPublicKeys does,
+// except that names of keys and groups in the keyring at ringPath, if
+// any, are resolved through it
+func loadRecipients(refs []string, ring *keyring.Keyring, ringPath string) ([]recipient, error) {
+	seen := map[string]bool{}
+	recipients := []recipient{}
+	add := func(ref, name string, pub *rsa.PublicKey) {
+		if fp := keys.GetFingerprint(pub); !seen[fp] {
+			seen[fp] = true
+			recipients = append(recipients, recipient{ref: ref, name: name, pub: pub})
+		}
+	}
+	for _, ref := range refs {
+		if ring != nil && ring.Has(ref) {
+			// revoked and expired keys are refused by checkRecipients
+			ks, err := ring.Resolve(ref)
+			if err != nil {
+				return nil, err
+			}
+			abs, err := filepath.Abs(ringPath)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range ks {
+				add("-keyring "+ringPath+" "+k.Name, abs+"#"+k.Name, k.PublicKey)
+			}
+			continue
+		}
+		if _, err := os.Stat(ref); ring != nil && errors.Is(err, iofs.ErrNotExist) {
+			return nil, fmt.Errorf("%s is neither a key file nor named in the keyring", ref)
+		}
+		files, err := expandKeyPaths([]string{ref}, pubKeyExt)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			pub, err := readPublicKey(f)
+			if err != nil {
+				return nil, err
+			}
+			abs, err := filepath.Abs(f)
+			if err != nil {
+				return nil, err
+			}
+			add(f, abs, pub)
+		}
+	}
+	return recipients, nil
+}
+
+// publicKeys returns the public keys of recipients
+func publicKeys(recipients []recipient) []*rsa.PublicKey {
+	pubs := []*rsa.PublicKey{}
+	for _, r := range recipients {
+		pubs = append(pubs, r.pub)
+	}
+	return pubs
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(keyring.DateFormat)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
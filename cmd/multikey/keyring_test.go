@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringAdd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.yaml")
+	code, stdout, stderr := runCLI(t, nil, "keyring", "add", "-keyring", path, "-name", "alice", "-group", "dev,ops", "-expires", "2030-01-01", "testdata/keys/alice.pub")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "added "+keys.GetFingerprint(loadCLITestKeys(t)[0])+"  alice\n", stdout)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "bob", args: []string{"-name", "bob", "-group", "dev", "testdata/keys/bob.pub"}, code: exitOK},
+		{name: "same name", args: []string{"-name", "alice", "testdata/keys/carol.pub"}, code: exitError},
+		{name: "same key", args: []string{"-name", "alice2", "testdata/keys/alice.pem"}, code: exitError},
+		{name: "named like a group", args: []string{"-name", "dev", "testdata/keys/carol.pub"}, code: exitError},
+		{name: "no name", args: []string{"testdata/keys/carol.pub"}, code: exitUsage},
+		{name: "bad date", args: []string{"-name", "carol", "-expires", "soon", "testdata/keys/carol.pub"}, code: exitUsage},
+		{name: "not a key", args: []string{"-name", "carol", "testdata/secret.txt"}, code: exitMalformedInput},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, stderr := runCLI(t, nil, append([]string{"keyring", "add", "-keyring", path}, test.args...)...)
+			assert.Equal(t, test.code, code, stderr)
+		})
+	}
+
+	code, stdout, stderr = runCLI(t, nil, "keyring", "list", "-keyring", path, "dev")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Regexp(t, `(?s)^NAME .*\nalice .* dev,ops .* 2030-01-01 +valid\nbob .* dev .*\n$`, stdout)
+
+	code, _, _ = runCLI(t, nil, "keyring", "list", "-keyring", path, "ops", "mallory")
+	assert.Equal(t, exitError, code)
+	code, _, _ = runCLI(t, nil, "keyring", "remove")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestEncryptKeyring(t *testing.T) {
+	tests := []struct {
+		name       string
+		recipients []string
+		expKeys    []int
+		code       int
+	}{
+		{name: "group", recipients: []string{"ops"}, expKeys: []int{0, 2}, code: exitOK},
+		{name: "key and group", recipients: []string{"bob", "ops"}, expKeys: []int{1, 0, 2}, code: exitOK},
+		{name: "names and files", recipients: []string{"testdata/keys/carol.pub", "dev"}, expKeys: []int{2, 0, 1}, code: exitOK},
+		{name: "unknown name", recipients: []string{"ops", "mallory"}, code: exitError},
+	}
+	pubs := loadCLITestKeys(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := []string{"encrypt", "-keyring", "testdata/keyring.yaml"}
+			for _, r := range test.recipients {
+				args = append(args, "-r", r)
+			}
+			code, stdout, stderr := runCLI(t, []byte("secret"), args...)
+			assert.Equal(t, test.code, code, stderr)
+			if code != exitOK {
+				return
+			}
+			info, err := multikey.Inspect(stdout)
+			assert.Nil(t, err)
+			expIDs := []string{}
+			for _, i := range test.expKeys {
+				expIDs = append(expIDs, keys.GetFingerprint(pubs[i]))
+			}
+			assert.ElementsMatch(t, expIDs, info.KeyIDs)
+		})
+	}
+
+	// the keyring may also be given through the environment
+	os.Setenv(envKeyring, "testdata/keyring.yaml")
+	defer os.Unsetenv(envKeyring)
+	code, _, stderr := runCLI(t, []byte("secret"), "encrypt", "-r", "dev")
+	assert.Equal(t, exitOK, code, stderr)
+}
+
+func TestKeyringRevoke(t *testing.T) {
+	dir := t.TempDir()
+	path, previous := filepath.Join(dir, "keyring.yaml"), filepath.Join(dir, "previous.yaml")
+	data, err := os.ReadFile("testdata/keyring.yaml")
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+	assert.Nil(t, os.WriteFile(previous, data, 0644))
+	enc, err := multikey.Encrypt([]byte("secret"), loadCLITestKeys(t)[:2], 1)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "app.secret"), []byte(enc), 0644))
+
+	// only keys of the keyring may sign revocations
+	code, _, _ := runCLI(t, nil, "keyring", "revoke", "-keyring", path, "-k", "testdata/keys/alice.pem", "alice")
+	assert.Equal(t, exitUsage, code)
+	mallory, err := rsa.GenerateKey(rand.Reader, minKeyBits)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "mallory.pem"), keys.EncodePrivKeyPEM(mallory), 0600))
+	code, _, stderr := runCLI(t, nil, "keyring", "revoke", "-keyring", path, "-k", filepath.Join(dir, "mallory.pem"), "-reason", "stolen", "alice")
+	assert.Equal(t, exitError, code, stderr)
+	// nor, without admins, any key but the revoked key itself
+	code, _, stderr = runCLI(t, nil, "keyring", "revoke", "-keyring", path, "-k", "testdata/keys/bob.pem", "-reason", "stolen", "alice")
+	assert.Equal(t, exitError, code, stderr)
+	code, stdout, stderr := runCLI(t, nil, "keyring", "revoke", "-keyring", path, "-k", "testdata/keys/alice.pem", "-reason", "laptop stolen", "-date", "2024-06-01", "alice")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "revoked "+keys.GetFingerprint(loadCLITestKeys(t)[0])+"  alice\n", stdout)
+	code, stdout, _ = runCLI(t, nil, "keyring", "list", "-keyring", path, "alice")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "revoked on 2024-06-01 by alice: laptop stolen\n")
+
+	// revoked keys are refused as recipients unless forced
+	code, _, stderr = runCLI(t, []byte("secret"), "encrypt", "-keyring", path, "-r", "ops")
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "multikey encrypt: alice: key is revoked on 2024-06-01: laptop stolen, encrypt for it anyway with -force\n", stderr)
+	code, _, stderr = runCLI(t, []byte("secret"), "encrypt", "-keyring", path, "-r", "testdata/keys/alice.pub")
+	assert.Equal(t, exitError, code, stderr)
+	code, _, stderr = runCLI(t, []byte("secret"), "encrypt", "-keyring", path, "-r", "ops", "-force")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "multikey: warning: alice: key is revoked on 2024-06-01: laptop stolen\n", stderr)
+
+	// decrypting with a revoked key warns about it
+	code, stdout, stderr = runCLI(t, []byte(enc), "decrypt", "-keyring", path, "-k", "testdata/keys/alice.pem")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "secret", stdout)
+	assert.Equal(t, "multikey: warning: decrypting with alice, which was revoked on 2024-06-01: laptop stolen\n", stderr)
+	code, _, stderr = runCLI(t, []byte(enc), "decrypt", "-keyring", path, "-k", "testdata/keys/bob.pem")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Empty(t, stderr)
+
+	// scanning lists the secrets revoked keys can still help decrypt
+	code, stdout, stderr = runCLI(t, nil, "scan", "-keyring", path, dir)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Regexp(t, `\nFILE +OFFSET +THRESHOLD +REVOKED KEYS +EXPOSED\napp.secret +0 +1 +alice +yes\n$`, stdout)
+
+	// revocations can not be dropped unnoticed
+	code, stdout, _ = runCLI(t, nil, "keyring", "verify", "-keyring", path, "-previous", previous)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "3 keys, 1 revocations verified\n", stdout)
+	code, stdout, _ = runCLI(t, nil, "keyring", "verify", "-keyring", previous, "-previous", path)
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Equal(t, "dropped revocation of "+keys.GetFingerprint(loadCLITestKeys(t)[0])+"  alice\n", stdout)
+	revoked, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	tampered := strings.Replace(string(revoked), "reason: laptop stolen", "reason: mistake", 1)
+	assert.Nil(t, os.WriteFile(path, []byte(tampered), 0644))
+	code, _, _ = runCLI(t, nil, "keyring", "verify", "-keyring", path)
+	assert.Equal(t, exitMalformedInput, code)
+}
+
+func TestKeyringSign(t *testing.T) {
+	dir := t.TempDir()
+	path, previous := filepath.Join(dir, "keyring.yaml"), filepath.Join(dir, "previous.yaml")
+	data, err := os.ReadFile("testdata/keyring.yaml")
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+	encrypt := func() (int, string) {
+		code, _, stderr := runCLI(t, []byte("secret"), "encrypt", "-keyring", path, "-r", "dev")
+		return code, stderr
+	}
+
+	code, stdout, stderr := runCLI(t, nil, "keyring", "admins", "-keyring", path, "-require", "2", "alice", "bob")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "signed by 0 of 2 required admins of alice, bob\n", stdout)
+	code, stderr = encrypt()
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "multikey encrypt: keyring is not signed by enough admins: 0 of 2 required signatures\n", stderr)
+
+	// admins co-sign the keyring
+	code, stdout, stderr = runCLI(t, nil, "keyring", "sign", "-keyring", path, "-k", "testdata/keys/alice.pem")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "signed by 1 of 2 required admins (alice) of alice, bob\n", stdout)
+	code, _ = encrypt()
+	assert.Equal(t, exitError, code)
+	code, _, stderr = runCLI(t, nil, "keyring", "sign", "-keyring", path, "-k", "testdata/keys/carol.pem")
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "multikey keyring: testdata/keys/carol.pem: key is not an admin of the keyring\n", stderr)
+	code, stdout, stderr = runCLI(t, nil, "keyring", "sign", "-keyring", path, "-k", "testdata/keys/bob.pem")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "signed by 2 of 2 required admins (alice, bob) of alice, bob\n", stdout)
+	code, stderr = encrypt()
+	assert.Equal(t, exitOK, code, stderr)
+	code, stdout, _ = runCLI(t, nil, "keyring", "verify", "-keyring", path)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "signed by 2 of 2 required admins (alice, bob) of alice, bob\n3 keys, 0 revocations verified\n", stdout)
+	signed, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(previous, signed, 0644))
+
+	// carol makes herself the only admin, which the previous admins did not sign
+	code, _, stderr = runCLI(t, nil, "keyring", "admins", "-keyring", path, "carol")
+	assert.Equal(t, exitOK, code, stderr)
+	code, _, stderr = runCLI(t, nil, "keyring", "sign", "-keyring", path, "-k", "testdata/keys/carol.pem")
+	assert.Equal(t, exitOK, code, stderr)
+	code, _, stderr = runCLI(t, nil, "keyring", "verify", "-keyring", path)
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Equal(t, "multikey keyring: keyring is not signed by enough admins: 0 of 2 required signatures by its trusted admins\n", stderr)
+	code, _, stderr = runCLI(t, nil, "keyring", "verify", "-keyring", path, "-previous", previous)
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Equal(t, "multikey keyring: keyring is not signed by enough admins: 0 of 2 required signatures by the admins of the previous keyring\n", stderr)
+	code, stderr = encrypt()
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "multikey encrypt: keyring is not signed by enough admins: 0 of 2 required signatures by its trusted admins\n", stderr)
+
+	// unless the new admins are accepted
+	abs, err := filepath.Abs(path)
+	assert.Nil(t, err)
+	code, stdout, stderr = runCLI(t, nil, "pins", "accept", "-keyring", path, "-admins")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "pinned 1 of carol  "+abs+" admins\n", stdout)
+	code, stdout, _ = runCLI(t, nil, "pins", "list")
+	assert.Equal(t, exitOK, code)
+	assert.Regexp(t, `(?m)^`+abs+` admins +1 of [0-9a-f:]+ +\d{4}-`, stdout)
+	code, stderr = encrypt()
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Nil(t, os.WriteFile(path, signed, 0644))
+	code, _ = encrypt()
+	assert.Equal(t, exitError, code)
+	code, _, stderr = runCLI(t, nil, "pins", "accept", "-keyring", path, "-admins")
+	assert.Equal(t, exitOK, code, stderr)
+
+	// dropping the admins does not stop them being required
+	unadministered := strings.Replace(string(signed), "admins:\n  require: 2\n  keys: [alice, bob]\n", "", 1)
+	assert.NotEqual(t, string(signed), unadministered)
+	assert.Nil(t, os.WriteFile(path, []byte(unadministered), 0644))
+	code, stderr = encrypt()
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "multikey encrypt: keyring is not signed by enough admins: 0 of 2 required signatures by its trusted admins\n", stderr)
+
+	// changes to the keyring must be signed again
+	assert.Nil(t, os.WriteFile(path, signed, 0644))
+	mallory, err := rsa.GenerateKey(rand.Reader, minKeyBits)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "mallory.pub"), keys.EncodePubKeyPEM(&mallory.PublicKey), 0644))
+	code, stdout, stderr = runCLI(t, nil, "keyring", "add", "-keyring", path, "-name", "mallory", "-group", "dev", filepath.Join(dir, "mallory.pub"))
+	assert.Equal(t, exitOK, code, stderr)
+	assert.True(t, strings.HasSuffix(stdout, "signed by 0 of 2 required admins of alice, bob\n"), stdout)
+	code, _ = encrypt()
+	assert.Equal(t, exitError, code)
+	code, _, _ = runCLI(t, nil, "keyring", "verify", "-keyring", path, "-previous", previous)
+	assert.Equal(t, exitPolicyViolation, code)
+}
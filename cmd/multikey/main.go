@@ -0,0 +1,262 @@
+// Command multikey encrypts secrets such that n-of-N keys are required
+// to decrypt them.
+//
+// Usage:
+//
+//	multikey keygen [-bits N] [-out NAME]
+//	multikey encrypt [-r KEY|DIR|NAME ...] [-keyring FILE [-force]] [-require N] [-sign KEY] [-context CONTEXT] [-name NAME] [-description TEXT] [-content-type TYPE] [-label KEY=VALUE ...] [-created-by KEY] [-rotate-by DATE] [-not-after DATE] [-in FILE] [-out FILE]
+//	multikey decrypt -k KEY|DIR [-k ...] [-signer KEY|DIR|NAME ...] [-keyring FILE] [-context CONTEXT] [-allow-expired] [-in FILE] [-out FILE]
+//	multikey edit -k KEY|DIR [-k ...] [-r KEY|DIR ...] [-sign KEY] [-allow-expired] FILE
+//	multikey exec -secrets FILE -k KEY|DIR [-k ...] [-signer KEY|DIR|NAME ...] [-keyring FILE] [-only NAME ...] [-allow-expired] -- COMMAND [ARGS ...]
+//	multikey inspect [-in FILE] [-keyring FILE]
+//	multikey scan [-r KEY|DIR ...] [-keyring FILE] [-json] [DIR]
+//	multikey stale [-at DATE] [-json] [DIR]
+//	multikey check [-policy FILE] [-json]
+//	multikey rotate [-remove FINGERPRINT|KEY ...] [-add KEY|DIR ...] -k KEY|DIR [-k ...] [-r KEY|DIR ...] [-require N] [-sign KEY] [-dry-run] [DIR]
+//	multikey git-setup -r KEY|DIR [-r ...] [-require N] [-k KEY|DIR ...] [-sign KEY] [-merge-recipients union|intersection] PATTERN ...
+//	multikey git-filter clean|smudge [PATH]
+//	multikey git-merge [-recipients union|intersection] [-marker-size N] BASE OURS THEIRS [PATH]
+//	multikey git-textconv FILE
+//	multikey keyring add [-keyring FILE] -name NAME [-owner OWNER] [-group GROUP ...] [-tag TAG ...] [-expires DATE] KEY
+//	multikey keyring list [-keyring FILE] [NAME|GROUP ...]
+//	multikey keyring revoke [-keyring FILE] -k KEY -reason REASON [-date DATE] NAME|FINGERPRINT
+//	multikey keyring verify [-keyring FILE] [-previous FILE]
+//	multikey keyring admins [-keyring FILE] -require N NAME ...
+//	multikey keyring sign [-keyring FILE] -k KEY
+//	multikey pins list
+//	multikey pins accept [-keyring FILE] [-admins] KEY|DIR|NAME ...
+//	multikey fingerprint [KEY ...]
+//
+// Input is read from stdin and output written to stdout unless files are
+// given. Keys may be named in a keyring file, given with -keyring or
+// $MULTIKEY_KEYRING. The keys of recipients are pinned the first time
+// they are encrypted for, in the file named by $MULTIKEY_PINS or the
+// user's configuration directory. Secrets signed with -sign can be
+// required to be signed by trusted keys with decrypt -signer. Secrets
+// encrypted to a file are bound to its path within its repository, which
+// is required to decrypt them. Secrets encrypted with -not-after can not be
+// decrypted after that date without -allow-expired, and those past their
+// -rotate-by or -not-after date are listed by stale. The exit status
+// distinguishes failure causes, see the exit* constants.
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// exit codes
+const (
+	exitOK               = 0
+	exitError            = 1
+	exitUsage            = 2
+	exitMalformedInput   = 3
+	exitInsufficientKeys = 4
+	exitPolicyViolation  = 5
+)
+
+const usage = `usage: multikey <command> [flags]
+
+commands:
+  keygen       generate an RSA key pair
+  encrypt      encrypt a secret for a set of recipients
+  decrypt      decrypt a secret with a set of private keys
+  edit         edit an encrypted file in place with $EDITOR
+  exec         run a command with the variables of an encrypted dotenv file
+  inspect      describe an encrypted secret without decrypting it
+  scan         report the encrypted secrets in a directory and their keys
+  stale        list the encrypted secrets due to be rotated or expired
+  check        verify the encrypted secrets of a repository against its policy
+  rotate       remove and add keys to every encrypted secret in a directory
+  keyring      manage, sign and verify a keyring of named keys
+  pins         list and accept the recipient keys pinned on first use
+  fingerprint  print the fingerprint of keys
+  git-setup    encrypt files matching patterns in a git repository
+  git-filter   git clean and smudge filter, configured by git-setup
+  git-merge    git merge driver, configured by git-setup
+  git-textconv git diff text conversion, configured by git-setup
+
+run "multikey <command> -h" for the flags of a command
+`
+
+// randReader is the source of randomness for key generation and
+// encryption, overridden in tests to produce reproducible output
+var randReader io.Reader = rand.Reader
+
+// command is a subcommand of the multikey tool
+type command func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+
+var commands = map[string]command{
+	"keygen":       runKeygen,
+	"encrypt":      runEncrypt,
+	"decrypt":      runDecrypt,
+	"edit":         runEdit,
+	"exec":         runExec,
+	"inspect":      runInspect,
+	"scan":         runScan,
+	"stale":        runStale,
+	"check":        runCheck,
+	"rotate":       runRotate,
+	"keyring":      runKeyring,
+	"pins":         runPins,
+	"fingerprint":  runFingerprint,
+	"git-setup":    runGitSetup,
+	"git-filter":   runGitFilter,
+	"git-merge":    runGitMerge,
+	"git-textconv": runGitTextconv,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the multikey tool with the given arguments and
+// returns its exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+			fmt.Fprint(stdout, usage)
+			return exitOK
+		}
+		fmt.Fprintf(stderr, "multikey: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+	err := cmd(args[1:], stdin, stdout, stderr)
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	var uerr *usageError
+	var cerr *childExit
+	if !errors.As(err, &uerr) && !errors.As(err, &cerr) {
+		fmt.Fprintf(stderr, "multikey %s: %s\n", args[0], err)
+	}
+	return exitCode(err)
+}
+
+// usageError is returned when a command is invoked incorrectly
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// codedError associates an error with a specific exit code
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+func exitCode(err error) int {
+	var uerr *usageError
+	if errors.As(err, &uerr) {
+		return exitUsage
+	}
+	var eerr *codedError
+	if errors.As(err, &eerr) {
+		return eerr.code
+	}
+	var cerr *childExit
+	if errors.As(err, &cerr) {
+		return cerr.code
+	}
+	return exitError
+}
+
+// newFlagSet returns a flag set for a command which reports its own usage
+// errors on stderr
+func newFlagSet(name, synopsis string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: multikey %s %s\n\nflags:\n", name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// flagSet reports whether a flag was given on the command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseFlags parses a command's flags, turning parse failures into usage errors
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// usagef reports a usage error for a command on stderr
+func usagef(fs *flag.FlagSet, format string, a ...interface{}) error {
+	fmt.Fprintf(fs.Output(), "multikey %s: %s\n", fs.Name(), fmt.Sprintf(format, a...))
+	fs.Usage()
+	return &usageError{msg: fmt.Sprintf(format, a...)}
+}
+
+// listFlag is a flag which may be repeated, and whose values may
+// also be comma separated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// readInput reads all of the file at path, or stdin when path is empty or "-"
+func readInput(path string, stdin io.Reader) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// writeOutput writes data to the file at path, or stdout when path is
+// empty or "-". Files are created with the given permissions.
+func writeOutput(path string, data []byte, perm os.FileMode, stdout io.Writer) error {
+	if path == "" || path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"flag"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// testChildEnv makes the test binary act as the command run by exec
+const testChildEnv = "MULTIKEY_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(testChildEnv); mode != "" {
+		os.Exit(testChild(mode, os.Args[1:]))
+	}
+	// keep the keys pinned by tests out of the user's pins
+	dir, err := os.MkdirTemp("", "multikey-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(envPins, filepath.Join(dir, "pins.json"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func runCLI(t *testing.T, stdin []byte, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, bytes.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func assertGolden(t *testing.T, name string, got string) {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		assert.Nil(t, os.WriteFile(path, []byte(got), 0644))
+	}
+	want, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(want), got)
+}
+
+func TestGolden(t *testing.T) {
+	randReader = mathrand.New(mathrand.NewSource(1))
+	defer func() { randReader = rand.Reader }()
+
+	tests := []struct {
+		name string
+		args []string
+		in   string
+		code int
+	}{
+		{
+			name: "encrypt",
+			args: []string{"encrypt", "-r", "testdata/keys", "-require", "2", "-in", "testdata/secret.txt"},
+			code: exitOK,
+		},
+		{
+			name: "decrypt",
+			args: []string{"decrypt", "-k", "testdata/keys/alice.pem", "-k", "testdata/keys/carol.pem"},
+			in:   "testdata/encrypt.golden",
+			code: exitOK,
+		},
+		{
+			name: "inspect",
+			args: []string{"inspect", "-in", "testdata/encrypt.golden"},
+			code: exitOK,
+		},
+		{
+			name: "inspect-keyring",
+			args: []string{"inspect", "-keyring", "testdata/keyring.yaml", "-in", "testdata/encrypt.golden"},
+			code: exitOK,
+		},
+		{
+			name: "keyring-list",
+			args: []string{"keyring", "list", "-keyring", "testdata/keyring.yaml"},
+			code: exitOK,
+		},
+		{
+			name: "scan",
+			args: []string{"scan", "-r", "testdata/keys/alice.pub", "-r", "testdata/keys/bob.pub", "testdata"},
+			code: exitOK,
+		},
+		{
+			name: "scan-json",
+			args: []string{"scan", "-json", "-r", "testdata/keys", "testdata"},
+			code: exitOK,
+		},
+		{
+			name: "fingerprint",
+			args: []string{"fingerprint", "testdata/keys/alice.pub", "testdata/keys/bob.pem"},
+			code: exitOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdin []byte
+			if test.in != "" {
+				var err error
+				stdin, err = os.ReadFile(test.in)
+				assert.Nil(t, err)
+			}
+			code, stdout, stderr := runCLI(t, stdin, test.args...)
+			assert.Equal(t, test.code, code, stderr)
+			assertGolden(t, test.name, stdout)
+		})
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	enc, err := os.ReadFile("testdata/encrypt.golden")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name string
+		args []string
+		in   []byte
+		code int
+	}{
+		{
+			name: "no command",
+			code: exitUsage,
+		},
+		{
+			name: "unknown command",
+			args: []string{"frobnicate"},
+			code: exitUsage,
+		},
+		{
+			name: "keygen with a small key",
+			args: []string{"keygen", "-bits", "1024"},
+			code: exitUsage,
+		},
+		{
+			name: "encrypt without recipients",
+			args: []string{"encrypt"},
+			code: exitUsage,
+		},
+		{
+			name: "encrypt requiring too many keys",
+			args: []string{"encrypt", "-r", "testdata/keys", "-require", "4"},
+			code: exitUsage,
+		},
+		{
+			name: "decrypt with too few keys",
+			args: []string{"decrypt", "-k", "testdata/keys/bob.pem"},
+			in:   enc,
+			code: exitInsufficientKeys,
+		},
+		{
+			name: "decrypt malformed input",
+			args: []string{"decrypt", "-k", "testdata/keys"},
+			in:   []byte("not a secret"),
+			code: exitMalformedInput,
+		},
+		{
+			name: "decrypt with a public key",
+			args: []string{"decrypt", "-k", "testdata/keys/alice.pub"},
+			in:   enc,
+			code: exitMalformedInput,
+		},
+		{
+			name: "inspect malformed input",
+			args: []string{"inspect"},
+			in:   []byte("not a secret"),
+			code: exitMalformedInput,
+		},
+		{
+			name: "missing key file",
+			args: []string{"decrypt", "-k", "testdata/keys/nobody.pem"},
+			in:   enc,
+			code: exitError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, _ := runCLI(t, test.in, test.args...)
+			assert.Equal(t, test.code, code)
+		})
+	}
+}
+
+func TestKeygenRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"one", "two"} {
+		code, stdout, stderr := runCLI(t, nil, "keygen", "-bits", "2048", "-out", filepath.Join(dir, name))
+		assert.Equal(t, exitOK, code, stderr)
+
+		_, fp, _ := runCLI(t, nil, "fingerprint", filepath.Join(dir, name+pubKeyExt))
+		assert.Contains(t, fp, stdout[:len(stdout)-1])
+
+		info, err := os.Stat(filepath.Join(dir, name+privKeyExt))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	encPath := filepath.Join(dir, "secret.mk")
+	code, _, stderr := runCLI(t, []byte("hello"), "encrypt", "-r", dir, "-require", "2", "-out", encPath)
+	assert.Equal(t, exitOK, code, stderr)
+
+	code, stdout, stderr := runCLI(t, nil, "decrypt", "-k", dir, "-in", encPath)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "hello", stdout)
+
+	code, _, _ = runCLI(t, nil, "decrypt", "-k", filepath.Join(dir, "one"+privKeyExt), "-in", encPath)
+	assert.Equal(t, exitInsufficientKeys, code)
+}
+
+func TestEncryptUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.mk")
+	encrypt := func(plain string, extra ...string) {
+		args := append([]string{"encrypt", "-r", "testdata/keys", "-require", "2", "-out", path}, extra...)
+		code, _, stderr := runCLI(t, []byte(plain), args...)
+		assert.Equal(t, exitOK, code, stderr)
+	}
+	encrypt("v1")
+	original, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	encrypt("v1", "-update", "-k", "testdata/keys")
+	unchanged, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(original), string(unchanged))
+
+	encrypt("v2", "-update", "-k", "testdata/keys")
+	changed, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotEqual(t, string(original), string(changed))
+	originalLines := strings.Split(string(original), "\n")
+	changedLines := strings.Split(string(changed), "\n")
+	assert.Equal(t, len(originalLines), len(changedLines))
+	differing := 0
+	for i := range originalLines {
+		if originalLines[i] != changedLines[i] {
+			differing++
+		}
+	}
+	assert.Equal(t, 1, differing) // the payload
+
+	code, _, _ := runCLI(t, []byte("v3"), "encrypt", "-r", "testdata/keys", "-out", path, "-update")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestEncryptSign(t *testing.T) {
+	pubs := loadCLITestKeys(t)
+	alice := keys.GetFingerprint(pubs[0])
+	dir := t.TempDir()
+	signed, unsigned := filepath.Join(dir, "signed.mk"), filepath.Join(dir, "unsigned.mk")
+	code, _, stderr := runCLI(t, []byte("secret"), "encrypt", "-r", "testdata/keys/bob.pub", "-sign", "testdata/keys/alice.pem", "-out", signed)
+	assert.Equal(t, exitOK, code, stderr)
+	code, _, stderr = runCLI(t, []byte("secret"), "encrypt", "-r", "testdata/keys/bob.pub", "-out", unsigned)
+	assert.Equal(t, exitOK, code, stderr)
+
+	code, stdout, _ := runCLI(t, nil, "inspect", "-keyring", "testdata/keyring.yaml", "-in", signed)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "signer:    "+alice+"  alice\n")
+	code, stdout, _ = runCLI(t, nil, "inspect", "-in", unsigned)
+	assert.Equal(t, exitOK, code)
+	assert.NotContains(t, stdout, "signer:")
+
+	tests := []struct {
+		name string
+		file string
+		args []string
+		code int
+	}{
+		{name: "no signer required", file: unsigned, code: exitOK},
+		{name: "trusted signer", file: signed, args: []string{"-signer", "testdata/keys/alice.pub"}, code: exitOK},
+		{name: "trusted signer in keyring", file: signed, args: []string{"-keyring", "testdata/keyring.yaml", "-signer", "ops"}, code: exitOK},
+		{name: "untrusted signer", file: signed, args: []string{"-signer", "testdata/keys/bob.pub"}, code: exitPolicyViolation},
+		{name: "unsigned", file: unsigned, args: []string{"-signer", "testdata/keys/alice.pub"}, code: exitPolicyViolation},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"decrypt", "-k", "testdata/keys/bob.pem", "-in", test.file}, test.args...)
+			code, stdout, stderr := runCLI(t, nil, args...)
+			assert.Equal(t, test.code, code, stderr)
+			if test.code == exitOK {
+				assert.Equal(t, "secret", stdout)
+			}
+		})
+	}
+}
+
+func TestEncryptContext(t *testing.T) {
+	repo := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(repo, ".git"), 0755))
+	for _, dir := range []string{"prod", "staging"} {
+		assert.Nil(t, os.Mkdir(filepath.Join(repo, dir), 0755))
+	}
+	prod, staging := filepath.Join(repo, "prod", "db.mk"), filepath.Join(repo, "staging", "db.mk")
+	code, _, stderr := runCLI(t, []byte("secret"), "encrypt", "-r", "testdata/keys/bob.pub", "-out", prod)
+	assert.Equal(t, exitOK, code, stderr)
+	enc, err := os.ReadFile(prod)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(staging, enc, 0644))
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{name: "bound to its path", args: []string{"-in", prod}, code: exitOK},
+		{name: "given context", args: []string{"-in", staging, "-context", "prod/db.mk"}, code: exitOK},
+		{
+			name:   "moved",
+			args:   []string{"-in", staging},
+			code:   exitError,
+			stderr: "multikey decrypt: could not decrypt secret payload: the secret was altered or is bound to a different context; if it was moved from another file than \"staging/db.mk\", give the context it was encrypted for with -context\n",
+		},
+		{
+			name:   "from stdin",
+			code:   exitPolicyViolation,
+			stderr: "multikey decrypt: secret is bound to a different context, give it with -context or the secret's file with -in\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"decrypt", "-k", "testdata/keys/bob.pem"}, test.args...)
+			code, stdout, stderr := runCLI(t, enc, args...)
+			assert.Equal(t, test.code, code, stderr)
+			if test.code == exitOK {
+				assert.Equal(t, "secret", stdout)
+				return
+			}
+			assert.Equal(t, test.stderr, stderr)
+		})
+	}
+
+	// secrets can be left unbound
+	code, _, stderr = runCLI(t, []byte("secret"), "encrypt", "-r", "testdata/keys/bob.pub", "-context", "", "-out", prod)
+	assert.Equal(t, exitOK, code, stderr)
+	code, stdout, _ := runCLI(t, nil, "inspect", "-in", prod)
+	assert.Equal(t, exitOK, code)
+	assert.NotContains(t, stdout, "context:")
+}
+
+func TestEncryptMetadata(t *testing.T) {
+	pubs := loadCLITestKeys(t)
+	alice := keys.GetFingerprint(pubs[0])
+	path := filepath.Join(t.TempDir(), "db.mk")
+	code, _, stderr := runCLI(t, []byte("hunter2"), "encrypt", "-r", "testdata/keys/bob.pub", "-out", path,
+		"-name", "db-password", "-description", "payments database", "-content-type", "text/plain",
+		"-label", "env=prod", "-label", "team=payments", "-sign", "testdata/keys/alice.pem")
+	assert.Equal(t, exitOK, code, stderr)
+
+	code, stdout, _ := runCLI(t, nil, "inspect", "-keyring", "testdata/keyring.yaml", "-in", path)
+	assert.Equal(t, exitOK, code)
+	assert.Regexp(t, `metadata:
+  name:         db-password
+  description:  payments database
+  content-type: text/plain
+  created:      \d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ
+  created-by:   `+alice+`  alice
+  labels:       env=prod, team=payments
+keys:
+`, stdout)
+
+	// updating keeps the metadata, and changes what is given
+	code, _, stderr = runCLI(t, []byte("hunter3"), "encrypt", "-r", "testdata/keys/bob.pub", "-out", path,
+		"-update", "-k", "testdata/keys/bob.pem", "-label", "env=staging")
+	assert.Equal(t, exitOK, code, stderr)
+	_, updated, _ := runCLI(t, nil, "inspect", "-in", path)
+	assert.Contains(t, updated, "  name:         db-password\n")
+	assert.Contains(t, updated, "  labels:       env=staging, team=payments\n")
+	assert.Equal(t, regexp.MustCompile(`created: .*`).FindString(stdout), regexp.MustCompile(`created: .*`).FindString(updated))
+
+	// the secret is no longer signed, so its creator is no longer known
+	assert.NotContains(t, updated, "created-by:")
+
+	// creators other than the signer are only claims
+	bob := keys.GetFingerprint(pubs[1])
+	code, _, stderr = runCLI(t, []byte("hunter2"), "encrypt", "-r", "testdata/keys/bob.pub", "-out", path, "-created-by", "testdata/keys/bob.pub")
+	assert.Equal(t, exitOK, code, stderr)
+	_, claimed, _ := runCLI(t, nil, "inspect", "-in", path)
+	assert.Contains(t, claimed, "  created-by:   "+bob+" (unverified)\n")
+	code, _, _ = runCLI(t, []byte("hunter2"), "encrypt", "-r", "testdata/keys/bob.pub", "-sign", "testdata/keys/alice.pem", "-created-by", "testdata/keys/bob.pub")
+	assert.Equal(t, exitUsage, code)
+	code, _, stderr = runCLI(t, []byte("hunter2"), "encrypt", "-r", "testdata/keys/bob.pub", "-sign", "testdata/keys/alice.pem", "-created-by", "testdata/keys/alice.pub")
+	assert.Equal(t, exitOK, code, stderr)
+
+	code, _, _ = runCLI(t, []byte("hunter2"), "encrypt", "-r", "testdata/keys/bob.pub", "-label", "env")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI(t, []byte("hunter2"), "encrypt", "-r", "testdata/keys/bob.pub", "-description", "two\nlines")
+	assert.Equal(t, exitUsage, code)
+}
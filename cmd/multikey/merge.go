@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/adrianosela/multikey"
+)
+
+// recipients of merged files, see runGitMerge
+const (
+	recipientsUnion        = "union"
+	recipientsIntersection = "intersection"
+)
+
+// defaultMarkerSize is the length of conflict markers when git does not say
+const defaultMarkerSize = 7
+
+// runGitMerge is a git merge driver for encrypted files. It decrypts the
+// three versions of a file, merges their plaintext and re-encrypts the
+// result over the OURS file, merging the recipients of both sides as
+// mergeRecipients does. Conflict markers are encrypted along with the
+// rest of the file, and reported with a non-zero exit status as git expects.
+// Merged files are signed with the key configured for the git filter, if any.
+func runGitMerge(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("git-merge", "[-recipients union|intersection] [-marker-size N] BASE OURS THEIRS [PATH]", stderr)
+	recipients := fs.String("recipients", recipientsUnion, "recipients of the merged file when the base version is not encrypted, the "+recipientsUnion+" or "+recipientsIntersection+" of those of both sides")
+	markerSize := fs.Int("marker-size", defaultMarkerSize, "length of conflict markers")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 3 || fs.NArg() > 4 {
+		return usagef(fs, "expected the base, ours and theirs files, and optionally the path of the file")
+	}
+	if *recipients != recipientsUnion && *recipients != recipientsIntersection {
+		return usagef(fs, "-recipients must be %s or %s", recipientsUnion, recipientsIntersection)
+	}
+	if *markerSize < 1 {
+		return usagef(fs, "-marker-size must be positive")
+	}
+	oursPath, path := fs.Arg(1), fs.Arg(1)
+	if fs.NArg() == 4 {
+		path = fs.Arg(3)
+	}
+
+	versions := [3][]byte{}
+	infos := [3]*multikey.Info{}
+	encrypted := false
+	for i := range versions {
+		data, err := os.ReadFile(fs.Arg(i))
+		if err != nil {
+			return err
+		}
+		versions[i] = data
+		if info, err := multikey.Inspect(string(data)); err == nil {
+			infos[i] = info
+			encrypted = true
+		}
+	}
+	context, err := gitContext(path)
+	if err != nil {
+		return err
+	}
+	var privs []*rsa.PrivateKey
+	plain := versions
+	if encrypted {
+		if privs, err = gitPrivateKeys(); err != nil {
+			return err
+		}
+		for i := range versions {
+			if infos[i] == nil {
+				continue
+			}
+			if plain[i], err = multikey.DecryptWithOptions(string(versions[i]), privs, &multikey.DecryptOptions{Context: context}); err != nil {
+				return classify(explainContext(err, context))
+			}
+		}
+	}
+
+	merged, conflicts, err := mergeText(plain[0], plain[1], plain[2], *markerSize)
+	if err != nil {
+		return err
+	}
+	out := merged
+	if encrypted {
+		ids, threshold := mergeRecipients(infos[0], infos[1], infos[2], *recipients == recipientsIntersection)
+		if len(ids) == 0 {
+			return fmt.Errorf("%s has no recipients in common to merge for", path)
+		}
+		if threshold == 0 {
+			if threshold, err = gitRequire(); err != nil {
+				return err
+			}
+		}
+		if threshold > len(ids) {
+			threshold = len(ids)
+		}
+		configured, err := gitConfigAll(gitConfigRecipient, false)
+		if err != nil {
+			return err
+		}
+		pubs, err := resolveRecipients(ids, configured, privs)
+		if err != nil {
+			return err
+		}
+		signer, err := gitSigner()
+		if err != nil {
+			return err
+		}
+		opts := &multikey.EncryptOptions{Rand: randReader, Signer: signer, Context: context}
+		var enc string
+		if infos[1] != nil {
+			enc, err = multikey.UpdateWithOptions(string(versions[1]), merged, privs, pubs, threshold, opts)
+		} else {
+			enc, err = multikey.EncryptWithOptions(merged, pubs, threshold, opts)
+		}
+		if err != nil {
+			return err
+		}
+		out = []byte(enc)
+		if !warnUnsigned(path, versions[1], out, signer, stderr) {
+			warnUnsigned(path, versions[2], out, signer, stderr)
+		}
+	}
+	if err := os.WriteFile(oursPath, out, 0644); err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d conflicts in %s", conflicts, path)
+	}
+	return nil
+}
+
+// mergeRecipients returns the recipients of a merged file and the largest
+// of the thresholds of both sides. Recipients added on either side since
+// the base version are kept, and those removed on either side dropped;
+// without an encrypted base version, the union or intersection of the
+// recipients of both sides is taken. Versions which are not encrypted do
+// not restrict recipients.
+func mergeRecipients(base, ours, theirs *multikey.Info, intersect bool) ([]string, int) {
+	ids, threshold, sides := []string{}, 0, 0
+	count := map[string]int{}
+	for _, info := range []*multikey.Info{ours, theirs} {
+		if info == nil {
+			continue
+		}
+		sides++
+		if info.Threshold > threshold {
+			threshold = info.Threshold
+		}
+		for _, id := range info.KeyIDs {
+			if count[id] == 0 {
+				ids = append(ids, id)
+			}
+			count[id]++
+		}
+	}
+	inBase := map[string]bool{}
+	if base != nil {
+		for _, id := range base.KeyIDs {
+			inBase[id] = true
+		}
+	}
+	merged := []string{}
+	for _, id := range ids {
+		switch {
+		case base == nil && (!intersect || count[id] == sides):
+			merged = append(merged, id)
+		case base != nil && (!inBase[id] || count[id] == sides):
+			merged = append(merged, id) // added, or kept on both sides
+		}
+	}
+	return merged, threshold
+}
+
+// mergeText runs a three-way merge of plaintext with git merge-file,
+// returning the result and the number of conflicts in it. The versions are
+// written to private temporary files which are overwritten once merged.
+func mergeText(base, ours, theirs []byte, markerSize int) ([]byte, int, error) {
+	dir, err := os.MkdirTemp(tempDir(), "multikey-")
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{}
+	for i, data := range [][]byte{ours, base, theirs} {
+		tmp := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(tmp, data, 0600); err != nil {
+			return nil, 0, fmt.Errorf("could not write temporary file: %s", err)
+		}
+		defer shred(tmp)
+		files = append(files, tmp)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"merge-file", "-p",
+		"--marker-size=" + strconv.Itoa(markerSize),
+		"-L", "ours", "-L", "base", "-L", "theirs"}, files...)...)
+	cmd.Stderr = &stderr
+	merged, err := cmd.Output()
+	if err == nil {
+		return merged, 0, nil
+	}
+	// the exit status is the number of conflicts, or negative on error
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		return merged, exitErr.ExitCode(), nil
+	}
+	return nil, 0, fmt.Errorf("git merge-file: %s %s", err, bytes.TrimSpace(stderr.Bytes()))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keyring"
+	"github.com/adrianosela/multikey/keys"
+)
+
+// metadataFlags are the flags describing a secret as it is encrypted
+type metadataFlags struct {
+	name        *string
+	description *string
+	contentType *string
+	createdBy   *string
+	rotateBy    *string
+	notAfter    *string
+	labels      listFlag
+}
+
+func addMetadataFlags(fs *flag.FlagSet) *metadataFlags {
+	m := &metadataFlags{
+		name:        fs.String("name", "", "name of the secret, recorded in its metadata"),
+		description: fs.String("description", "", "description of the secret, recorded in its metadata"),
+		contentType: fs.String("content-type", "", "media type of the secret, recorded in its metadata"),
+		createdBy:   fs.String("created-by", "", "key file of the secret's creator, recorded in its metadata, which must be the -sign key if one is given (default the -sign key)"),
+		rotateBy:    fs.String("rotate-by", "", "date the secret is due to be rotated by, as YYYY-MM-DD or RFC 3339, recorded in its metadata"),
+		notAfter:    fs.String("not-after", "", "date the secret expires and can no longer be decrypted, as YYYY-MM-DD or RFC 3339, recorded in its metadata"),
+	}
+	fs.Var(&m.labels, "label", "KEY=VALUE label of the secret, recorded in its metadata (repeatable)")
+	return m
+}
+
+// given reports whether any metadata flags were given
+func (m *metadataFlags) given(fs *flag.FlagSet) bool {
+	for _, name := range []string{"name", "description", "content-type", "created-by", "rotate-by", "not-after", "label"} {
+		if flagSet(fs, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadata returns the metadata of a secret given its existing version,
+// if any, and the flags given. Secrets are described when any metadata
+// flags are given or they are signed, and keep when they were created.
+// Secrets which lose their signature no longer name its key as their
+// creator. It returns nil to keep the existing metadata of secrets as it is.
+func (m *metadataFlags) metadata(fs *flag.FlagSet, existing *multikey.Info, signer *rsa.PrivateKey) (*multikey.Metadata, error) {
+	unsigned := signer == nil && existing != nil && existing.Signer != "" && existing.Metadata != nil && existing.Metadata.CreatedBy != ""
+	if !m.given(fs) && signer == nil && !unsigned {
+		return nil, nil
+	}
+	md := &multikey.Metadata{Created: time.Now().UTC().Truncate(time.Second), Labels: map[string]string{}}
+	if existing != nil && existing.Metadata != nil {
+		*md = *existing.Metadata
+		md.Labels = map[string]string{}
+		for k, v := range existing.Metadata.Labels {
+			md.Labels[k] = v
+		}
+	}
+	if unsigned {
+		md.CreatedBy = ""
+	}
+	if flagSet(fs, "name") {
+		md.Name = *m.name
+	}
+	if flagSet(fs, "description") {
+		md.Description = *m.description
+	}
+	if flagSet(fs, "content-type") {
+		md.ContentType = *m.contentType
+	}
+	for name, t := range map[string]*time.Time{"rotate-by": &md.RotateBy, "not-after": &md.NotAfter} {
+		if !flagSet(fs, name) {
+			continue
+		}
+		value := fs.Lookup(name).Value.String()
+		if value == "" {
+			*t = time.Time{}
+			continue
+		}
+		parsed, err := parseDate(value)
+		if err != nil {
+			return nil, usagef(fs, "-%s must be formatted as YYYY-MM-DD or RFC 3339", name)
+		}
+		*t = parsed
+	}
+	if signer != nil {
+		md.CreatedBy = keys.GetFingerprint(&signer.PublicKey)
+	}
+	if *m.createdBy != "" {
+		pub, err := readPublicKey(*m.createdBy)
+		if err != nil {
+			return nil, err
+		}
+		if signer != nil && keys.GetFingerprint(pub) != md.CreatedBy {
+			return nil, usagef(fs, "-created-by must be the -sign key, which signs the secret as its creator")
+		}
+		md.CreatedBy = keys.GetFingerprint(pub)
+	}
+	for _, label := range m.labels {
+		k, v, ok := strings.Cut(label, "=")
+		if !ok {
+			return nil, usagef(fs, "-label must be KEY=VALUE, not %q", label)
+		}
+		md.Labels[k] = v
+	}
+	return md, nil
+}
+
+// printMetadata prints the metadata of a secret, naming its creator's key
+// after the keyring. Creators other than the secret's signer are only
+// claimed, and marked as unverified.
+func printMetadata(info *multikey.Info, ring *keyring.Keyring, stdout io.Writer) {
+	md := info.Metadata
+	if md == nil {
+		return
+	}
+	fields := [][2]string{
+		{"name", md.Name},
+		{"description", md.Description},
+		{"content-type", md.ContentType},
+	}
+	if !md.Created.IsZero() {
+		fields = append(fields, [2]string{"created", md.Created.Format(time.RFC3339)})
+	}
+	if md.CreatedBy != "" {
+		createdBy := keyName(ring, md.CreatedBy)
+		if md.CreatedBy != info.Signer {
+			createdBy += " (unverified)"
+		}
+		fields = append(fields, [2]string{"created-by", createdBy})
+	}
+	now := time.Now()
+	if !md.RotateBy.IsZero() {
+		rotateBy := md.RotateBy.Format(time.RFC3339)
+		if md.Overdue(now) {
+			rotateBy += " (overdue)"
+		}
+		fields = append(fields, [2]string{"rotate-by", rotateBy})
+	}
+	if !md.NotAfter.IsZero() {
+		notAfter := md.NotAfter.Format(time.RFC3339)
+		if md.Expired(now) {
+			notAfter += " (expired)"
+		}
+		fields = append(fields, [2]string{"not-after", notAfter})
+	}
+	labels := []string{}
+	for _, k := range md.LabelKeys() {
+		labels = append(labels, k+"="+md.Labels[k])
+	}
+	fields = append(fields, [2]string{"labels", strings.Join(labels, ", ")})
+	fmt.Fprintf(stdout, "metadata:\n")
+	for _, f := range fields {
+		if f[1] != "" {
+			fmt.Fprintf(stdout, "  %-13s %s\n", f[0]+":", f[1])
+		}
+	}
+}
+
+// explainExpired adds how to decrypt expired secrets anyway to expiry
+// errors
+func explainExpired(err error) error {
+	if !errors.Is(err, multikey.ErrExpired) {
+		return err
+	}
+	return fmt.Errorf("%w, decrypt it anyway with -allow-expired or renew it with encrypt -update -not-after DATE", err)
+}
+
+// parseDate parses a date given as YYYY-MM-DD, meaning the start of the
+// day in UTC, or as an RFC 3339 time
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(keyring.DateFormat, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/adrianosela/multikey/pin"
+)
+
+// envPins names the environment variable holding the pin store file
+const envPins = "MULTIKEY_PINS"
+
+func runPins(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return runPinsList(args[1:], stdout, stderr)
+		case "accept":
+			return runPinsAccept(args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprint(stderr, "usage: multikey pins list|accept [flags]\n")
+	return &usageError{msg: "expected list or accept"}
+}
+
+func runPinsList(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("pins list", "", stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef(fs, "unexpected arguments %q", fs.Args())
+	}
+	store, err := loadPins()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFINGERPRINT\tPINNED")
+	for _, p := range store.Pins() {
+		fingerprint := p.Fingerprint
+		if p.Require > 0 {
+			fingerprint = fmt.Sprintf("%d of %s", p.Require, strings.Join(p.Admins, ", "))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Name, fingerprint, p.Pinned.Format("2006-01-02 15:04:05"))
+	}
+	return tw.Flush()
+}
+
+func runPinsAccept(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("pins accept", "[-keyring FILE] [-admins] KEY|DIR|NAME ...", stderr)
+	keyringPath := fs.String("keyring", "", keyringFlagUsage)
+	admins := fs.Bool("admins", false, "accept the admins of the keyring, whoever signed it")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 && !*admins {
+		return usagef(fs, "at least one recipient to accept the key of is required")
+	}
+	if *admins {
+		if keyringFile(*keyringPath) == "" {
+			return usagef(fs, "-admins requires a keyring file, give it with -keyring or $%s", envKeyring)
+		}
+		if err := acceptAdmins(keyringFile(*keyringPath), stdout); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			return nil
+		}
+	}
+	ring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	recipients, err := loadRecipients(fs.Args(), ring, keyringFile(*keyringPath))
+	if err != nil {
+		return err
+	}
+	store, err := loadPins()
+	if err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		previous, ok := store.Get(r.name)
+		store.Accept(r.name, r.pub)
+		p, _ := store.Get(r.name)
+		if ok && previous.Fingerprint != p.Fingerprint {
+			fmt.Fprintf(stdout, "pinned %s  %s (was %s)\n", p.Fingerprint, r.name, previous.Fingerprint)
+			continue
+		}
+		fmt.Fprintf(stdout, "pinned %s  %s\n", p.Fingerprint, r.name)
+	}
+	return store.Save()
+}
+
+// acceptAdmins pins the admins of the keyring at path as they are in it
+func acceptAdmins(path string, stdout io.Writer) error {
+	ring, err := readKeyring(path)
+	if err != nil {
+		return err
+	}
+	name, err := adminsPinName(path)
+	if err != nil {
+		return err
+	}
+	store, err := loadPins()
+	if err != nil {
+		return err
+	}
+	admins, require := ring.Admins()
+	store.AcceptAdmins(name, ring.AdminFingerprints(), require)
+	if require == 0 {
+		fmt.Fprintf(stdout, "pinned no admins  %s\n", name)
+	} else {
+		fmt.Fprintf(stdout, "pinned %d of %s  %s\n", require, strings.Join(admins, ", "), name)
+	}
+	return store.Save()
+}
+
+// loadPins loads the pin store named by $MULTIKEY_PINS, or the user's
+// default one
+func loadPins() (*pin.Store, error) {
+	path := os.Getenv(envPins)
+	if path == "" {
+		var err error
+		if path, err = pin.DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+	store, err := pin.Load(path)
+	if err != nil {
+		return nil, &codedError{code: exitMalformedInput, err: err}
+	}
+	return store, nil
+}
+
+// loadPinnedKeys loads recipient key files and directories as
+// loadRecipients does, and pins their keys as pinRecipients does
+func loadPinnedKeys(paths []string) ([]*rsa.PublicKey, error) {
+	recipients, err := loadRecipients(paths, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := pinRecipients(recipients); err != nil {
+		return nil, err
+	}
+	return publicKeys(recipients), nil
+}
+
+// pinRecipients pins the keys of recipients the first time they are used,
+// and fails if any of them resolves to a different key than it did then
+func pinRecipients(recipients []recipient) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	store, err := loadPins()
+	if err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		if err := store.Check(r.name, r.pub); err != nil {
+			return fmt.Errorf("%s\nif the key was changed on purpose, accept it with: multikey pins accept %s", err, r.ref)
+		}
+	}
+	return store.Save()
+}
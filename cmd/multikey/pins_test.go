@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPins(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(envPins, filepath.Join(dir, "pins.json"))
+	pubs := loadCLITestKeys(t)
+	alice, bob := keys.GetFingerprint(pubs[0]), keys.GetFingerprint(pubs[1])
+	keyPath := filepath.Join(dir, "alice.pub")
+	copyFile := func(from string) {
+		data, err := os.ReadFile(from)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(keyPath, data, 0644))
+	}
+	encrypt := func(args ...string) (int, string) {
+		code, _, stderr := runCLI(t, []byte("secret"), append([]string{"encrypt"}, args...)...)
+		return code, stderr
+	}
+
+	// the first key a recipient resolves to is pinned
+	copyFile("testdata/keys/alice.pub")
+	code, stderr := encrypt("-r", keyPath, "-r", "testdata/keys/bob.pub")
+	assert.Equal(t, exitOK, code, stderr)
+	code, stdout, _ := runCLI(t, nil, "pins", "list")
+	assert.Equal(t, exitOK, code)
+	assert.Regexp(t, `(?m)^`+filepath.Join(dir, "alice.pub")+` +`+alice+` +\d{4}-\d\d-\d\d \d\d:\d\d:\d\d$`, stdout)
+	assert.Contains(t, stdout, bob)
+
+	// a substituted key file is refused until accepted
+	copyFile("testdata/keys/bob.pub")
+	code, stderr = encrypt("-r", keyPath)
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "multikey encrypt: "+keyPath+": key does not match its pinned fingerprint "+alice+", it is now "+bob+
+		"\nif the key was changed on purpose, accept it with: multikey pins accept "+keyPath+"\n", stderr)
+	code, stdout, stderr = runCLI(t, nil, "pins", "accept", keyPath)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "pinned "+bob+"  "+keyPath+" (was "+alice+")\n", stdout)
+	code, stderr = encrypt("-r", keyPath)
+	assert.Equal(t, exitOK, code, stderr)
+
+	// so are keys changed in a keyring
+	ringPath := filepath.Join(dir, "keyring.yaml")
+	data, err := os.ReadFile("testdata/keyring.yaml")
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(ringPath, data, 0644))
+	code, stderr = encrypt("-keyring", ringPath, "-r", "carol")
+	assert.Equal(t, exitOK, code, stderr)
+	swapped := strings.Replace(string(data), "name: carol", "name: carol-old", 1)
+	swapped = strings.Replace(swapped, "name: bob", "name: carol", 1)
+	assert.Nil(t, os.WriteFile(ringPath, []byte(swapped), 0644))
+	code, stderr = encrypt("-keyring", ringPath, "-r", "carol")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "accept it with: multikey pins accept -keyring "+ringPath+" carol\n")
+	code, _, stderr = runCLI(t, nil, "pins", "accept", "-keyring", ringPath, "carol")
+	assert.Equal(t, exitOK, code, stderr)
+	code, stderr = encrypt("-keyring", ringPath, "-r", "carol")
+	assert.Equal(t, exitOK, code, stderr)
+
+	// and keys added to secrets by rotate
+	copyFile("testdata/keys/alice.pub")
+	code, _, stderr = runCLI(t, nil, "rotate", "-add", keyPath, "-k", "testdata/keys/alice.pem", dir)
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "multikey pins accept "+keyPath+"\n")
+
+	code, _, _ = runCLI(t, nil, "pins", "accept")
+	assert.Equal(t, exitUsage, code)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/dotenv"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/scan"
+	"github.com/adrianosela/multikey/structured"
+)
+
+var fingerprintRegex = regexp.MustCompile(`^([0-9a-f]{2}:){15}[0-9a-f]{2}$`)
+
+func runRotate(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("rotate", "[-remove FINGERPRINT|KEY ...] [-add KEY|DIR ...] -k KEY|DIR [-k ...] [-r KEY|DIR ...] [-require N] [-sign KEY] [-dry-run] [DIR]", stderr)
+	var removed, added, keyPaths, recipients listFlag
+	fs.Var(&removed, "remove", "fingerprint or public key file of a key to remove from every secret (repeatable)")
+	fs.Var(&added, "add", "public key file, or directory of *"+pubKeyExt+" files, to add to every secret with a removed key, or every secret if none are removed (repeatable)")
+	fs.Var(&keyPaths, "k", "private key file, or directory of *"+privKeyExt+" files, to decrypt secrets (repeatable)")
+	fs.Var(&keyPaths, "key", "same as -k")
+	fs.Var(&recipients, "r", "where to find the public keys of the secrets' other recipients, as in encrypt (repeatable)")
+	fs.Var(&recipients, "recipient", "same as -r")
+	require := fs.Int("require", 0, "threshold of rotated secrets (default each secret's own)")
+	signKey := fs.String("sign", "", "private key file to sign rotated secrets with as their author, rotated secrets are otherwise unsigned")
+	dryRun := fs.Bool("dry-run", false, "print what would be rotated without changing any file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usagef(fs, "at most one directory may be given")
+	}
+	if len(removed) == 0 && len(added) == 0 {
+		return usagef(fs, "at least one key to -remove or -add is required")
+	}
+	if len(keyPaths) == 0 {
+		return usagef(fs, "at least one key is required")
+	}
+	if *require < 0 {
+		return usagef(fs, "-require must be positive")
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	privs, err := loadPrivateKeys(keyPaths)
+	if err != nil {
+		return err
+	}
+	signer, err := loadSigner(*signKey)
+	if err != nil {
+		return err
+	}
+	r := &rotation{remove: map[string]bool{}, known: map[string]*rsa.PublicKey{}, require: *require, signer: signer}
+	for _, ref := range removed {
+		if fingerprintRegex.MatchString(ref) {
+			r.remove[ref] = true
+			continue
+		}
+		pub, err := readPublicKey(ref)
+		if err != nil {
+			return err
+		}
+		r.remove[keys.GetFingerprint(pub)] = true
+	}
+	if len(added) > 0 {
+		if r.add, err = loadPinnedKeys(added); err != nil {
+			return err
+		}
+	}
+	for _, pub := range r.add {
+		if r.remove[keys.GetFingerprint(pub)] {
+			return usagef(fs, "key %s is both added and removed", keys.GetFingerprint(pub))
+		}
+	}
+	if len(recipients) > 0 {
+		pubs, err := loadPinnedKeys(recipients)
+		if err != nil {
+			return err
+		}
+		for _, pub := range pubs {
+			r.known[keys.GetFingerprint(pub)] = pub
+		}
+	}
+	for _, pub := range r.add {
+		r.known[keys.GetFingerprint(pub)] = pub
+	}
+	for _, priv := range privs {
+		r.known[keys.GetFingerprint(&priv.PublicKey)] = &priv.PublicKey
+	}
+	if r.names, err = keyNames(append(append([]string{}, recipients...), added...)); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	rotated, skipped, files := 0, 0, 0
+	err = filepath.WalkDir(dir, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		affected := []scan.Block{}
+		for _, b := range scan.Blocks(data) {
+			if b.Err == nil && r.affects(b.Info) {
+				affected = append(affected, b)
+			}
+		}
+		if len(affected) == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		out, err := r.rotateFile(path, data, affected, privs)
+		for _, b := range affected {
+			if err != nil {
+				fmt.Fprintf(tw, "skipped\t%s@%d\t%s\n", rel, b.Offset, err)
+				continue
+			}
+			fmt.Fprintf(tw, "rotated\t%s@%d\t%s\n", rel, b.Offset, r.describe(b.Info))
+			if b.Info.Signer != "" && signer == nil {
+				fmt.Fprintf(stderr, "multikey: warning: %s@%d was signed by %s and is no longer signed, sign it with -sign KEY\n", rel, b.Offset, b.Info.Signer)
+			}
+		}
+		if err != nil {
+			skipped += len(affected)
+			return nil
+		}
+		rotated += len(affected)
+		files++
+		if *dryRun {
+			return nil
+		}
+		return replaceFile(path, out)
+	})
+	if flushErr := tw.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return err
+	}
+	verb := "rotated"
+	if *dryRun {
+		verb = "would rotate"
+	}
+	fmt.Fprintf(stdout, "%s %d secrets in %d files, skipped %d\n", verb, rotated, files, skipped)
+	return nil
+}
+
+// rotation describes how the recipients of secrets change
+type rotation struct {
+	remove  map[string]bool // by fingerprint
+	add     []*rsa.PublicKey
+	require int             // zero keeps the threshold of each secret
+	signer  *rsa.PrivateKey // nil leaves rotated secrets unsigned
+
+	known map[string]*rsa.PublicKey // by fingerprint
+	names map[string]string         // by fingerprint
+}
+
+// affects reports whether the recipients of a secret change: those
+// with a removed key, or if no keys are removed, those missing an added key
+func (r *rotation) affects(info *multikey.Info) bool {
+	ids := map[string]bool{}
+	for _, id := range info.KeyIDs {
+		if r.remove[id] {
+			return true
+		}
+		ids[id] = true
+	}
+	if len(r.remove) > 0 {
+		return false
+	}
+	for _, pub := range r.add {
+		if !ids[keys.GetFingerprint(pub)] {
+			return true
+		}
+	}
+	return false
+}
+
+// recipients returns the new recipients and threshold of a secret, or
+// no keys if it is not affected
+func (r *rotation) recipients(info *multikey.Info) ([]*rsa.PublicKey, int, error) {
+	if !r.affects(info) {
+		return nil, 0, nil
+	}
+	pubs := []*rsa.PublicKey{}
+	ids := map[string]bool{}
+	missing := []string{}
+	for _, id := range info.KeyIDs {
+		if r.remove[id] {
+			continue
+		}
+		pub, ok := r.known[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		ids[id] = true
+		pubs = append(pubs, pub)
+	}
+	if len(missing) > 0 {
+		return nil, 0, fmt.Errorf("public keys of recipients %s not found, provide them with -r", strings.Join(missing, ", "))
+	}
+	for _, pub := range r.add {
+		if fp := keys.GetFingerprint(pub); !ids[fp] {
+			ids[fp] = true
+			pubs = append(pubs, pub)
+		}
+	}
+	require := r.require
+	if require == 0 {
+		require = info.Threshold
+	}
+	if require == 0 {
+		return nil, 0, errors.New("threshold is not recorded, give it with -require")
+	}
+	if require > len(pubs) {
+		return nil, 0, fmt.Errorf("threshold %d is more than the %d remaining recipients", require, len(pubs))
+	}
+	return pubs, require, nil
+}
+
+// rotateFile rewraps the affected secrets of a file, returning its new
+// contents. Secrets within encrypted dotenv and structured files are
+// rewrapped through those formats, which authenticate them. Other secrets
+// must start on a line of their own.
+func (r *rotation) rotateFile(path string, data []byte, affected []scan.Block, privs []*rsa.PrivateKey) ([]byte, error) {
+	bound := false
+	for _, b := range affected {
+		bound = bound || b.Info.Bound
+	}
+	context, err := boundContext(path, bound)
+	if err != nil {
+		return nil, err
+	}
+	if dotenv.IsEncrypted(data) {
+		pubs, require, err := r.recipients(affected[0].Info)
+		if err != nil {
+			return nil, err
+		}
+		return dotenv.Rewrap(data, privs, pubs, require, &dotenv.Options{Rand: randReader, Context: context, Signer: r.signer})
+	}
+	if format, err := structured.FormatFromPath(path); err == nil {
+		out, err := structured.Rewrap(data, format, privs, r.recipients, &structured.Options{Rand: randReader, Context: context, Signer: r.signer})
+		if !errors.Is(err, structured.ErrNotEncrypted) {
+			return out, err
+		}
+	}
+
+	var out bytes.Buffer
+	pos := 0
+	for _, b := range affected {
+		if b.Offset > 0 && data[b.Offset-1] != '\n' {
+			return nil, errors.New("secret is embedded in a file of unknown format")
+		}
+		pubs, require, err := r.recipients(b.Info)
+		if err != nil {
+			return nil, err
+		}
+		blockContext, err := boundContext(path, b.Info.Bound)
+		if err != nil {
+			return nil, err
+		}
+		opts := &multikey.EncryptOptions{Rand: randReader, Signer: r.signer, Context: blockContext}
+		enc, err := multikey.RewrapWithOptions(string(data[b.Offset:b.Offset+b.Length])+"\n", privs, pubs, require, opts)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(data[pos:b.Offset])
+		out.WriteString(strings.TrimSuffix(enc, "\n"))
+		pos = b.Offset + b.Length
+	}
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
+
+// describe summarizes how the recipients of a secret change
+func (r *rotation) describe(info *multikey.Info) string {
+	pubs, require, err := r.recipients(info)
+	if err != nil {
+		return err.Error()
+	}
+	changes := []string{}
+	kept := map[string]bool{}
+	for _, pub := range pubs {
+		kept[keys.GetFingerprint(pub)] = true
+	}
+	existing := map[string]bool{}
+	for _, id := range info.KeyIDs {
+		existing[id] = true
+		if !kept[id] {
+			changes = append(changes, "-"+r.name(id))
+		}
+	}
+	for _, pub := range pubs {
+		if fp := keys.GetFingerprint(pub); !existing[fp] {
+			changes = append(changes, "+"+r.name(fp))
+		}
+	}
+	return fmt.Sprintf("%d of %d\t%s", require, len(pubs), strings.Join(changes, " "))
+}
+
+func (r *rotation) name(fp string) string {
+	if name, ok := r.names[fp]; ok {
+		return name
+	}
+	return fp
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/dotenv"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/scan"
+	"github.com/adrianosela/multikey/structured"
+	"github.com/stretchr/testify/assert"
+)
+
+// rotateTree creates a directory of secrets encrypted for alice, amongst
+// others, in the different ways multikey encrypts files
+func rotateTree(t *testing.T) (string, map[string]string) {
+	pubs := loadCLITestKeys(t)
+	alice, bob, carol := pubs[0], pubs[1], pubs[2]
+	dave, err := rsa.GenerateKey(rand.Reader, minKeyBits)
+	assert.Nil(t, err)
+	encrypt := func(data string, require int, pubs ...*rsa.PublicKey) string {
+		enc, err := multikey.Encrypt([]byte(data), pubs, require)
+		assert.Nil(t, err)
+		return enc
+	}
+	env, err := dotenv.Encrypt([]byte("A=1\n"), []*rsa.PublicKey{alice, bob}, 1, nil)
+	assert.Nil(t, err)
+	doc, err := structured.Encrypt([]byte("db:\n  password: p\napi:\n  token: t\n"), structured.YAML, []structured.Rule{
+		{PathRegex: "^/db/", Keys: []*rsa.PublicKey{alice, bob}, Require: 2},
+		{Keys: []*rsa.PublicKey{bob}, Require: 1},
+	}, nil)
+	assert.Nil(t, err)
+
+	files := map[string]string{
+		"app.secret":         encrypt("password=1\n", 2, alice, bob),
+		"config/app.env":     string(env),
+		"config/app.yaml":    string(doc),
+		"notes.md":           "# notes\n\n" + encrypt("note", 1, alice, bob) + "\nmore notes\n",
+		"other.secret":       encrypt("other", 1, bob, carol),
+		"locked.secret":      encrypt("locked", 3, alice, bob, &dave.PublicKey),
+		"dave.pub":           string(keys.EncodePubKeyPEM(&dave.PublicKey)),
+		"config/values.conf": "secret = |\n  " + strings.ReplaceAll(encrypt("value", 1, alice, bob), "\n", "\n  "),
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+	return dir, files
+}
+
+func TestRotate(t *testing.T) {
+	dir, files := rotateTree(t)
+	args := []string{"rotate", "-remove", "testdata/keys/alice.pub", "-add", "testdata/keys/carol.pub",
+		"-k", "testdata/keys/alice.pem", "-k", "testdata/keys/bob.pem", "-r", "testdata/keys", "-r", filepath.Join(dir, "dave.pub")}
+
+	// a dry run changes nothing
+	code, stdout, stderr := runCLI(t, nil, append(args, "-dry-run", dir)...)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.True(t, strings.HasSuffix(stdout, "would rotate 4 secrets in 4 files, skipped 2\n"), stdout)
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.Equal(t, data, string(got), name)
+	}
+
+	code, stdout, stderr = runCLI(t, nil, append(args, dir)...)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Regexp(t, `rotated  app.secret@0 +2 of 2  -alice \+carol\n`, stdout)
+	assert.Regexp(t, `rotated  config/app.env@\d+ +1 of 2  -alice \+carol\n`, stdout)
+	assert.Regexp(t, `skipped  locked.secret@0 +not enough keys were provided to decrypt the secret\n`, stdout)
+	assert.Regexp(t, `skipped  config/values.conf@13 +secret is embedded in a file of unknown format\n`, stdout)
+	assert.True(t, strings.HasSuffix(stdout, "rotated 4 secrets in 4 files, skipped 2\n"), stdout)
+
+	// alice is gone from every secret which could be opened, and bob and
+	// carol can decrypt them
+	alice := keys.GetFingerprint(loadCLITestKeys(t)[0])
+	secrets, err := scan.Dir(dir)
+	assert.Nil(t, err)
+	for _, s := range secrets {
+		if s.Path == "locked.secret" || s.Path == "config/values.conf" {
+			assert.Contains(t, s.Info.KeyIDs, alice)
+			continue
+		}
+		assert.NotContains(t, s.Info.KeyIDs, alice, s.Path)
+	}
+	privs, err := loadPrivateKeys([]string{"testdata/keys/bob.pem", "testdata/keys/carol.pem"})
+	assert.Nil(t, err)
+	read := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		return data
+	}
+	plain, err := multikey.Decrypt(string(read("app.secret")), privs)
+	assert.Nil(t, err)
+	assert.Equal(t, "password=1\n", string(plain))
+	vars, err := dotenv.Load(read("config/app.env"), privs[1:])
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"A": "1"}, vars)
+	plain, err = structured.DecryptPath(read("config/app.yaml"), structured.YAML, "/db/password", privs)
+	assert.Nil(t, err)
+	assert.Equal(t, "p", string(plain))
+	notes := string(read("notes.md"))
+	assert.True(t, strings.HasPrefix(notes, "# notes\n\n-----BEGIN"))
+	assert.True(t, strings.HasSuffix(notes, "-----\n\nmore notes\n"))
+	assert.Equal(t, files["other.secret"], string(read("other.secret")))
+	assert.Equal(t, files["locked.secret"], string(read("locked.secret")))
+
+	// nothing is left to rotate
+	code, stdout, _ = runCLI(t, nil, append(args, dir)...)
+	assert.Equal(t, exitOK, code)
+	assert.True(t, strings.HasSuffix(stdout, "rotated 0 secrets in 0 files, skipped 2\n"), stdout)
+}
+
+func TestRotateRecipientsNotFound(t *testing.T) {
+	dir, _ := rotateTree(t)
+	code, stdout, stderr := runCLI(t, nil, "rotate", "-remove", "testdata/keys/alice.pub",
+		"-k", "testdata/keys/alice.pem", "-k", "testdata/keys/carol.pem", dir)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Regexp(t, `skipped  app.secret@0 +public keys of recipients `+keys.GetFingerprint(loadCLITestKeys(t)[1])+` not found, provide them with -r\n`, stdout)
+
+	code, _, _ = runCLI(t, nil, "rotate", "-k", "testdata/keys/alice.pem", dir)
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestRotateLegacySecret(t *testing.T) {
+	dir := t.TempDir()
+	enc, err := os.ReadFile(filepath.Join("..", "..", "testdata", "legacy.secret"))
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "legacy.secret")
+	assert.Nil(t, os.WriteFile(path, enc, 0644))
+
+	// the secret requires 2 of its keys, which it does not record
+	code, stdout, stderr := runCLI(t, nil, "rotate", "-remove", "testdata/keys/carol.pub", "-k", "testdata/keys/alice.pem",
+		"-r", "testdata/keys/bob.pub", "-require", "2", dir)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Regexp(t, `skipped  legacy.secret@0 +not enough keys were provided to decrypt the secret\n`, stdout)
+	assert.True(t, strings.HasSuffix(stdout, "rotated 0 secrets in 0 files, skipped 1\n"), stdout)
+	after, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, enc, after)
+}
+
+func TestRotateSigned(t *testing.T) {
+	pubs := loadCLITestKeys(t)
+	alice, err := loadSigner("testdata/keys/alice.pem")
+	assert.Nil(t, err)
+	aliceID := keys.GetFingerprint(pubs[0])
+	enc, err := multikey.EncryptWithOptions([]byte("password=1\n"), pubs[:2], 1, &multikey.EncryptOptions{Signer: alice})
+	assert.Nil(t, err)
+	env, err := dotenv.Encrypt([]byte("A=1\n"), pubs[:2], 1, &dotenv.Options{Signer: alice})
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "app.secret"), []byte(enc), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "app.env"), env, 0644))
+	signers := func() map[string]string {
+		secrets, err := scan.Dir(dir)
+		assert.Nil(t, err)
+		found := map[string]string{}
+		for _, s := range secrets {
+			found[s.Path] = s.Info.Signer
+		}
+		return found
+	}
+
+	// rotated secrets are signed again with -sign
+	code, _, stderr := runCLI(t, nil, "rotate", "-add", "testdata/keys/carol.pub", "-k", "testdata/keys/alice.pem",
+		"-r", "testdata/keys", "-sign", "testdata/keys/alice.pem", dir)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, map[string]string{"app.secret": aliceID, "app.env": aliceID}, signers())
+	env, err = os.ReadFile(filepath.Join(dir, "app.env"))
+	assert.Nil(t, err)
+	vars, err := dotenv.LoadWithOptions(env, []*rsa.PrivateKey{alice}, &dotenv.Options{Signers: pubs[:1]})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"A": "1"}, vars)
+
+	// and lose their signature without it, which is warned about
+	code, _, stderr = runCLI(t, nil, "rotate", "-remove", "testdata/keys/carol.pub", "-k", "testdata/keys/alice.pem",
+		"-r", "testdata/keys", dir)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "app.secret@0 was signed by "+aliceID+" and is no longer signed, sign it with -sign KEY")
+	assert.Contains(t, stderr, "app.env@")
+	assert.Equal(t, map[string]string{"app.secret": "", "app.env": ""}, signers())
+}
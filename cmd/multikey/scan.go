@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/scan"
+)
+
+func runScan(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("scan", "[-r KEY|DIR ...] [-keyring FILE] [-json] [DIR]", stderr)
+	var recipients listFlag
+	fs.Var(&recipients, "r", "public key file, or directory of *"+pubKeyExt+" files, to name keys after (repeatable)")
+	fs.Var(&recipients, "recipient", "same as -r")
+	keyringPath := fs.String("keyring", "", keyringFlagUsage+", to name keys after")
+	asJSON := fs.Bool("json", false, "output the report as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usagef(fs, "at most one directory may be given")
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	names, err := keyNames(recipients)
+	if err != nil {
+		return err
+	}
+	ring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	revoked := map[string]bool{}
+	if ring != nil {
+		for fp, name := range ring.Names() {
+			names[fp] = name
+		}
+		for _, rv := range ring.Revocations() {
+			revoked[rv.Fingerprint] = true
+		}
+	}
+	secrets, err := scan.Dir(dir)
+	if err != nil {
+		return err
+	}
+	report := scan.NewReport(secrets, names)
+	report.FindRevoked(revoked)
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return writeScanReport(stdout, report)
+}
+
+// keyNames names the public keys at the given paths after their files,
+// by fingerprint
+func keyNames(paths []string) (map[string]string, error) {
+	files, err := expandKeyPaths(paths, pubKeyExt)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, f := range files {
+		pub, err := readPublicKey(f)
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(f)
+		names[keys.GetFingerprint(pub)] = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return names, nil
+}
+
+// writeScanReport writes a report as tables of the keys of each secret,
+// the secrets of each key, and the secrets revoked keys can help decrypt
+// if there are any
+func writeScanReport(w io.Writer, report *scan.Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tOFFSET\tTHRESHOLD\tKEYS")
+	for _, s := range report.Secrets {
+		if s.Error != "" {
+			fmt.Fprintf(tw, "%s\t%d\t-\terror: %s\n", s.Path, s.Offset, s.Error)
+			continue
+		}
+		threshold := "unknown"
+		if s.Threshold > 0 {
+			threshold = fmt.Sprintf("%d of %d", s.Threshold, len(s.Keys))
+		}
+		names := []string{}
+		for _, k := range s.Keys {
+			names = append(names, k.String())
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", s.Path, s.Offset, threshold, strings.Join(names, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSECRETS")
+	for _, k := range report.Keys {
+		locs := []string{}
+		for _, l := range k.Secrets {
+			locs = append(locs, fmt.Sprintf("%s@%d", l.Path, l.Offset))
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", k, strings.Join(locs, ", "))
+	}
+	if err := tw.Flush(); err != nil || len(report.Revoked) == 0 {
+		return err
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tOFFSET\tTHRESHOLD\tREVOKED KEYS\tEXPOSED")
+	for _, r := range report.Revoked {
+		names := []string{}
+		for _, k := range r.Keys {
+			names = append(names, k.String())
+		}
+		exposed := "no"
+		if r.Exposed {
+			exposed = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", r.Path, r.Offset, r.Threshold, strings.Join(names, ", "), exposed)
+	}
+	return tw.Flush()
+}
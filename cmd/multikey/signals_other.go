@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// forwardedSignals are relayed by exec to the command it runs
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// exitStatus returns the exit status of a process
+func exitStatus(state *os.ProcessState) int {
+	return state.ExitCode()
+}
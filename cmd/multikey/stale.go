@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adrianosela/multikey/scan"
+)
+
+func runStale(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("stale", "[-at DATE] [-json] [DIR]", stderr)
+	at := fs.String("at", "", "date to report stale secrets at, as YYYY-MM-DD or RFC 3339 (default now)")
+	asJSON := fs.Bool("json", false, "output the stale secrets as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usagef(fs, "at most one directory may be given")
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	now := time.Now()
+	if *at != "" {
+		var err error
+		if now, err = parseDate(*at); err != nil {
+			return usagef(fs, "-at must be formatted as YYYY-MM-DD or RFC 3339")
+		}
+	}
+	secrets, err := scan.Dir(dir)
+	if err != nil {
+		return err
+	}
+	stale := scan.Stale(secrets, now)
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Stale []scan.StaleSecret `json:"stale"`
+		}{stale}); err != nil {
+			return err
+		}
+	} else if err := writeStale(stdout, stale); err != nil {
+		return err
+	}
+	if len(stale) > 0 {
+		return &codedError{code: exitPolicyViolation, err: fmt.Errorf("%d stale secrets", len(stale))}
+	}
+	return nil
+}
+
+// writeStale writes a table of stale secrets, if there are any
+func writeStale(w io.Writer, stale []scan.StaleSecret) error {
+	if len(stale) == 0 {
+		return nil
+	}
+	date := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tOFFSET\tNAME\tROTATE-BY\tNOT-AFTER\tSTATUS")
+	for _, s := range stale {
+		name, status := s.Name, "rotate"
+		if name == "" {
+			name = "-"
+		}
+		if s.Expired {
+			status = "expired"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", s.Path, s.Offset, name, date(s.RotateBy), date(s.NotAfter), status)
+	}
+	return tw.Flush()
+}
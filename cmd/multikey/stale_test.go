@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/dotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStale(t *testing.T) {
+	dir := t.TempDir()
+	expired, overdue, fresh := filepath.Join(dir, "contractor.mk"), filepath.Join(dir, "db.mk"), filepath.Join(dir, "api.mk")
+	for _, args := range [][]string{
+		{"-out", expired, "-not-after", "2020-01-01"},
+		{"-out", overdue, "-name", "db-password", "-rotate-by", "2020-06-01T12:00:00Z", "-not-after", "2999-01-01"},
+		{"-out", fresh, "-rotate-by", "2999-01-01"},
+	} {
+		code, _, stderr := runCLI(t, []byte("hunter2"), append([]string{"encrypt", "-r", "testdata/keys/bob.pub"}, args...)...)
+		assert.Equal(t, exitOK, code, stderr)
+	}
+	code, _, _ := runCLI(t, []byte("hunter2"), "encrypt", "-r", "testdata/keys/bob.pub", "-not-after", "soon")
+	assert.Equal(t, exitUsage, code)
+
+	code, _, stderr := runCLI(t, nil, "decrypt", "-k", "testdata/keys/bob.pem", "-in", expired)
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Contains(t, stderr, "secret has expired on 2020-01-01T00:00:00Z, decrypt it anyway with -allow-expired")
+	code, stdout, stderr := runCLI(t, nil, "decrypt", "-k", "testdata/keys/bob.pem", "-in", expired, "-allow-expired")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "hunter2", stdout)
+
+	code, stdout, _ = runCLI(t, nil, "inspect", "-in", expired)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "  not-after:    2020-01-01T00:00:00Z (expired)\n")
+
+	code, stdout, _ = runCLI(t, nil, "stale", dir)
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Equal(t, strings.Join([]string{
+		"FILE           OFFSET  NAME         ROTATE-BY             NOT-AFTER             STATUS",
+		"contractor.mk  0       -            -                     2020-01-01T00:00:00Z  expired",
+		"db.mk          0       db-password  2020-06-01T12:00:00Z  2999-01-01T00:00:00Z  rotate",
+		"",
+	}, "\n"), stdout)
+	code, stdout, _ = runCLI(t, nil, "stale", "-at", "2019-12-31", dir)
+	assert.Equal(t, exitOK, code)
+	assert.Empty(t, stdout)
+	code, stdout, _ = runCLI(t, nil, "stale", "-at", "3000-01-01", "-json", dir)
+	assert.Equal(t, exitPolicyViolation, code)
+	var report struct {
+		Stale []struct {
+			Path    string `json:"file"`
+			Expired bool   `json:"expired"`
+		} `json:"stale"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Len(t, report.Stale, 3)
+	code, _, _ = runCLI(t, nil, "stale", "-at", "tomorrow", dir)
+	assert.Equal(t, exitUsage, code)
+
+	// expired secrets are renewed by updating their dates
+	code, _, stderr = runCLI(t, []byte("hunter3"), "encrypt", "-r", "testdata/keys/bob.pub", "-out", expired,
+		"-update", "-k", "testdata/keys/bob.pem", "-not-after", "2999-01-01")
+	assert.Equal(t, exitOK, code, stderr)
+	code, stdout, stderr = runCLI(t, nil, "decrypt", "-k", "testdata/keys/bob.pem", "-in", expired)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "hunter3", stdout)
+}
+
+func TestStaleDotenv(t *testing.T) {
+	pubs := loadCLITestKeys(t)
+	notAfter := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	enc, err := dotenv.Encrypt([]byte(testEnvFile), pubs, 1, &dotenv.Options{Metadata: &multikey.Metadata{NotAfter: notAfter}})
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "app.env")
+	assert.Nil(t, os.WriteFile(secrets, enc, 0644))
+	t.Setenv(testChildEnv, "env")
+
+	code, _, stderr := runCLI(t, nil, "exec", "-secrets", secrets, "-k", "testdata/keys/bob.pem", "--", os.Args[0], "0")
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Contains(t, stderr, "secret has expired on 2020-01-01T00:00:00Z, decrypt it anyway with -allow-expired")
+	code, stdout, stderr := runCLI(t, nil, "exec", "-secrets", secrets, "-k", "testdata/keys/bob.pem", "-allow-expired", "--", os.Args[0], "0", "DB_USER")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "DB_USER=app\n", stdout)
+
+	code, stdout, _ = runCLI(t, nil, "stale", dir)
+	assert.Equal(t, exitPolicyViolation, code)
+	assert.Regexp(t, `app.env +\d+ +- +- +2020-01-01T00:00:00Z +expired\n`, stdout)
+}
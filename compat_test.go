@@ -0,0 +1,65 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// testdata/legacy.secret was encrypted for alice, bob and carol, requiring
+// 2 keys, before secrets recorded their version and threshold
+func TestLegacySecretCompatibility(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	enc, err := os.ReadFile(filepath.Join("testdata", "legacy.secret"))
+	assert.Nil(t, err)
+
+	info, err := Inspect(string(enc))
+	assert.Nil(t, err)
+	assert.Equal(t, legacyVersion, info.Version)
+	assert.Equal(t, 0, info.Threshold)
+	assert.Equal(t, []string{
+		keys.GetFingerprint(pubs[0]),
+		keys.GetFingerprint(pubs[1]),
+		keys.GetFingerprint(pubs[2]),
+	}, info.KeyIDs)
+
+	for _, quorum := range [][]int{{0, 1}, {1, 2}, {0, 2}, {0, 1, 2}} {
+		ks := []*rsa.PrivateKey{}
+		for _, i := range quorum {
+			ks = append(ks, privs[i])
+		}
+		plain, err := Decrypt(string(enc), ks)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("legacy secret value"), plain)
+	}
+
+	// secrets encrypted now are of the current version, which legacy
+	// versions of this package can not decrypt
+	reenc, err := Encrypt([]byte("legacy secret value"), pubs, 2)
+	assert.Nil(t, err)
+	info, err = Inspect(reenc)
+	assert.Nil(t, err)
+	assert.Equal(t, currentVersion, info.Version)
+	assert.Equal(t, 2, info.Threshold)
+}
+
+// rewrapping a legacy secret with fewer of its keys than its unrecorded
+// threshold would combine its shards into garbage
+func TestRewrapLegacySecret(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	enc, err := os.ReadFile(filepath.Join("testdata", "legacy.secret"))
+	assert.Nil(t, err)
+
+	_, err = Rewrap(string(enc), privs[:1], pubs[:2], 2)
+	assert.Equal(t, ErrInsufficientKeys, err)
+
+	rewrapped, err := Rewrap(string(enc), privs[:2], pubs[:2], 2)
+	assert.Nil(t, err)
+	plain, err := Decrypt(rewrapped, privs[:2])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("legacy secret value"), plain)
+}
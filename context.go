@@ -0,0 +1,37 @@
+package multikey
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// headerContext marks secrets bound to a context. The context itself
+	// is not recorded: it is supplied again by whoever decrypts the secret.
+	headerContext = "Context"
+	contextBound  = "bound"
+
+	errMsgContextMismatch = "secret is bound to a different context"
+
+	errMsgAlteredOrContextMismatch = "the secret was altered or is bound to a different context"
+)
+
+// ErrContextMismatch is returned when decrypting a secret bound to a
+// context without one. Decrypting it with a different context, such as
+// after it was copied to another file, fails with ErrAuthenticationFailed.
+var ErrContextMismatch = errors.New(errMsgContextMismatch)
+
+// bind binds a secret to a context, or unbinds it if the context is empty
+func (s *secret) bind(context string) {
+	s.bound, s.context = context != "", context
+}
+
+// contextData returns the encoding of the context a secret is bound to,
+// authenticated as part of its payload's associated data
+func (s *secret) contextData() string {
+	if !s.bound {
+		return ""
+	}
+	return fmt.Sprintf("context: %s%s", strconv.Quote(s.context), simpleFmtSeparator)
+}
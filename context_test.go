@@ -0,0 +1,101 @@
+package multikey
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextBinding(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	testSecret := []byte("hunter2")
+	enc, err := EncryptWithOptions(testSecret, pubs, 2, &EncryptOptions{Context: "prod/db-password.mk"})
+	assert.Nil(t, err)
+	assert.Contains(t, enc, "Context: bound")
+	assert.NotContains(t, enc, "prod/db-password.mk")
+	unbound, err := Encrypt(testSecret, pubs, 2)
+	assert.Nil(t, err)
+	named, err := EncryptWithOptions(testSecret, pubs, 2, &EncryptOptions{Context: "prod/db-password.mk", Metadata: &Metadata{Name: "db"}})
+	assert.Nil(t, err)
+	altered := fmt.Errorf("%w: %s", ErrAuthenticationFailed, errMsgAlteredOrContextMismatch)
+
+	info, err := Inspect(enc)
+	assert.Nil(t, err)
+	assert.True(t, info.Bound)
+
+	tests := []struct {
+		testName  string
+		enc       string
+		context   string
+		expectErr error
+	}{
+		{testName: "same context", enc: enc, context: "prod/db-password.mk"},
+		{testName: "other context", enc: enc, context: "staging/db-password.mk", expectErr: altered},
+		{testName: "no context", enc: enc, expectErr: ErrContextMismatch},
+		{testName: "unbound secret with a context", enc: unbound, context: "staging/db-password.mk"},
+		{
+			testName:  "binding removed",
+			enc:       strings.Replace(enc, "Context: bound\n", "", 1),
+			context:   "prod/db-password.mk",
+			expectErr: ErrAuthenticationFailed,
+		},
+		{
+			testName:  "altered",
+			enc:       strings.Replace(named, "Name: db\n", "Name: web\n", 1),
+			context:   "prod/db-password.mk",
+			expectErr: altered,
+		},
+		{
+			testName:  "altered without context",
+			enc:       strings.Replace(named, "Name: db\n", "Name: web\n", 1),
+			expectErr: ErrContextMismatch,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			plain, err := DecryptWithOptions(test.enc, privs[:2], &DecryptOptions{Context: test.context})
+			if test.expectErr != nil {
+				assert.EqualError(t, err, test.expectErr.Error())
+				assert.True(t, errors.Is(err, ErrContextMismatch) || errors.Is(err, ErrAuthenticationFailed))
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, testSecret, plain)
+		})
+	}
+}
+
+func TestUpdateContext(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	prod := &EncryptOptions{Context: "prod"}
+	enc, err := EncryptWithOptions([]byte("v1"), pubs, 2, prod)
+	assert.Nil(t, err)
+
+	updated, err := UpdateWithOptions(enc, []byte("v1"), privs, pubs, 2, prod)
+	assert.Nil(t, err)
+	assert.Equal(t, enc, updated)
+	_, err = UpdateWithOptions(enc, []byte("v2"), privs, pubs, 2, &EncryptOptions{Context: "staging"})
+	assert.True(t, errors.Is(err, ErrAuthenticationFailed))
+
+	// unbound secrets are bound by updating them with a context
+	unbound, err := Encrypt([]byte("v1"), pubs, 2)
+	assert.Nil(t, err)
+	updated, err = UpdateWithOptions(unbound, []byte("v1"), privs, pubs, 2, prod)
+	assert.Nil(t, err)
+	_, err = Decrypt(updated, privs)
+	assert.Equal(t, ErrContextMismatch, err)
+	plain, err := DecryptWithOptions(updated, privs, &DecryptOptions{Context: "prod"})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), plain)
+
+	rewrapped, err := RewrapWithOptions(enc, privs, pubs[1:], 1, prod)
+	assert.Nil(t, err)
+	plain, err = DecryptWithOptions(rewrapped, privs[2:], &DecryptOptions{Context: "prod"})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), plain)
+	_, err = RewrapWithOptions(enc, privs, pubs[1:], 1, nil)
+	assert.Equal(t, ErrContextMismatch, err)
+}
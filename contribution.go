@@ -0,0 +1,230 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adrianosela/multikey/keys"
+)
+
+const (
+	contributionPEMBlockType = "MULTIKEY CONTRIBUTION"
+	contributionSigContext   = "multikey contribution v1"
+
+	contributionHeaderRequestID = "Request-Id"
+	contributionHeaderSecretID  = "Secret-Id"
+	contributionHeaderCombiner  = "Combiner"
+	contributionHeaderExpires   = "Expires"
+	contributionHeaderHolder    = "Holder"
+	contributionHeaderSignature = "Signature"
+
+	errMsgCouldNotDecodeContribution = "could not decode contribution"
+	errMsgBadContributionSignature   = "contribution signature is invalid"
+	errMsgContributionExpired        = "contribution has expired"
+	errMsgContributionWrongSecret    = "contribution is for a different secret"
+	errMsgContributionWrongRequest   = "contribution is for a different request"
+	errMsgContributionWrongCombiner  = "contribution is for a different combiner"
+	errMsgContributionUnknownHolder  = "contribution holder has no shard in the secret"
+	errMsgInvalidRequestID           = "request id must be non-empty and a single line"
+)
+
+// Contribution is a single key holder's shard of a secret, decrypted by
+// the holder and re-encrypted for a designated combiner. Contributions
+// allow a secret to be decrypted without all of the required private keys
+// ever being in the same place: each holder produces a contribution with
+// Contribute, and the combiner reconstructs the secret with
+// CombineContributions once enough of them have been gathered.
+//
+// Contributions are signed by the holder and bound to a single secret,
+// request and combiner, and expire at a given time.
+type Contribution struct {
+	RequestID string
+	SecretID  string
+	Combiner  string
+	Holder    *rsa.PublicKey
+	ExpiresAt time.Time
+
+	value     []byte
+	signature []byte
+}
+
+// Contribute decrypts the holder's shard of an encrypted secret and
+// re-encrypts it for the combiner, returning a signed contribution which
+// expires at the given time.
+func Contribute(enc string, holder *rsa.PrivateKey, combiner *rsa.PublicKey, requestID string, expiresAt time.Time) (string, error) {
+	if requestID == "" || strings.ContainsAny(requestID, "\r\n") {
+		return "", errors.New(errMsgInvalidRequestID)
+	}
+	secretID, err := SecretID(enc)
+	if err != nil {
+		return "", err
+	}
+	share, err := DecryptShare(enc, holder)
+	if err != nil {
+		return "", err
+	}
+	value, err := keys.EncryptMessage(share, combiner)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", errMsgCouldNotEncrypt, err)
+	}
+	c := &Contribution{
+		RequestID: requestID,
+		SecretID:  secretID,
+		Combiner:  keys.GetFingerprint(combiner),
+		Holder:    &holder.PublicKey,
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+		value:     value,
+	}
+	if c.signature, err = keys.SignMessage(c.signedBytes(), holder); err != nil {
+		return "", fmt.Errorf("could not sign contribution: %s", err)
+	}
+	return c.Encode(), nil
+}
+
+// DecodeContribution parses a contribution and verifies its signature.
+// Whether it is bound to the expected secret, request and combiner, and
+// whether it has expired, is checked by Check.
+func DecodeContribution(s string) (*Contribution, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != contributionPEMBlockType {
+		return nil, errors.New(errMsgCouldNotDecodeContribution)
+	}
+	h := block.Headers
+	holderDER, err := base64.StdEncoding.DecodeString(h[contributionHeaderHolder])
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad holder: %s", errMsgCouldNotDecodeContribution, err)
+	}
+	holder, err := x509.ParsePKCS1PublicKey(holderDER)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad holder: %s", errMsgCouldNotDecodeContribution, err)
+	}
+	expiresAt, err := time.Parse(time.RFC3339, h[contributionHeaderExpires])
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad expiry: %s", errMsgCouldNotDecodeContribution, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(h[contributionHeaderSignature])
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad signature: %s", errMsgCouldNotDecodeContribution, err)
+	}
+	c := &Contribution{
+		RequestID: h[contributionHeaderRequestID],
+		SecretID:  h[contributionHeaderSecretID],
+		Combiner:  h[contributionHeaderCombiner],
+		Holder:    holder,
+		ExpiresAt: expiresAt,
+		value:     block.Bytes,
+		signature: sig,
+	}
+	if err := keys.VerifySignature(c.signedBytes(), c.signature, c.Holder); err != nil {
+		return nil, errors.New(errMsgBadContributionSignature)
+	}
+	return c, nil
+}
+
+// Encode returns the contribution in a PEM block
+func (c *Contribution) Encode() string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type: contributionPEMBlockType,
+		Headers: map[string]string{
+			contributionHeaderRequestID: c.RequestID,
+			contributionHeaderSecretID:  c.SecretID,
+			contributionHeaderCombiner:  c.Combiner,
+			contributionHeaderExpires:   c.ExpiresAt.UTC().Format(time.RFC3339),
+			contributionHeaderHolder:    base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(c.Holder)),
+			contributionHeaderSignature: base64.StdEncoding.EncodeToString(c.signature),
+		},
+		Bytes: c.value,
+	}))
+}
+
+// HolderID returns the fingerprint of the key which made the contribution
+func (c *Contribution) HolderID() string {
+	return keys.GetFingerprint(c.Holder)
+}
+
+// Check verifies that the contribution is bound to the given encrypted
+// secret, request and combiner key fingerprint, that the holder has a
+// shard in the secret, and that the contribution has not expired.
+func (c *Contribution) Check(enc string, requestID string, combinerID string, now time.Time) error {
+	secretID, err := SecretID(enc)
+	if err != nil {
+		return err
+	}
+	if c.SecretID != secretID {
+		return errors.New(errMsgContributionWrongSecret)
+	}
+	if c.RequestID != requestID {
+		return errors.New(errMsgContributionWrongRequest)
+	}
+	if c.Combiner != combinerID {
+		return errors.New(errMsgContributionWrongCombiner)
+	}
+	if !now.Before(c.ExpiresAt) {
+		return errors.New(errMsgContributionExpired)
+	}
+	s, err := decodePEM(enc)
+	if err != nil {
+		return ErrMalformedSecret
+	}
+	holderID := c.HolderID()
+	for _, sh := range s.shards {
+		if sh.KeyID == holderID {
+			return nil
+		}
+	}
+	return errors.New(errMsgContributionUnknownHolder)
+}
+
+// CombineContributions reconstructs a secret from the contributions of
+// its key holders, using the combiner's private key. Every contribution
+// must be valid for the given secret and request; a single bad one fails
+// the whole operation rather than being silently skipped.
+func CombineContributions(enc string, contributions []string, combiner *rsa.PrivateKey, requestID string) ([]byte, error) {
+	s, err := decodePEM(enc)
+	if err != nil {
+		return nil, ErrMalformedSecret
+	}
+	combinerID := keys.GetFingerprint(&combiner.PublicKey)
+	now := time.Now()
+	seen := map[string]bool{}
+	parts := [][]byte{}
+	for i, raw := range contributions {
+		c, err := DecodeContribution(raw)
+		if err != nil {
+			return nil, fmt.Errorf("contribution %d: %s", i, err)
+		}
+		if err := c.Check(enc, requestID, combinerID, now); err != nil {
+			return nil, fmt.Errorf("contribution %d: %s", i, err)
+		}
+		if seen[c.HolderID()] {
+			continue // the same holder contributing twice adds nothing
+		}
+		seen[c.HolderID()] = true
+		share, err := keys.DecryptMessage(c.value, combiner)
+		if err != nil {
+			return nil, fmt.Errorf("contribution %d: %s: %s", i, errMsgCouldNotDecrypt, err)
+		}
+		parts = append(parts, share)
+	}
+	return s.open(parts)
+}
+
+// signedBytes returns the canonical representation of the contribution
+// covered by the holder's signature
+func (c *Contribution) signedBytes() []byte {
+	return []byte(strings.Join([]string{
+		contributionSigContext,
+		c.RequestID,
+		c.SecretID,
+		c.Combiner,
+		c.HolderID(),
+		c.ExpiresAt.UTC().Format(time.RFC3339),
+		base64.StdEncoding.EncodeToString(c.value),
+	}, "\n"))
+}
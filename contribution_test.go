@@ -0,0 +1,119 @@
+package multikey
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContributeAndCombine(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	combiner, _, err := keys.GenerateRSAKeyPair(2048)
+	if err != nil {
+		assert.FailNow(t, "could not generate combiner key")
+	}
+	testSecret := []byte("test secret value")
+	enc, err := Encrypt(testSecret, pubs, 2)
+	assert.Nil(t, err)
+
+	expiry := time.Now().Add(time.Hour)
+	contributions := []string{}
+	for _, holder := range privs[:2] {
+		c, err := Contribute(enc, holder, &combiner.PublicKey, "req-1", expiry)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(c, "-----BEGIN "+contributionPEMBlockType))
+		contributions = append(contributions, c)
+	}
+
+	plain, err := CombineContributions(enc, contributions, combiner, "req-1")
+	assert.Nil(t, err)
+	assert.Equal(t, testSecret, plain)
+
+	// a repeated contribution does not count twice
+	_, err = CombineContributions(enc, []string{contributions[0], contributions[0]}, combiner, "req-1")
+	assert.Equal(t, ErrInsufficientKeys, err)
+
+	decoded, err := DecodeContribution(contributions[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "req-1", decoded.RequestID)
+	assert.Equal(t, keys.GetFingerprint(pubs[1]), decoded.HolderID())
+	assert.Equal(t, keys.GetFingerprint(&combiner.PublicKey), decoded.Combiner)
+}
+
+func TestCombineContributionsRejects(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	combiner, _, err := keys.GenerateRSAKeyPair(2048)
+	if err != nil {
+		assert.FailNow(t, "could not generate combiner key")
+	}
+	enc, err := Encrypt([]byte("test secret value"), pubs, 2)
+	assert.Nil(t, err)
+	otherEnc, err := Encrypt([]byte("another secret value"), pubs, 2)
+	assert.Nil(t, err)
+
+	valid, err := Contribute(enc, privs[0], &combiner.PublicKey, "req-1", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	expired, err := Contribute(enc, privs[1], &combiner.PublicKey, "req-1", time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	otherRequest, err := Contribute(enc, privs[1], &combiner.PublicKey, "req-2", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	otherSecret, err := Contribute(otherEnc, privs[1], &combiner.PublicKey, "req-1", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	otherCombiner, err := Contribute(enc, privs[1], pubs[2], "req-1", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	tampered := strings.Replace(valid, "Request-Id: req-1", "Request-Id: req-9", 1)
+
+	tests := []struct {
+		testName     string
+		contribution string
+		expectedErr  string
+	}{
+		{testName: "expired", contribution: expired, expectedErr: errMsgContributionExpired},
+		{testName: "other request", contribution: otherRequest, expectedErr: errMsgContributionWrongRequest},
+		{testName: "other secret", contribution: otherSecret, expectedErr: errMsgContributionWrongSecret},
+		{testName: "other combiner", contribution: otherCombiner, expectedErr: errMsgContributionWrongCombiner},
+		{testName: "tampered", contribution: tampered, expectedErr: errMsgBadContributionSignature},
+		{testName: "garbage", contribution: "garbage", expectedErr: errMsgCouldNotDecodeContribution},
+	}
+	for _, test := range tests {
+		_, err := CombineContributions(enc, []string{valid, test.contribution}, combiner, "req-1")
+		assert.EqualError(t, err, "contribution 1: "+test.expectedErr, test.testName)
+	}
+
+	// a holder with no shard in the secret
+	outsider, err := Contribute(otherEnc, privs[2], &combiner.PublicKey, "req-1", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	onlyTwo, err := Encrypt([]byte("test secret value"), pubs[:2], 2)
+	assert.Nil(t, err)
+	c, err := DecodeContribution(outsider)
+	assert.Nil(t, err)
+	c.SecretID, _ = SecretID(onlyTwo)
+	assert.EqualError(t, c.Check(onlyTwo, "req-1", c.Combiner, time.Now()), errMsgContributionUnknownHolder)
+
+	// invalid request ids
+	_, err = Contribute(enc, privs[0], &combiner.PublicKey, "", time.Now().Add(time.Hour))
+	assert.EqualError(t, err, errMsgInvalidRequestID)
+	_, err = Contribute(enc, privs[0], &combiner.PublicKey, "a\nb", time.Now().Add(time.Hour))
+	assert.EqualError(t, err, errMsgInvalidRequestID)
+}
+
+func TestSecretID(t *testing.T) {
+	_, pubs := loadTestKeys(t, "alice")
+	enc, err := Encrypt([]byte("test secret value"), pubs, 1)
+	assert.Nil(t, err)
+
+	id, err := SecretID(enc)
+	assert.Nil(t, err)
+	assert.Len(t, id, 64)
+
+	// surrounding whitespace and line endings do not matter
+	crlf, err := SecretID("\n\n" + strings.ReplaceAll(enc, "\n", "\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, id, crlf)
+
+	_, err = SecretID("not a secret")
+	assert.EqualError(t, err, errMsgCouldNotDecodePEM)
+}
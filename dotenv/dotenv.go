@@ -0,0 +1,148 @@
+// Package dotenv parses and writes dotenv (.env) files, which hold
+// environment variables as NAME=value lines.
+package dotenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Variable is a single environment variable
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// Parse parses the variables of a dotenv file, in the order in which they
+// appear. The following syntax is supported:
+//
+//	# comments and blank lines are ignored
+//	NAME=value           # unquoted values are trimmed, and end at " #"
+//	export NAME=value    # the export keyword is optional
+//	NAME="line\nbreak"   # double quotes support \n, \r, \t, \", \$ and \\ escapes
+//	NAME='$literal'      # single quoted values are taken literally
+func Parse(data []byte) ([]Variable, error) {
+	f, err := ParseFile(data)
+	if err != nil {
+		return nil, err
+	}
+	return f.Variables(), nil
+}
+
+// ParseMap parses a dotenv file as per Parse, returning its variables in
+// a map. Where a name is repeated the last value wins.
+func ParseMap(data []byte) (map[string]string, error) {
+	vars, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(vars))
+	for _, v := range vars {
+		m[v.Name] = v.Value
+	}
+	return m, nil
+}
+
+// parseLine parses a single line of a dotenv file
+func parseLine(raw string) (line, error) {
+	l := line{raw: raw}
+	text := strings.TrimSpace(raw)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return l, nil
+	}
+	if strings.HasPrefix(text, "export ") {
+		l.export = true
+		text = strings.TrimSpace(strings.TrimPrefix(text, "export "))
+	}
+	eq := strings.IndexByte(text, '=')
+	if eq < 0 {
+		return l, fmt.Errorf("expected NAME=value")
+	}
+	name := strings.TrimSpace(text[:eq])
+	if !validName(name) {
+		return l, fmt.Errorf("invalid variable name %q", name)
+	}
+	value, comment, err := parseValue(strings.TrimSpace(text[eq+1:]))
+	if err != nil {
+		return l, fmt.Errorf("%s: %s", name, err)
+	}
+	l.isVar, l.name, l.value, l.comment = true, name, value, comment
+	return l, nil
+}
+
+// parseValue parses a value and the comment which may follow it
+func parseValue(raw string) (string, string, error) {
+	if raw == "" {
+		return "", "", nil
+	}
+	switch raw[0] {
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated single quoted value")
+		}
+		comment, err := trailingComment(raw[end+2:])
+		return raw[1 : end+1], comment, err
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(raw); i++ {
+			switch c := raw[i]; c {
+			case '"':
+				comment, err := trailingComment(raw[i+1:])
+				return b.String(), comment, err
+			case '\\':
+				if i+1 == len(raw) {
+					return "", "", fmt.Errorf("unterminated double quoted value")
+				}
+				i++
+				switch raw[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\', '$':
+					b.WriteByte(raw[i])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(raw[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", "", fmt.Errorf("unterminated double quoted value")
+	}
+	comment := ""
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw, comment = raw[:i], strings.TrimSpace(raw[i:])
+	}
+	return strings.TrimSpace(raw), comment, nil
+}
+
+// trailingComment returns the comment following a quoted value, verifying
+// that nothing else follows it
+func trailingComment(rest string) (string, error) {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected characters after quoted value")
+	}
+	return rest, nil
+}
+
+// validName reports whether name is a valid environment variable name
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package dotenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`# database
+DB_HOST=localhost
+export DB_PORT = 5432 # default port
+DB_PASSWORD="p@ss \"word\"\n"
+DB_TEMPLATE='$HOME\n'
+
+EMPTY=
+URL=http://example.com/#anchor
+`)
+	vars, err := Parse(data)
+	assert.Nil(t, err)
+	assert.Equal(t, []Variable{
+		{Name: "DB_HOST", Value: "localhost"},
+		{Name: "DB_PORT", Value: "5432"},
+		{Name: "DB_PASSWORD", Value: "p@ss \"word\"\n"},
+		{Name: "DB_TEMPLATE", Value: `$HOME\n`},
+		{Name: "EMPTY", Value: ""},
+		{Name: "URL", Value: "http://example.com/#anchor"},
+	}, vars)
+}
+
+func TestParseMap(t *testing.T) {
+	m, err := ParseMap([]byte("A=1\nB=2\nA=3\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"A": "3", "B": "2"}, m)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{
+			name:  "missing equals",
+			data:  "A=1\nJUSTANAME\n",
+			error: "line 2: expected NAME=value",
+		},
+		{
+			name:  "invalid name",
+			data:  "1A=1\n",
+			error: `line 1: invalid variable name "1A"`,
+		},
+		{
+			name:  "unterminated double quote",
+			data:  `A="abc`,
+			error: "line 1: A: unterminated double quoted value",
+		},
+		{
+			name:  "unterminated single quote",
+			data:  `A='abc`,
+			error: "line 1: A: unterminated single quoted value",
+		},
+		{
+			name:  "trailing characters",
+			data:  `A="abc" def`,
+			error: "line 1: A: unexpected characters after quoted value",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.data))
+			assert.EqualError(t, err, test.error)
+		})
+	}
+}
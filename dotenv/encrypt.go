@@ -0,0 +1,352 @@
+package dotenv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/adrianosela/multikey"
+)
+
+// Encrypted dotenv files keep their variable names, comments and ordering
+// in plaintext, while each value is encrypted:
+//
+//	# database settings
+//	DB_USER=MK[Vq3o...]
+//	DB_PASSWORD=MK[0cX1...]
+//
+//	#multikey:mac=Yk2P...
+//	#multikey:-----BEGIN MULTIKEY ENCRYPTED SECRET-----
+//	#multikey:...
+//
+// All of the values of a file are encrypted with AES-256-GCM under one
+// data key, bound to their variable's name. The data key is a multikey
+// secret kept in the trailing header comments, which also carry a MAC
+// over every variable so that removing or reordering them is detected.
+// Being comments, the header keeps the file valid dotenv syntax.
+
+const (
+	headerPrefix = "#multikey:"
+	macPrefix    = "mac="
+	macContext   = "multikey dotenv v1"
+	dataKeySize  = 32
+
+	valuePrefix = "MK["
+	valueSuffix = "]"
+
+	errMsgNotEncrypted     = "dotenv file is not encrypted"
+	errMsgAlreadyEncrypted = "dotenv file is already encrypted"
+	errMsgTampered         = "dotenv file MAC does not match, it has been tampered with"
+	errMsgBadHeader        = "invalid multikey header"
+)
+
+var (
+	// ErrNotEncrypted is returned when decrypting a file which has no
+	// multikey header
+	ErrNotEncrypted = errors.New(errMsgNotEncrypted)
+
+	// ErrTampered is returned when a file's MAC does not match its variables
+	ErrTampered = errors.New(errMsgTampered)
+)
+
+// Options configures optional behaviour of Encrypt, Rewrap and the
+// WithOptions variants of Decrypt, Load and Setenv
+type Options struct {
+	// Rand is the source of randomness used to encrypt the file.
+	// Defaults to crypto/rand.Reader when nil; setting it to a
+	// deterministic stream is only ever appropriate in tests.
+	Rand io.Reader
+
+	// Context binds the file to a context such as its path, as with
+	// multikey.EncryptOptions. The same context is required to decrypt it.
+	Context string
+
+	// Signer, when set, signs the file's data key as its author, as with
+	// multikey.EncryptOptions. Rewrapping a file without it unsigns it.
+	Signer *rsa.PrivateKey
+
+	// Signers and SignerPolicy decide which keys are trusted to sign the
+	// file's data key when decrypting it, as with multikey.DecryptOptions
+	Signers      []*rsa.PublicKey
+	SignerPolicy multikey.SignerPolicy
+
+	// Metadata, when set, describes the file's data key, as with
+	// multikey.EncryptOptions, such as when it is due to be rotated by or
+	// expires. Rewrapping a file without it keeps its metadata.
+	Metadata *multikey.Metadata
+
+	// AllowExpired allows decrypting files past their not after time
+	AllowExpired bool
+}
+
+func (o *Options) rand() io.Reader {
+	if o == nil || o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
+func (o *Options) context() string {
+	if o == nil {
+		return ""
+	}
+	return o.Context
+}
+
+// encryptOptions returns the options with which to encrypt a file's data key
+func (o *Options) encryptOptions() *multikey.EncryptOptions {
+	opts := &multikey.EncryptOptions{Rand: o.rand(), Context: o.context()}
+	if o != nil {
+		opts.Signer = o.Signer
+		opts.Metadata = o.Metadata
+	}
+	return opts
+}
+
+// decryptOptions returns the options with which to decrypt a file's data key
+func (o *Options) decryptOptions() *multikey.DecryptOptions {
+	opts := &multikey.DecryptOptions{Context: o.context()}
+	if o != nil {
+		opts.Signers = o.Signers
+		opts.SignerPolicy = o.SignerPolicy
+		opts.AllowExpired = o.AllowExpired
+	}
+	return opts
+}
+
+// IsEncrypted reports whether data is an encrypted dotenv file
+func IsEncrypted(data []byte) bool {
+	for _, l := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(l, headerPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Encrypt encrypts the values of a dotenv file with a given set of public
+// keys. The values will be decryptable with `require` of the given keys.
+func Encrypt(data []byte, pubs []*rsa.PublicKey, require int, opts *Options) ([]byte, error) {
+	if IsEncrypted(data) {
+		return nil, errors.New(errMsgAlreadyEncrypted)
+	}
+	f, err := ParseFile(data)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(opts.rand(), key); err != nil {
+		return nil, fmt.Errorf("could not create data key: %s", err)
+	}
+	secret, err := multikey.EncryptWithOptions(key, pubs, require, opts.encryptOptions())
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range f.lines {
+		if !l.isVar {
+			continue
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(opts.rand(), nonce); err != nil {
+			return nil, fmt.Errorf("could not create nonce: %s", err)
+		}
+		sealed := aead.Seal(nonce, nonce, []byte(l.value), []byte(l.name))
+		f.lines[i] = l.withValue(valuePrefix + base64.StdEncoding.EncodeToString(sealed) + valueSuffix)
+	}
+
+	appendHeader(f, key, secret)
+	return f.Bytes(), nil
+}
+
+// Rewrap re-encrypts the data key of an encrypted dotenv file for a new
+// set of recipients and threshold, as multikey.Rewrap does. The values of
+// the file are kept as they are, only its header changes. As with
+// multikey.Rewrap, expired files can be rewrapped.
+func Rewrap(data []byte, privs []*rsa.PrivateKey, pubs []*rsa.PublicKey, require int, opts *Options) ([]byte, error) {
+	dec := opts.decryptOptions()
+	dec.AllowExpired = true
+	f, key, secret, err := openFile(data, privs, dec)
+	if err != nil {
+		return nil, err
+	}
+	secret, err = multikey.RewrapWithOptions(secret, privs, pubs, require, opts.encryptOptions())
+	if err != nil {
+		return nil, err
+	}
+	appendHeader(f, key, secret)
+	return f.Bytes(), nil
+}
+
+// appendHeader appends the MAC and data key of an encrypted file to it
+func appendHeader(f *File, key []byte, secret string) {
+	mac := computeMAC(key, f, secret)
+	f.lines = append(f.lines, line{raw: headerPrefix + macPrefix + base64.StdEncoding.EncodeToString(mac)})
+	for _, l := range strings.Split(strings.TrimSuffix(secret, "\n"), "\n") {
+		f.lines = append(f.lines, line{raw: headerPrefix + l})
+	}
+}
+
+// Decrypt decrypts an encrypted dotenv file, returning it with its
+// comments and ordering intact and without its multikey header
+func Decrypt(data []byte, privs []*rsa.PrivateKey) ([]byte, error) {
+	return DecryptWithOptions(data, privs, nil)
+}
+
+// DecryptWithOptions is like Decrypt, for files bound to the context of
+// the given options or which must be signed by a key they trust
+func DecryptWithOptions(data []byte, privs []*rsa.PrivateKey, opts *Options) ([]byte, error) {
+	f, err := decryptFile(data, privs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return f.Bytes(), nil
+}
+
+// Load decrypts an encrypted dotenv file and returns its variables
+func Load(data []byte, privs []*rsa.PrivateKey) (map[string]string, error) {
+	return LoadWithOptions(data, privs, nil)
+}
+
+// LoadWithOptions is like Load, for files bound to the context of the
+// given options or which must be signed by a key they trust
+func LoadWithOptions(data []byte, privs []*rsa.PrivateKey, opts *Options) (map[string]string, error) {
+	f, err := decryptFile(data, privs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return f.Map(), nil
+}
+
+// Setenv decrypts an encrypted dotenv file and sets its variables in the
+// environment of the current process
+func Setenv(data []byte, privs []*rsa.PrivateKey) error {
+	return SetenvWithOptions(data, privs, nil)
+}
+
+// SetenvWithOptions is like Setenv, for files bound to the context of the
+// given options or which must be signed by a key they trust
+func SetenvWithOptions(data []byte, privs []*rsa.PrivateKey, opts *Options) error {
+	f, err := decryptFile(data, privs, opts)
+	if err != nil {
+		return err
+	}
+	for _, v := range f.Variables() {
+		if err := os.Setenv(v.Name, v.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decryptFile(data []byte, privs []*rsa.PrivateKey, opts *Options) (*File, error) {
+	f, key, _, err := openFile(data, privs, opts.decryptOptions())
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range f.lines {
+		if !l.isVar {
+			continue
+		}
+		bad := fmt.Errorf("%s is not a valid encrypted value", l.name)
+		if !strings.HasPrefix(l.value, valuePrefix) || !strings.HasSuffix(l.value, valueSuffix) {
+			return nil, bad
+		}
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(l.value, valuePrefix), valueSuffix))
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return nil, bad
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(l.name))
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt %s", l.name)
+		}
+		f.lines[i] = l.withValue(string(plain))
+	}
+	return f, nil
+}
+
+// openFile splits the header off an encrypted file, decrypts its data
+// key and verifies its MAC. It returns the file without its header, still
+// encrypted, along with its data key and the secret holding it.
+func openFile(data []byte, privs []*rsa.PrivateKey, opts *multikey.DecryptOptions) (*File, []byte, string, error) {
+	f, err := ParseFile(data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	// split the header off the file
+	header := []string{}
+	kept := []line{}
+	for _, l := range f.lines {
+		if strings.HasPrefix(l.raw, headerPrefix) {
+			header = append(header, strings.TrimPrefix(l.raw, headerPrefix))
+		} else {
+			kept = append(kept, l)
+		}
+	}
+	if len(header) == 0 {
+		return nil, nil, "", ErrNotEncrypted
+	}
+	f.lines = kept
+	if !strings.HasPrefix(header[0], macPrefix) {
+		return nil, nil, "", errors.New(errMsgBadHeader)
+	}
+	mac, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header[0], macPrefix))
+	if err != nil {
+		return nil, nil, "", errors.New(errMsgBadHeader)
+	}
+	secret := strings.Join(header[1:], "\n") + "\n"
+
+	key, err := multikey.DecryptWithOptions(secret, privs, opts)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if len(key) != dataKeySize {
+		return nil, nil, "", errors.New(errMsgBadHeader)
+	}
+	if !hmac.Equal(mac, computeMAC(key, f, secret)) {
+		return nil, nil, "", ErrTampered
+	}
+	return f, key, secret, nil
+}
+
+// computeMAC authenticates the encrypted variables of a file, in order,
+// and its data key's multikey secret
+func computeMAC(key []byte, f *File, secret string) []byte {
+	var buf bytes.Buffer
+	field := func(s string) { fmt.Fprintf(&buf, "%d:%s,", len(s), s) }
+	field(macContext)
+	field(secret)
+	for _, v := range f.Variables() {
+		field(v.Name)
+		field(v.Value)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(buf.Bytes())
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,259 @@
+package dotenv
+
+import (
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// loadTestKeys loads the named private keys from the repository's testdata
+func loadTestKeys(t *testing.T, names ...string) ([]*rsa.PrivateKey, []*rsa.PublicKey) {
+	privs := []*rsa.PrivateKey{}
+	pubs := []*rsa.PublicKey{}
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join("..", "testdata", name+".pem"))
+		assert.Nil(t, err)
+		priv, err := keys.DecodePrivKeyPEM(raw)
+		assert.Nil(t, err)
+		privs = append(privs, priv)
+		pubs = append(pubs, &priv.PublicKey)
+	}
+	return privs, pubs
+}
+
+func encryptTestFile(t *testing.T) ([]byte, []byte) {
+	_, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	plain, err := os.ReadFile(filepath.Join("testdata", "app.env"))
+	assert.Nil(t, err)
+	enc, err := Encrypt(plain, pubs, 2, nil)
+	assert.Nil(t, err)
+	return plain, enc
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	privs, _ := loadTestKeys(t, "alice", "bob", "carol")
+	plain, enc := encryptTestFile(t)
+	assert.True(t, IsEncrypted(enc))
+	assert.False(t, IsEncrypted(plain))
+
+	// names, comments and ordering are readable, values are not
+	encFile, err := ParseFile(enc)
+	assert.Nil(t, err)
+	names := []string{}
+	for _, v := range encFile.Variables() {
+		names = append(names, v.Name)
+		assert.True(t, strings.HasPrefix(v.Value, valuePrefix))
+	}
+	assert.Equal(t, []string{"DB_HOST", "DB_PORT", "DB_PASSWORD", "API_TOKEN", "EMPTY"}, names)
+	assert.Contains(t, string(enc), "# database settings\n")
+	assert.Contains(t, string(enc), "export DB_PORT=MK[")
+	assert.NotContains(t, string(enc), "p@ss")
+
+	dec, err := Decrypt(enc, privs[1:])
+	assert.Nil(t, err)
+	want, err := ParseMap(plain)
+	assert.Nil(t, err)
+	got, err := ParseMap(dec)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+	assert.Contains(t, string(dec), "# database settings\n")
+	assert.Contains(t, string(dec), "export DB_PORT=5432 # default port\n")
+	assert.False(t, IsEncrypted(dec))
+
+	_, err = Decrypt(enc, privs[:1])
+	assert.Equal(t, multikey.ErrInsufficientKeys, err)
+
+	_, err = Decrypt(plain, privs)
+	assert.Equal(t, ErrNotEncrypted, err)
+
+	_, err = Encrypt(enc, nil, 1, nil)
+	assert.EqualError(t, err, errMsgAlreadyEncrypted)
+}
+
+func TestLoadAndSetenv(t *testing.T) {
+	privs, _ := loadTestKeys(t, "alice", "bob")
+	_, enc := encryptTestFile(t)
+
+	m, err := Load(enc, privs)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_HOST":     "localhost",
+		"DB_PORT":     "5432",
+		"DB_PASSWORD": `p@ss "word" $1`,
+		"API_TOKEN":   "tok$en",
+		"EMPTY":       "",
+	}, m)
+
+	for name := range m {
+		t.Setenv(name, "") // restored once the test is done
+	}
+	assert.Nil(t, Setenv(enc, privs))
+	assert.Equal(t, `p@ss "word" $1`, os.Getenv("DB_PASSWORD"))
+	assert.Equal(t, "tok$en", os.Getenv("API_TOKEN"))
+}
+
+func TestDecryptTampered(t *testing.T) {
+	privs, _ := loadTestKeys(t, "alice", "bob")
+	_, enc := encryptTestFile(t)
+	f, err := ParseFile(enc)
+	assert.Nil(t, err)
+	host, _ := f.Get("DB_HOST")
+	token, _ := f.Get("API_TOKEN")
+
+	tests := []struct {
+		name   string
+		tamper func(f *File)
+		err    string
+	}{
+		{
+			name:   "swapped values",
+			tamper: func(f *File) { f.Set("DB_HOST", token); f.Set("API_TOKEN", host) },
+			err:    errMsgTampered,
+		},
+		{
+			name:   "removed variable",
+			tamper: func(f *File) { f.lines = append(f.lines[:1], f.lines[2:]...) },
+			err:    errMsgTampered,
+		},
+		{
+			name:   "renamed variable",
+			tamper: func(f *File) { f.lines[1] = line{isVar: true, name: "DB_HOSTNAME"}.withValue(host) },
+			err:    errMsgTampered,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := ParseFile(enc)
+			assert.Nil(t, err)
+			test.tamper(f)
+			_, err = Decrypt(f.Bytes(), privs)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+
+	// comments are not authenticated, and may be edited freely
+	edited := strings.Replace(string(enc), "# api", "# third party api", 1)
+	_, err = Decrypt([]byte(edited), privs)
+	assert.Nil(t, err)
+}
+
+func TestRewrap(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	plain, enc := encryptTestFile(t)
+
+	// carol is replaced by alice and bob alone, values are kept as they are
+	rewrapped, err := Rewrap(enc, privs[1:], pubs[:2], 2, nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, string(enc), string(rewrapped))
+	encFile, err := ParseFile(enc)
+	assert.Nil(t, err)
+	rewrappedFile, err := ParseFile(rewrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, encFile.Variables(), rewrappedFile.Variables())
+
+	dec, err := Decrypt(rewrapped, privs[:2])
+	assert.Nil(t, err)
+	want, err := ParseMap(plain)
+	assert.Nil(t, err)
+	got, err := ParseMap(dec)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+	_, err = Decrypt(rewrapped, []*rsa.PrivateKey{privs[1], privs[2]})
+	assert.Equal(t, multikey.ErrInsufficientKeys, err)
+
+	_, err = Rewrap(enc, privs[:1], pubs, 2, nil)
+	assert.Equal(t, multikey.ErrInsufficientKeys, err)
+	_, err = Rewrap(plain, privs, pubs, 2, nil)
+	assert.Equal(t, ErrNotEncrypted, err)
+}
+
+func TestEncryptContext(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob")
+	plain, err := os.ReadFile(filepath.Join("testdata", "app.env"))
+	assert.Nil(t, err)
+	prod := &Options{Context: "prod/app.env"}
+	enc, err := Encrypt(plain, pubs, 2, prod)
+	assert.Nil(t, err)
+
+	_, err = DecryptWithOptions(enc, privs, prod)
+	assert.Nil(t, err)
+	_, err = DecryptWithOptions(enc, privs, &Options{Context: "staging/app.env"})
+	assert.True(t, errors.Is(err, multikey.ErrAuthenticationFailed))
+	_, err = Load(enc, privs)
+	assert.Equal(t, multikey.ErrContextMismatch, err)
+	vars, err := LoadWithOptions(enc, privs, prod)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, vars)
+	_, err = LoadWithOptions(enc, privs, &Options{Context: "staging/app.env"})
+	assert.True(t, errors.Is(err, multikey.ErrAuthenticationFailed))
+	for name := range vars {
+		t.Setenv(name, "") // restored once the test is done
+	}
+	assert.Equal(t, multikey.ErrContextMismatch, Setenv(enc, privs))
+	assert.Nil(t, SetenvWithOptions(enc, privs, prod))
+	for name, value := range vars {
+		assert.Equal(t, value, os.Getenv(name))
+	}
+
+	rewrapped, err := Rewrap(enc, privs, pubs[:1], 1, prod)
+	assert.Nil(t, err)
+	_, err = DecryptWithOptions(rewrapped, privs[:1], prod)
+	assert.Nil(t, err)
+}
+
+func TestEncryptSigned(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob")
+	plain, err := os.ReadFile(filepath.Join("testdata", "app.env"))
+	assert.Nil(t, err)
+	alice := &Options{Signer: privs[0]}
+	enc, err := Encrypt(plain, pubs, 1, alice)
+	assert.Nil(t, err)
+
+	trustAlice := &Options{Signers: pubs[:1]}
+	_, err = LoadWithOptions(enc, privs, trustAlice)
+	assert.Nil(t, err)
+	_, err = LoadWithOptions(enc, privs, &Options{Signers: pubs[1:]})
+	assert.True(t, errors.Is(err, multikey.ErrUntrustedSigner))
+
+	// rewrapping without a signer unsigns the file
+	unsigned, err := Rewrap(enc, privs, pubs, 1, nil)
+	assert.Nil(t, err)
+	_, err = LoadWithOptions(unsigned, privs, trustAlice)
+	assert.Equal(t, multikey.ErrUnsigned, err)
+	resigned, err := Rewrap(unsigned, privs, pubs, 1, alice)
+	assert.Nil(t, err)
+	_, err = DecryptWithOptions(resigned, privs, trustAlice)
+	assert.Nil(t, err)
+}
+
+func TestEncryptExpiring(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice")
+	plain, err := os.ReadFile(filepath.Join("testdata", "app.env"))
+	assert.Nil(t, err)
+	notAfter := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	enc, err := Encrypt(plain, pubs, 1, &Options{Metadata: &multikey.Metadata{NotAfter: notAfter}})
+	assert.Nil(t, err)
+
+	_, err = Load(enc, privs)
+	assert.True(t, errors.Is(err, multikey.ErrExpired))
+	_, err = LoadWithOptions(enc, privs, &Options{AllowExpired: true})
+	assert.Nil(t, err)
+
+	// rewrapping keeps the metadata, and works on expired files
+	rewrapped, err := Rewrap(enc, privs, pubs, 1, nil)
+	assert.Nil(t, err)
+	_, err = Decrypt(rewrapped, privs)
+	assert.True(t, errors.Is(err, multikey.ErrExpired))
+	renewed, err := Rewrap(enc, privs, pubs, 1, &Options{Metadata: &multikey.Metadata{Name: "app"}})
+	assert.Nil(t, err)
+	_, err = Decrypt(renewed, privs)
+	assert.Nil(t, err)
+}
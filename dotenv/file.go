@@ -0,0 +1,135 @@
+package dotenv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// File is a parsed dotenv file which keeps its comments, blank lines and
+// the order of its variables, such that it can be written back unchanged
+type File struct {
+	lines []line
+}
+
+// line is either a variable, or text kept verbatim
+type line struct {
+	raw     string
+	isVar   bool
+	export  bool
+	name    string
+	value   string
+	comment string
+}
+
+// ParseFile parses a dotenv file, see Parse for the supported syntax
+func ParseFile(data []byte) (*File, error) {
+	f := &File{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		l, err := parseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		f.lines = append(f.lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Variables returns the variables of the file, in order
+func (f *File) Variables() []Variable {
+	vars := []Variable{}
+	for _, l := range f.lines {
+		if l.isVar {
+			vars = append(vars, Variable{Name: l.name, Value: l.value})
+		}
+	}
+	return vars
+}
+
+// Map returns the variables of the file in a map. Where a name is
+// repeated the last value wins.
+func (f *File) Map() map[string]string {
+	m := map[string]string{}
+	for _, v := range f.Variables() {
+		m[v.Name] = v.Value
+	}
+	return m
+}
+
+// Get returns the value of a variable
+func (f *File) Get(name string) (string, bool) {
+	value, ok := "", false
+	for _, l := range f.lines {
+		if l.isVar && l.name == name {
+			value, ok = l.value, true
+		}
+	}
+	return value, ok
+}
+
+// Set sets the value of every occurrence of a variable, or appends the
+// variable to the file if it is not in it
+func (f *File) Set(name, value string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	found := false
+	for i, l := range f.lines {
+		if l.isVar && l.name == name {
+			f.lines[i] = l.withValue(value)
+			found = true
+		}
+	}
+	if !found {
+		f.lines = append(f.lines, line{isVar: true, name: name}.withValue(value))
+	}
+	return nil
+}
+
+// Bytes returns the file in dotenv syntax. Variables which were not set
+// since parsing keep their original formatting.
+func (f *File) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, l := range f.lines {
+		buf.WriteString(l.raw)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// withValue returns a variable's line with its value replaced, keeping
+// its export keyword and comment
+func (l line) withValue(value string) line {
+	l.value = value
+	l.raw = l.name + "=" + quote(value)
+	if l.export {
+		l.raw = "export " + l.raw
+	}
+	if l.comment != "" {
+		l.raw += " " + l.comment
+	}
+	return l
+}
+
+// quote returns a value as it must be written for Parse to read it back,
+// which is as is for values made of safe characters only
+func quote(value string) string {
+	safe := value != ""
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_-.,/:@+=[]", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return value
+	}
+	return `"` + strings.NewReplacer(
+		`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`,
+	).Replace(value) + `"`
+}
@@ -0,0 +1,43 @@
+package dotenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	data := []byte("# comment\n\nA=1\nexport B = two  # note\n")
+	f, err := ParseFile(data)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), string(f.Bytes()))
+}
+
+func TestFileSet(t *testing.T) {
+	f, err := ParseFile([]byte("# comment\nexport A=1 # note\nB=2\n"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, f.Set("A", "needs \"quoting\"\n"))
+	assert.Nil(t, f.Set("C", "new"))
+	assert.NotNil(t, f.Set("not valid", "x"))
+	assert.Equal(t, "# comment\nexport A=\"needs \\\"quoting\\\"\\n\" # note\nB=2\nC=new\n", string(f.Bytes()))
+
+	v, ok := f.Get("A")
+	assert.True(t, ok)
+	assert.Equal(t, "needs \"quoting\"\n", v)
+	_, ok = f.Get("D")
+	assert.False(t, ok)
+}
+
+func TestQuote(t *testing.T) {
+	values := []string{
+		"", "plain", "with space", `back\slash`, `"quoted"`, "$HOME", "tab\tnew\nline\r", "a #b", "'single'", "MK[abc/+=]",
+	}
+	for _, value := range values {
+		f := &File{}
+		assert.Nil(t, f.Set("V", value))
+		parsed, err := ParseMap(f.Bytes())
+		assert.Nil(t, err)
+		assert.Equal(t, value, parsed["V"], string(f.Bytes()))
+	}
+}
@@ -7,6 +7,18 @@ import (
 	"crypto/subtle"
 )
 
+const (
+	// ReductionPolynomial is the irreducible polynomial x^8 + x^4 + x^3 + x + 1
+	// used to reduce products in GF(2^8). This is the same field as AES,
+	// Hashicorp Vault's shamir package and SLIP-0039, which is what makes
+	// shares interoperable between them.
+	ReductionPolynomial = 0x11b
+
+	// Generator is the primitive element of the field from which the
+	// log and exp tables below are built.
+	Generator = 0xe5
+)
+
 // Add combines two numbers in GF(2^8)
 //
 // GF(2^8) addition and subtraction are performed by the
@@ -75,7 +87,7 @@ func Div(a, b uint8) uint8 {
 }
 
 // -------------------------- TABLES ----------------------------
-// The log and exp tables below use 0xe5 (229) as the generator
+// The log and exp tables below use Generator (0xe5) over ReductionPolynomial (0x11b)
 // - logTable provides the log(X)/log(g) at each index X
 // - expTable provides the anti-log (or exp) at each index X
 var (
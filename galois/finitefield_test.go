@@ -59,3 +59,38 @@ func TestTables(t *testing.T) {
 		}
 	}
 }
+
+// slowMult multiplies two numbers in GF(2^8) by shift-and-add,
+// reducing by ReductionPolynomial, without relying on the lookup tables
+func slowMult(a, b uint8) uint8 {
+	var product uint16
+	aa := uint16(a)
+	for b > 0 {
+		if b&1 == 1 {
+			product ^= aa
+		}
+		aa <<= 1
+		if aa&0x100 != 0 {
+			aa ^= ReductionPolynomial
+		}
+		b >>= 1
+	}
+	return uint8(product)
+}
+
+func TestTablesMatchFieldParameters(t *testing.T) {
+	val := uint8(1)
+	for i := 0; i < 256; i++ {
+		if expTable[i] != val {
+			t.Fatalf("bad: exp[%d] = %#x, expected %#x", i, expTable[i], val)
+		}
+		val = slowMult(val, Generator)
+	}
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			if out, exp := Mult(uint8(a), uint8(b)), slowMult(uint8(a), uint8(b)); out != exp {
+				t.Fatalf("bad: %d * %d = %d, expected %d", a, b, out, exp)
+			}
+		}
+	}
+}
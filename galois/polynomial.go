@@ -2,6 +2,7 @@ package galois
 
 import (
 	"crypto/rand"
+	"io"
 )
 
 // Polynomial represents a polynomial of arbitrary degree
@@ -12,17 +13,28 @@ type Polynomial struct {
 // MakePolynomial constructs a random polynomial of the given
 // degree but with the provided intercept value.
 func MakePolynomial(intercept, degree uint8) Polynomial {
+	// crypto/rand.Reader never returns an error on supported platforms
+	p, _ := MakePolynomialWithRand(intercept, degree, rand.Reader)
+	return p
+}
+
+// MakePolynomialWithRand constructs a polynomial of the given degree
+// with the provided intercept value, reading its co-efficients from
+// the given source of randomness.
+func MakePolynomialWithRand(intercept, degree uint8, rand io.Reader) (Polynomial, error) {
 	p := Polynomial{
-		coefficients: make([]byte, degree+1),
+		coefficients: make([]byte, int(degree)+1),
 	}
 
 	// set the intercept
 	p.coefficients[0] = intercept
 
 	// assign random co-efficients to the polynomial
-	rand.Read(p.coefficients[1:])
+	if _, err := io.ReadFull(rand, p.coefficients[1:]); err != nil {
+		return Polynomial{}, err
+	}
 
-	return p
+	return p, nil
 }
 
 // Evaluate returns the value of the polynomial for the given x
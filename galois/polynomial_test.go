@@ -1,6 +1,9 @@
 package galois
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestMakePolynomial(t *testing.T) {
 	p := MakePolynomial(42, 2)
@@ -34,3 +37,18 @@ func TestInterpolatePolynomial(t *testing.T) {
 		}
 	}
 }
+
+func TestMakePolynomialWithRand(t *testing.T) {
+	p, err := MakePolynomialWithRand(42, 2, bytes.NewReader([]byte{1, 2}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(p.coefficients, []uint8{42, 1, 2}) {
+		t.Fatalf("bad: %v", p.coefficients)
+	}
+
+	// not enough randomness available
+	if _, err := MakePolynomialWithRand(42, 2, bytes.NewReader([]byte{1})); err == nil {
+		t.Fatalf("expect error")
+	}
+}
@@ -0,0 +1,50 @@
+package multikey
+
+// Info describes an encrypted secret, as far as can be learnt without
+// any of its keys
+type Info struct {
+	// Version is the format version of the secret
+	Version int
+
+	// Threshold is the amount of keys required to decrypt the secret.
+	// It is zero for legacy (version 1) secrets, which do not record it.
+	Threshold int
+
+	// KeyIDs are the fingerprints of the keys the secret was encrypted
+	// with, in the order in which their shards appear
+	KeyIDs []string
+
+	// Signer is the fingerprint of the key which signed the secret, if
+	// it is signed. Inspect does not verify the signature.
+	Signer string
+
+	// Bound is true for secrets bound to a context, which must be given
+	// to decrypt them
+	Bound bool
+
+	// Metadata describes the secret, if it was encrypted with any
+	Metadata *Metadata
+}
+
+// Inspect decodes an encrypted secret and describes it without decrypting it
+func Inspect(enc string) (*Info, error) {
+	s, err := decodePEM(enc)
+	if err != nil {
+		return nil, ErrMalformedSecret
+	}
+	info := &Info{
+		Version:   s.version,
+		Threshold: s.threshold,
+		KeyIDs:    []string{},
+		Signer:    s.signer,
+		Bound:     s.bound,
+		Metadata:  s.metadata,
+	}
+	if info.Version == 0 {
+		info.Version = legacyVersion
+	}
+	for _, sh := range s.shards {
+		info.KeyIDs = append(info.KeyIDs, sh.KeyID)
+	}
+	return info, nil
+}
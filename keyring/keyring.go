@@ -0,0 +1,458 @@
+// Package keyring names the public keys secrets are encrypted for. A
+// keyring is a YAML file listing keys along with who owns them and the
+// groups they belong to, signed by a threshold of its admins:
+//
+//	admins:
+//	  require: 2
+//	  keys: [alice-laptop, bob, carol]
+//	keys:
+//	  - name: alice-laptop
+//	    owner: alice@example.com
+//	    groups: [dev, prod]
+//	    tags: [laptop]
+//	    created: 2024-01-15
+//	    expires: 2025-01-15
+//	    public_key: |
+//	      -----BEGIN RSA PUBLIC KEY-----
+//	      ...
+//	      -----END RSA PUBLIC KEY-----
+//	revocations:
+//	  - fingerprint: 61:83:df:df:10:73:0c:2a:96:1d:9e:99:7f:44:f1:3c
+//	    date: 2024-06-01
+//	    reason: laptop stolen
+//	    by: bob
+//	    signature: ...
+//	signatures:
+//	  - by: alice-laptop
+//	    signature: ...
+//	  - by: bob
+//	    signature: ...
+//
+// Recipients are resolved by the name of a key or of a group, which share
+// a namespace, and keys are named by their fingerprints. Revoked and
+// expired keys are kept in the keyring to name them, but are not resolved
+// as recipients. Nor are any keys of a keyring which has admins but is not
+// signed by as many of them as it requires. Any change to a keyring must be
+// signed again by its admins; check it with VerifyUpdate against the last
+// trusted version of the keyring, whose admins may have been replaced.
+//
+// Since whoever can change a keyring file can also change its admins, the
+// keys of a parsed keyring are only resolved once it is anchored to admins
+// trusted independently of the file: those of a trusted previous version
+// of it with AnchorTo, or keys known to the caller with Anchor.
+package keyring
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adrianosela/multikey/keys"
+	"gopkg.in/yaml.v3"
+)
+
+// DateFormat is the layout of the created and expiry dates of keys
+const DateFormat = "2006-01-02"
+
+const (
+	errMsgInvalid     = "invalid keyring"
+	errMsgUnknownName = "no key or group in the keyring is named"
+)
+
+var (
+	// ErrUnknownName is returned when resolving a name which is neither
+	// that of a key nor of a group
+	ErrUnknownName = errors.New(errMsgUnknownName)
+
+	nameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@+-]*$`)
+)
+
+// Key is a named public key
+type Key struct {
+	Name      string
+	Owner     string
+	Tags      []string
+	Groups    []string
+	Created   time.Time // zero if unknown
+	Expires   time.Time // zero if the key does not expire
+	PublicKey *rsa.PublicKey
+
+	fingerprint string
+}
+
+// Fingerprint returns the fingerprint of the key
+func (k *Key) Fingerprint() string {
+	if k.fingerprint == "" {
+		k.fingerprint = keys.GetFingerprint(k.PublicKey)
+	}
+	return k.fingerprint
+}
+
+// Keyring is a set of named public keys
+type Keyring struct {
+	keys          []*Key
+	byName        map[string]*Key
+	byFingerprint map[string]*Key
+	groups        map[string][]*Key
+
+	revocations     map[string]*Revocation // by fingerprint
+	revocationOrder []*Revocation
+
+	admins        []string // names of keys
+	adminsRequire int
+	signatures    []*Signature
+
+	// admins trusted independently of the keyring file
+	anchored      bool
+	anchor        []string // fingerprints of keys
+	anchorRequire int
+}
+
+// yamlFile and yamlKey are the representation of keyrings on disk
+type yamlFile struct {
+	Admins      *yamlAdmins       `yaml:"admins,omitempty"`
+	Keys        []*yamlKey        `yaml:"keys"`
+	Revocations []*yamlRevocation `yaml:"revocations,omitempty"`
+	Signatures  []*yamlSignature  `yaml:"signatures,omitempty"`
+}
+
+type yamlAdmins struct {
+	Require int      `yaml:"require"`
+	Keys    []string `yaml:"keys,flow"`
+}
+
+type yamlKey struct {
+	Name      string   `yaml:"name"`
+	Owner     string   `yaml:"owner,omitempty"`
+	Groups    []string `yaml:"groups,omitempty,flow"`
+	Tags      []string `yaml:"tags,omitempty,flow"`
+	Created   string   `yaml:"created,omitempty"`
+	Expires   string   `yaml:"expires,omitempty"`
+	PublicKey string   `yaml:"public_key"`
+}
+
+type yamlRevocation struct {
+	Fingerprint string `yaml:"fingerprint"`
+	Date        string `yaml:"date"`
+	Reason      string `yaml:"reason"`
+	By          string `yaml:"by"`
+	Signature   string `yaml:"signature"`
+}
+
+type yamlSignature struct {
+	By        string `yaml:"by"`
+	Signature string `yaml:"signature"`
+}
+
+// New returns an empty keyring, which is trusted by whoever builds it
+func New() *Keyring {
+	return &Keyring{
+		byName:        map[string]*Key{},
+		byFingerprint: map[string]*Key{},
+		groups:        map[string][]*Key{},
+		revocations:   map[string]*Revocation{},
+		anchored:      true,
+	}
+}
+
+// Load reads a keyring file
+func Load(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return r, nil
+}
+
+// Parse parses a keyring. Its keys are not resolved until it is anchored
+// to admins trusted independently of it with Anchor or AnchorTo.
+func Parse(data []byte) (*Keyring, error) {
+	var f yamlFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", errMsgInvalid, err)
+	}
+	r := New()
+	for i, y := range f.Keys {
+		if y == nil {
+			return nil, fmt.Errorf("%s: key %d is empty", errMsgInvalid, i+1)
+		}
+		k := &Key{Name: y.Name, Owner: y.Owner, Groups: y.Groups, Tags: y.Tags}
+		var err error
+		if k.Created, err = parseDate(y.Created); err != nil {
+			return nil, fmt.Errorf("%s: key %s: created: %s", errMsgInvalid, y.Name, err)
+		}
+		if k.Expires, err = parseDate(y.Expires); err != nil {
+			return nil, fmt.Errorf("%s: key %s: expires: %s", errMsgInvalid, y.Name, err)
+		}
+		if k.PublicKey, err = keys.DecodePubKeyPEM([]byte(y.PublicKey)); err != nil {
+			return nil, fmt.Errorf("%s: key %s: %s", errMsgInvalid, y.Name, err)
+		}
+		if err := r.Add(k); err != nil {
+			return nil, fmt.Errorf("%s: %s", errMsgInvalid, err)
+		}
+	}
+	// admins sign revocations of other keys
+	if f.Admins != nil {
+		if err := r.SetAdmins(f.Admins.Keys, f.Admins.Require); err != nil {
+			return nil, fmt.Errorf("%s: %s", errMsgInvalid, err)
+		}
+	}
+	for i, y := range f.Revocations {
+		if y == nil {
+			return nil, fmt.Errorf("%s: revocation %d is empty", errMsgInvalid, i+1)
+		}
+		rv := &Revocation{Fingerprint: y.Fingerprint, Reason: y.Reason, By: y.By}
+		var err error
+		if rv.Date, err = parseDate(y.Date); err != nil {
+			return nil, fmt.Errorf("%s: revocation of %s: date: %s", errMsgInvalid, y.Fingerprint, err)
+		}
+		if rv.Signature, err = base64.StdEncoding.DecodeString(y.Signature); err != nil {
+			return nil, fmt.Errorf("%s: revocation of %s: signature: %s", errMsgInvalid, y.Fingerprint, err)
+		}
+		if err := r.Revoke(rv); err != nil {
+			return nil, fmt.Errorf("%s: %s", errMsgInvalid, err)
+		}
+	}
+	for i, y := range f.Signatures {
+		if y == nil {
+			return nil, fmt.Errorf("%s: signature %d is empty", errMsgInvalid, i+1)
+		}
+		sig, err := base64.StdEncoding.DecodeString(y.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("%s: signature by %s: %s", errMsgInvalid, y.By, err)
+		}
+		r.signatures = append(r.signatures, &Signature{By: y.By, Signature: sig})
+	}
+	r.anchored = false
+	return r, nil
+}
+
+// Marshal encodes the keyring in its file format
+func (r *Keyring) Marshal() ([]byte, error) {
+	return r.marshal(true)
+}
+
+func (r *Keyring) marshal(withSignatures bool) ([]byte, error) {
+	f := yamlFile{Keys: []*yamlKey{}}
+	if r.adminsRequire > 0 {
+		f.Admins = &yamlAdmins{Require: r.adminsRequire, Keys: r.admins}
+	}
+	for _, k := range r.keys {
+		f.Keys = append(f.Keys, &yamlKey{
+			Name:      k.Name,
+			Owner:     k.Owner,
+			Groups:    k.Groups,
+			Tags:      k.Tags,
+			Created:   formatDate(k.Created),
+			Expires:   formatDate(k.Expires),
+			PublicKey: string(keys.EncodePubKeyPEM(k.PublicKey)),
+		})
+	}
+	for _, rv := range r.revocationOrder {
+		f.Revocations = append(f.Revocations, &yamlRevocation{
+			Fingerprint: rv.Fingerprint,
+			Date:        formatDate(rv.Date),
+			Reason:      rv.Reason,
+			By:          rv.By,
+			Signature:   base64.StdEncoding.EncodeToString(rv.Signature),
+		})
+	}
+	for _, sig := range r.signatures {
+		if !withSignatures {
+			break
+		}
+		f.Signatures = append(f.Signatures, &yamlSignature{By: sig.By, Signature: base64.StdEncoding.EncodeToString(sig.Signature)})
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Add adds a key to the keyring. Names of keys and groups must be unique
+// amongst both, and a public key may only be in the keyring once. Adding a
+// key removes the signatures of the keyring.
+func (r *Keyring) Add(k *Key) error {
+	if !nameRegex.MatchString(k.Name) {
+		return fmt.Errorf("invalid key name %q", k.Name)
+	}
+	if k.PublicKey == nil {
+		return fmt.Errorf("key %s has no public key", k.Name)
+	}
+	if _, ok := r.byName[k.Name]; ok {
+		return fmt.Errorf("key %s is in the keyring more than once", k.Name)
+	}
+	if _, ok := r.groups[k.Name]; ok {
+		return fmt.Errorf("key %s is named like a group", k.Name)
+	}
+	if other, ok := r.byFingerprint[k.Fingerprint()]; ok {
+		return fmt.Errorf("key %s is the same public key as %s", k.Name, other.Name)
+	}
+	for _, g := range k.Groups {
+		if !nameRegex.MatchString(g) {
+			return fmt.Errorf("key %s: invalid group name %q", k.Name, g)
+		}
+		if _, ok := r.byName[g]; ok || g == k.Name {
+			return fmt.Errorf("key %s: group %s is named like a key", k.Name, g)
+		}
+	}
+	r.signatures = nil
+	r.keys = append(r.keys, k)
+	r.byName[k.Name] = k
+	r.byFingerprint[k.Fingerprint()] = k
+	for _, g := range k.Groups {
+		if !containsKey(r.groups[g], k) {
+			r.groups[g] = append(r.groups[g], k)
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of the keyring in the order they were added
+func (r *Keyring) Keys() []*Key {
+	return append([]*Key{}, r.keys...)
+}
+
+// Key returns the key with the given name
+func (r *Keyring) Key(name string) (*Key, bool) {
+	k, ok := r.byName[name]
+	return k, ok
+}
+
+// Lookup returns the key with the given fingerprint
+func (r *Keyring) Lookup(fingerprint string) (*Key, bool) {
+	k, ok := r.byFingerprint[fingerprint]
+	return k, ok
+}
+
+// Group returns the keys in a group, or none if there is no such group
+func (r *Keyring) Group(name string) []*Key {
+	return append([]*Key{}, r.groups[name]...)
+}
+
+// Groups returns the names of the groups of the keyring, sorted
+func (r *Keyring) Groups() []string {
+	names := []string{}
+	for g := range r.groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has reports whether a name is that of a key or group in the keyring
+func (r *Keyring) Has(name string) bool {
+	_, isKey := r.byName[name]
+	_, isGroup := r.groups[name]
+	return isKey || isGroup
+}
+
+// Resolve returns the keys with the given key or group names, in order
+// and without duplicates. It fails as CheckSigned does if the keyring is
+// not anchored or not signed by enough admins.
+func (r *Keyring) Resolve(names ...string) ([]*Key, error) {
+	if err := r.CheckSigned(); err != nil {
+		return nil, err
+	}
+	seen := map[*Key]bool{}
+	resolved := []*Key{}
+	for _, name := range names {
+		ks, ok := r.groups[name]
+		if k, isKey := r.byName[name]; isKey {
+			ks, ok = []*Key{k}, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownName, name)
+		}
+		for _, k := range ks {
+			if !seen[k] {
+				seen[k] = true
+				resolved = append(resolved, k)
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// PublicKeys returns the public keys with the given key or group names,
+// to encrypt secrets for. It fails if any of them is revoked or expired,
+// see CheckRecipients; use Resolve to encrypt for them regardless.
+func (r *Keyring) PublicKeys(names ...string) ([]*rsa.PublicKey, error) {
+	ks, err := r.Resolve(names...)
+	if err != nil {
+		return nil, err
+	}
+	pubs := []*rsa.PublicKey{}
+	for _, k := range ks {
+		pubs = append(pubs, k.PublicKey)
+	}
+	if err := r.CheckRecipients(pubs, time.Now()); err != nil {
+		return nil, err
+	}
+	return pubs, nil
+}
+
+// Names returns the names of the keys of the keyring by fingerprint
+func (r *Keyring) Names() map[string]string {
+	names := map[string]string{}
+	for fp, k := range r.byFingerprint {
+		names[fp] = k.Name
+	}
+	return names
+}
+
+// Name returns the name of the key with the given fingerprint, or the
+// fingerprint itself if the key is not in the keyring
+func (r *Keyring) Name(fingerprint string) string {
+	if k, ok := r.byFingerprint[fingerprint]; ok {
+		return k.Name
+	}
+	return fingerprint
+}
+
+func containsKey(ks []*Key, k *Key) bool {
+	for _, other := range ks {
+		if other == k {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(DateFormat, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("dates are formatted as YYYY-MM-DD")
+	}
+	return t, nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(DateFormat)
+}
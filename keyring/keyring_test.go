@@ -0,0 +1,259 @@
+package keyring
+
+import (
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// loadTestKeys loads the named public keys from the repository's testdata
+func loadTestKeys(t *testing.T, names ...string) []*rsa.PublicKey {
+	pubs := []*rsa.PublicKey{}
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join("..", "testdata", name+".pem"))
+		assert.Nil(t, err)
+		priv, err := keys.DecodePrivKeyPEM(raw)
+		assert.Nil(t, err)
+		pubs = append(pubs, &priv.PublicKey)
+	}
+	return pubs
+}
+
+// testKeyring returns a keyring of alice and bob in the dev group, and of
+// alice and carol in the ops group
+func testKeyring(t *testing.T) *Keyring {
+	pubs := loadTestKeys(t, "alice", "bob", "carol")
+	r := New()
+	assert.Nil(t, r.Add(&Key{
+		Name:      "alice-laptop",
+		Owner:     "alice@example.com",
+		Groups:    []string{"dev", "ops"},
+		Tags:      []string{"laptop"},
+		Created:   time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		Expires:   time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC),
+		PublicKey: pubs[0],
+	}))
+	assert.Nil(t, r.Add(&Key{Name: "bob", Groups: []string{"dev"}, PublicKey: pubs[1]}))
+	assert.Nil(t, r.Add(&Key{Name: "carol", Groups: []string{"ops"}, PublicKey: pubs[2]}))
+	return r
+}
+
+func TestMarshalParse(t *testing.T) {
+	r := testKeyring(t)
+	data, err := r.Marshal()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "keys:\n  - name: alice-laptop\n    owner: alice@example.com\n    groups: [dev, ops]\n    tags: [laptop]\n    created: \"2024-01-15\"\n    expires: \"2030-01-15\"\n    public_key: |\n      -----BEGIN RSA PUBLIC KEY-----\n"), string(data))
+
+	parsed, err := Parse(data)
+	assert.Nil(t, err)
+	assert.Equal(t, len(r.Keys()), len(parsed.Keys()))
+	for i, k := range parsed.Keys() {
+		exp := r.Keys()[i]
+		assert.Equal(t, exp.Name, k.Name)
+		assert.Equal(t, exp.Owner, k.Owner)
+		assert.Equal(t, exp.Groups, k.Groups)
+		assert.Equal(t, exp.Tags, k.Tags)
+		assert.True(t, exp.Created.Equal(k.Created))
+		assert.True(t, exp.Expires.Equal(k.Expires))
+		assert.Equal(t, exp.Fingerprint(), k.Fingerprint())
+	}
+	again, err := parsed.Marshal()
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), string(again))
+}
+
+func TestParseInvalid(t *testing.T) {
+	pub := strings.ReplaceAll(string(keys.EncodePubKeyPEM(loadTestKeys(t, "alice")[0])), "\n", "\n      ")
+	bob := strings.ReplaceAll(string(keys.EncodePubKeyPEM(loadTestKeys(t, "bob")[0])), "\n", "\n      ")
+	tests := []struct {
+		name   string
+		data   string
+		expErr string
+	}{
+		{name: "unknown field", data: "keys:\n  - name: a\n    colour: red\n", expErr: "field colour not found"},
+		{name: "bad name", data: "keys:\n  - name: ../a\n    public_key: |\n      " + pub, expErr: `invalid key name "../a"`},
+		{name: "bad date", data: "keys:\n  - name: a\n    created: last week\n    public_key: |\n      " + pub, expErr: "key a: created: dates are formatted as YYYY-MM-DD"},
+		{name: "bad key", data: "keys:\n  - name: a\n    public_key: nope\n", expErr: "key a: failed to decode PEM block"},
+		{name: "duplicate name", data: "keys:\n  - name: a\n    public_key: |\n      " + pub + "\n  - name: a\n    public_key: |\n      " + bob, expErr: "key a is in the keyring more than once"},
+		{name: "duplicate key", data: "keys:\n  - name: a\n    public_key: |\n      " + pub + "\n  - name: b\n    public_key: |\n      " + pub, expErr: "key b is the same public key as a"},
+		{name: "group named like a key", data: "keys:\n  - name: a\n    public_key: |\n      " + pub + "\n  - name: b\n    groups: [a]\n    public_key: |\n      " + bob, expErr: "key b: group a is named like a key"},
+		{name: "key named like a group", data: "keys:\n  - name: a\n    groups: [b]\n    public_key: |\n      " + pub + "\n  - name: b\n    public_key: |\n      " + bob, expErr: "key b is named like a group"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.data))
+			assert.NotNil(t, err)
+			if err != nil {
+				assert.True(t, strings.HasPrefix(err.Error(), errMsgInvalid), err.Error())
+				assert.Contains(t, err.Error(), test.expErr)
+			}
+		})
+	}
+
+	r, err := Parse(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, r.Keys())
+}
+
+func TestResolve(t *testing.T) {
+	r := testKeyring(t)
+	tests := []struct {
+		name     string
+		names    []string
+		expNames []string
+		expErr   bool
+	}{
+		{name: "key", names: []string{"bob"}, expNames: []string{"bob"}},
+		{name: "group", names: []string{"ops"}, expNames: []string{"alice-laptop", "carol"}},
+		{name: "overlapping", names: []string{"carol", "dev", "ops"}, expNames: []string{"carol", "alice-laptop", "bob"}},
+		{name: "unknown", names: []string{"dev", "mallory"}, expErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ks, err := r.Resolve(test.names...)
+			if test.expErr {
+				assert.True(t, errors.Is(err, ErrUnknownName))
+				return
+			}
+			assert.Nil(t, err)
+			names := []string{}
+			for _, k := range ks {
+				names = append(names, k.Name)
+			}
+			assert.Equal(t, test.expNames, names)
+
+			pubs, err := r.PublicKeys(test.names...)
+			assert.Nil(t, err)
+			assert.Len(t, pubs, len(ks))
+		})
+	}
+}
+
+func TestNames(t *testing.T) {
+	r := testKeyring(t)
+	alice := keys.GetFingerprint(loadTestKeys(t, "alice")[0])
+	assert.Equal(t, "alice-laptop", r.Name(alice))
+	assert.Equal(t, "00:11", r.Name("00:11"))
+	assert.Equal(t, "alice-laptop", r.Names()[alice])
+	assert.Len(t, r.Names(), 3)
+	k, ok := r.Lookup(alice)
+	assert.True(t, ok)
+	assert.Equal(t, "alice@example.com", k.Owner)
+	assert.Equal(t, []string{"dev", "ops"}, r.Groups())
+	assert.True(t, r.Has("dev"))
+	assert.True(t, r.Has("bob"))
+	assert.False(t, r.Has("mallory"))
+}
+
+// loadTestPrivateKey loads a private key from the repository's testdata
+func loadTestPrivateKey(t *testing.T, name string) *rsa.PrivateKey {
+	raw, err := os.ReadFile(filepath.Join("..", "testdata", name+".pem"))
+	assert.Nil(t, err)
+	priv, err := keys.DecodePrivKeyPEM(raw)
+	assert.Nil(t, err)
+	return priv
+}
+
+func TestRevoke(t *testing.T) {
+	r := testKeyring(t)
+	alice, _ := r.Key("alice-laptop")
+	date := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rv, err := NewRevocation(alice.Fingerprint(), "laptop stolen", date, "alice-laptop", loadTestPrivateKey(t, "alice"))
+	assert.Nil(t, err)
+
+	// revocations must be signed by the key they name as their signer
+	forged := *rv
+	forged.By = "carol"
+	assert.NotEqual(t, rv.message(), forged.message())
+	assert.NotNil(t, r.Revoke(&forged))
+	unknown, err := NewRevocation("00:11", "", date, "bob", loadTestPrivateKey(t, "bob"))
+	assert.Nil(t, err)
+	assert.NotNil(t, r.Revoke(unknown))
+
+	// which must be the revoked key itself or an admin
+	byBob, err := NewRevocation(alice.Fingerprint(), "laptop stolen", date, "bob", loadTestPrivateKey(t, "bob"))
+	assert.Nil(t, err)
+	assert.EqualError(t, r.Revoke(byBob), "revocation of alice-laptop is signed by bob, which is neither an admin of the keyring nor the revoked key")
+	administered := testKeyring(t)
+	assert.Nil(t, administered.SetAdmins([]string{"bob", "carol"}, 1))
+	assert.Nil(t, administered.Revoke(byBob))
+	marshalled, err := administered.Marshal()
+	assert.Nil(t, err)
+	_, err = Parse(marshalled)
+	assert.Nil(t, err)
+
+	// revoked admins can not revoke other keys, e.g. to stop their
+	// signatures counting
+	bob, _ := administered.Key("bob")
+	byRevoked, err := NewRevocation(bob.Fingerprint(), "", date, "alice-laptop", loadTestPrivateKey(t, "alice"))
+	assert.Nil(t, err)
+	assert.NotNil(t, administered.Revoke(byRevoked))
+	carol, _ := administered.Key("carol")
+	assert.Nil(t, administered.SetAdmins([]string{"alice-laptop", "bob", "carol"}, 1))
+	byRevoked, err = NewRevocation(carol.Fingerprint(), "", date, "alice-laptop", loadTestPrivateKey(t, "alice"))
+	assert.Nil(t, err)
+	assert.EqualError(t, administered.Revoke(byRevoked), "revocation of carol is signed by revoked key alice-laptop")
+
+	previous, err := r.Marshal()
+	assert.Nil(t, err)
+	assert.Nil(t, r.Revoke(rv))
+	assert.NotNil(t, r.Revoke(rv))
+	got, ok := r.Revocation(alice.Fingerprint())
+	assert.True(t, ok)
+	assert.Equal(t, "laptop stolen", got.Reason)
+
+	// revocations survive a round trip, and are verified when parsed
+	data, err := r.Marshal()
+	assert.Nil(t, err)
+	parsed, err := Parse(data)
+	assert.Nil(t, err)
+	assert.Len(t, parsed.Revocations(), 1)
+	assert.True(t, parsed.Revocations()[0].Date.Equal(date))
+	tampered := strings.Replace(string(data), "reason: laptop stolen", "reason: laptop found", 1)
+	_, err = Parse([]byte(tampered))
+	assert.NotNil(t, err)
+	if err != nil {
+		assert.Contains(t, err.Error(), "revocation of alice-laptop has an invalid signature by alice-laptop")
+	}
+
+	// dropping a revocation is noticed against the previous keyring
+	dropped, err := Parse(previous)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Revocation{parsed.Revocations()[0]}, dropped.Dropped(parsed))
+	assert.Empty(t, parsed.Dropped(dropped))
+
+	// revoked keys are named but not resolved as recipients
+	assert.Equal(t, "alice-laptop", parsed.Name(alice.Fingerprint()))
+	_, err = parsed.PublicKeys("bob")
+	assert.True(t, errors.Is(err, ErrNotAnchored))
+	parsed.Anchor(nil, 0)
+	_, err = parsed.PublicKeys("ops")
+	assert.True(t, errors.Is(err, ErrRevoked))
+	_, err = parsed.PublicKeys("bob")
+	assert.Nil(t, err)
+	ks, err := parsed.Resolve("ops")
+	assert.Nil(t, err)
+	assert.Len(t, ks, 2)
+}
+
+func TestCheckRecipients(t *testing.T) {
+	r := testKeyring(t)
+	pubs := loadTestKeys(t, "alice", "bob", "carol")
+	before := time.Date(2030, 1, 14, 23, 59, 0, 0, time.UTC)
+	after := time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, r.CheckRecipients(pubs, before))
+	err := r.CheckRecipients(pubs, after)
+	assert.True(t, errors.Is(err, ErrExpired))
+	assert.Equal(t, "alice-laptop: key has expired on 2030-01-15", err.Error())
+	assert.Nil(t, r.CheckRecipients(pubs[1:], after))
+
+	// keys outside the keyring are not checked
+	assert.Nil(t, New().CheckRecipients(pubs, after))
+}
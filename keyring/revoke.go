@@ -0,0 +1,139 @@
+package keyring
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/multikey/keys"
+)
+
+const (
+	errMsgRevoked = "key is revoked"
+	errMsgExpired = "key has expired"
+
+	// revocationContext prefixes the messages revocations sign, so that
+	// their signatures can not be mistaken for signatures of anything else
+	revocationContext = "multikey revocation\n"
+)
+
+var (
+	// ErrRevoked is returned when resolving a revoked key as a recipient
+	ErrRevoked = errors.New(errMsgRevoked)
+
+	// ErrExpired is returned when resolving an expired key as a recipient
+	ErrExpired = errors.New(errMsgExpired)
+)
+
+// Revocation records that a key must no longer be used. It is signed by
+// an admin of the keyring or the revoked key itself, so that revocations
+// can not be forged. Revocations can not be removed from a keyring
+// either, see Dropped.
+type Revocation struct {
+	Fingerprint string
+	Date        time.Time
+	Reason      string
+	By          string // name of the signing key
+	Signature   []byte
+}
+
+// NewRevocation returns a revocation of the key with the given
+// fingerprint, signed by the named key
+func NewRevocation(fingerprint, reason string, date time.Time, by string, priv *rsa.PrivateKey) (*Revocation, error) {
+	rv := &Revocation{Fingerprint: fingerprint, Date: date, Reason: reason, By: by}
+	sig, err := keys.SignMessage(rv.message(), priv)
+	if err != nil {
+		return nil, err
+	}
+	rv.Signature = sig
+	return rv, nil
+}
+
+// message returns what the signature of a revocation covers
+func (rv *Revocation) message() []byte {
+	return []byte(fmt.Sprintf("%s%s\n%s\n%s\n%s\n", revocationContext, rv.Fingerprint, formatDate(rv.Date), rv.By, rv.Reason))
+}
+
+// Revoke adds a revocation to the keyring. The revoked key must be in the
+// keyring, and the revocation signed by an admin of the keyring who is not
+// revoked, or by the revoked key itself. Revoking a key removes the
+// signatures of the keyring.
+func (r *Keyring) Revoke(rv *Revocation) error {
+	revoked, ok := r.byFingerprint[rv.Fingerprint]
+	if !ok {
+		return fmt.Errorf("revoked key %s is not in the keyring", rv.Fingerprint)
+	}
+	if _, ok := r.revocations[rv.Fingerprint]; ok {
+		return fmt.Errorf("key %s is revoked more than once", revoked.Name)
+	}
+	signer, ok := r.byName[rv.By]
+	if !ok {
+		return fmt.Errorf("revocation of %s is signed by unknown key %q", revoked.Name, rv.By)
+	}
+	if signer.Fingerprint() != rv.Fingerprint {
+		if !r.isAdmin(signer.Name) {
+			return fmt.Errorf("revocation of %s is signed by %s, which is neither an admin of the keyring nor the revoked key", revoked.Name, signer.Name)
+		}
+		if _, ok := r.revocations[signer.Fingerprint()]; ok {
+			return fmt.Errorf("revocation of %s is signed by revoked key %s", revoked.Name, signer.Name)
+		}
+	}
+	if err := keys.VerifySignature(rv.message(), rv.Signature, signer.PublicKey); err != nil {
+		return fmt.Errorf("revocation of %s has an invalid signature by %s", revoked.Name, signer.Name)
+	}
+	r.signatures = nil
+	r.revocations[rv.Fingerprint] = rv
+	r.revocationOrder = append(r.revocationOrder, rv)
+	return nil
+}
+
+// Revocation returns the revocation of the key with the given fingerprint,
+// if it is revoked
+func (r *Keyring) Revocation(fingerprint string) (*Revocation, bool) {
+	rv, ok := r.revocations[fingerprint]
+	return rv, ok
+}
+
+// Revocations returns the revocations of the keyring in the order they
+// were added
+func (r *Keyring) Revocations() []*Revocation {
+	return append([]*Revocation{}, r.revocationOrder...)
+}
+
+// Dropped returns the revocations of a previous version of the keyring
+// which are missing from it. A keyring should never drop revocations, so
+// comparing it with its last known version reveals tampering.
+func (r *Keyring) Dropped(previous *Keyring) []*Revocation {
+	dropped := []*Revocation{}
+	for _, rv := range previous.revocationOrder {
+		if _, ok := r.revocations[rv.Fingerprint]; !ok {
+			dropped = append(dropped, rv)
+		}
+	}
+	return dropped
+}
+
+// Expired reports whether the key has expired at the given time
+func (k *Key) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// CheckRecipients returns an error wrapping ErrRevoked or ErrExpired if
+// any of the given keys is revoked, or expired at the given time. Keys
+// which are not in the keyring are not checked.
+func (r *Keyring) CheckRecipients(pubs []*rsa.PublicKey, now time.Time) error {
+	for _, pub := range pubs {
+		k, ok := r.byFingerprint[keys.GetFingerprint(pub)]
+		if !ok {
+			continue
+		}
+		if rv, ok := r.revocations[k.Fingerprint()]; ok {
+			return fmt.Errorf("%s: %w on %s: %s", k.Name, ErrRevoked, formatDate(rv.Date), rv.Reason)
+		}
+		if k.Expired(now) {
+			return fmt.Errorf("%s: %w on %s", k.Name, ErrExpired, formatDate(k.Expires))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package keyring
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adrianosela/multikey/keys"
+)
+
+const (
+	errMsgNotSigned = "keyring is not signed by enough admins"
+	errMsgNotAdmin  = "key is not an admin of the keyring"
+
+	errMsgNotAnchored = "keyring is not anchored to trusted admins"
+
+	// keyringContext prefixes the messages keyring signatures sign
+	keyringContext = "multikey keyring\n"
+)
+
+var (
+	// ErrNotSigned is returned when resolving recipients through a keyring
+	// which is not signed by as many of its admins as it requires, and
+	// when verifying a keyring against admins who have not signed it
+	ErrNotSigned = errors.New(errMsgNotSigned)
+
+	// ErrNotAdmin is returned when signing a keyring with a key which is
+	// not one of its admins
+	ErrNotAdmin = errors.New(errMsgNotAdmin)
+
+	// ErrNotAnchored is returned when resolving recipients through a parsed
+	// keyring which was not anchored to admins trusted independently of it
+	ErrNotAnchored = errors.New(errMsgNotAnchored)
+)
+
+// Signature is the signature of a keyring by one of its admins
+type Signature struct {
+	By        string // name of the signing key
+	Signature []byte
+}
+
+// SetAdmins sets the keys, by name, which must sign the keyring, and how
+// many of them must. Setting no admins leaves the keyring unsigned.
+// Changing the admins removes the signatures of the keyring.
+func (r *Keyring) SetAdmins(names []string, require int) error {
+	if len(names) == 0 {
+		r.admins, r.adminsRequire = nil, 0
+		r.signatures = nil
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if _, ok := r.byName[name]; !ok {
+			return fmt.Errorf("admin %s is not a key in the keyring", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("admin %s is given more than once", name)
+		}
+		seen[name] = true
+	}
+	if require < 1 || require > len(names) {
+		return fmt.Errorf("admins require must be between 1 and the number of admins (%d)", len(names))
+	}
+	r.admins, r.adminsRequire = append([]string{}, names...), require
+	r.signatures = nil
+	return nil
+}
+
+// Admins returns the names of the admins of the keyring and how many of
+// them must sign it
+func (r *Keyring) Admins() ([]string, int) {
+	return append([]string{}, r.admins...), r.adminsRequire
+}
+
+// Sign adds the signature of an admin to the keyring, replacing any
+// previous signature by the same key
+func (r *Keyring) Sign(priv *rsa.PrivateKey) error {
+	k, ok := r.byFingerprint[keys.GetFingerprint(&priv.PublicKey)]
+	if !ok || !r.isAdmin(k.Name) {
+		return ErrNotAdmin
+	}
+	if _, revoked := r.revocations[k.Fingerprint()]; revoked {
+		return fmt.Errorf("%s: %w", k.Name, ErrRevoked)
+	}
+	msg, err := r.signedMessage()
+	if err != nil {
+		return err
+	}
+	sig, err := keys.SignMessage(msg, priv)
+	if err != nil {
+		return err
+	}
+	signatures := []*Signature{}
+	for _, s := range r.signatures {
+		if s.By != k.Name {
+			signatures = append(signatures, s)
+		}
+	}
+	r.signatures = append(signatures, &Signature{By: k.Name, Signature: sig})
+	return nil
+}
+
+// Signatures returns the signatures of the keyring
+func (r *Keyring) Signatures() []*Signature {
+	return append([]*Signature{}, r.signatures...)
+}
+
+// Signers returns the names of the admins whose signatures of the keyring
+// are valid, in the order they signed it
+func (r *Keyring) Signers() []string {
+	signers := []string{}
+	for _, k := range r.validSigners(r.adminKeys()) {
+		signers = append(signers, k.Name)
+	}
+	return signers
+}
+
+// Verify checks that the keyring is signed by at least require of the
+// given keys. Revoked keys do not count.
+func (r *Keyring) Verify(admins []*rsa.PublicKey, require int) error {
+	fingerprints := []string{}
+	for _, pub := range admins {
+		fingerprints = append(fingerprints, keys.GetFingerprint(pub))
+	}
+	return r.verifyFingerprints(fingerprints, require)
+}
+
+// VerifyUpdate checks that the keyring is signed by as many of the admins
+// of a previous version of it as that version requires, so that only its
+// admins can change the keyring, including who they are
+func (r *Keyring) VerifyUpdate(previous *Keyring) error {
+	if previous.adminsRequire == 0 {
+		return nil
+	}
+	if err := r.verifyFingerprints(previous.AdminFingerprints(), previous.adminsRequire); err != nil {
+		return fmt.Errorf("%w by the admins of the previous keyring", err)
+	}
+	return nil
+}
+
+// AdminFingerprints returns the fingerprints of the admins of the keyring
+// which are not revoked
+func (r *Keyring) AdminFingerprints() []string {
+	fingerprints := []string{}
+	for _, k := range r.adminKeys() {
+		if _, revoked := r.revocations[k.Fingerprint()]; !revoked {
+			fingerprints = append(fingerprints, k.Fingerprint())
+		}
+	}
+	return fingerprints
+}
+
+// Anchor trusts the keyring only if it is signed by at least require of
+// the admin keys with the given fingerprints, which the caller trusts
+// independently of the keyring file. Anchoring to no admins trusts the
+// keyring as it is.
+func (r *Keyring) Anchor(fingerprints []string, require int) {
+	r.anchored = true
+	r.anchor, r.anchorRequire = append([]string{}, fingerprints...), require
+}
+
+// AnchorTo anchors the keyring to the admins of a trusted previous version
+// of it, as VerifyUpdate checks it against them
+func (r *Keyring) AnchorTo(previous *Keyring) {
+	r.Anchor(previous.AdminFingerprints(), previous.adminsRequire)
+}
+
+// CheckSigned returns ErrNotAnchored if the keyring was parsed but not
+// anchored, and ErrNotSigned if it is not signed by as many of the admins
+// it is anchored to, or of its own admins, as they require
+func (r *Keyring) CheckSigned() error {
+	if !r.anchored {
+		return ErrNotAnchored
+	}
+	if r.anchorRequire > 0 {
+		if err := r.verifyFingerprints(r.anchor, r.anchorRequire); err != nil {
+			return fmt.Errorf("%w by its trusted admins", err)
+		}
+	}
+	if r.adminsRequire == 0 {
+		return nil
+	}
+	fingerprints := []string{}
+	for _, k := range r.adminKeys() {
+		fingerprints = append(fingerprints, k.Fingerprint())
+	}
+	return r.verifyFingerprints(fingerprints, r.adminsRequire)
+}
+
+// verifyFingerprints checks that the keyring is signed by at least require
+// of the keys with the given fingerprints
+func (r *Keyring) verifyFingerprints(fingerprints []string, require int) error {
+	trusted := map[string]bool{}
+	for _, fp := range fingerprints {
+		trusted[fp] = true
+	}
+	candidates := []*Key{}
+	for _, k := range r.keys {
+		if trusted[k.Fingerprint()] {
+			candidates = append(candidates, k)
+		}
+	}
+	if signed := len(r.validSigners(candidates)); signed < require {
+		return fmt.Errorf("%w: %d of %d required signatures", ErrNotSigned, signed, require)
+	}
+	return nil
+}
+
+// validSigners returns those of the given keys which have validly signed
+// the keyring and are not revoked
+func (r *Keyring) validSigners(candidates []*Key) []*Key {
+	msg, err := r.signedMessage()
+	if err != nil {
+		return nil
+	}
+	byName := map[string]*Key{}
+	for _, k := range candidates {
+		byName[k.Name] = k
+	}
+	signers := []*Key{}
+	seen := map[string]bool{}
+	for _, s := range r.signatures {
+		k, ok := byName[s.By]
+		if !ok || seen[s.By] {
+			continue
+		}
+		if _, revoked := r.revocations[k.Fingerprint()]; revoked {
+			continue
+		}
+		if keys.VerifySignature(msg, s.Signature, k.PublicKey) == nil {
+			seen[s.By] = true
+			signers = append(signers, k)
+		}
+	}
+	return signers
+}
+
+func (r *Keyring) adminKeys() []*Key {
+	ks := []*Key{}
+	for _, name := range r.admins {
+		if k, ok := r.byName[name]; ok {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+func (r *Keyring) isAdmin(name string) bool {
+	for _, admin := range r.admins {
+		if admin == name {
+			return true
+		}
+	}
+	return false
+}
+
+// signedMessage returns what the signatures of the keyring cover: its
+// encoding without its signatures
+func (r *Keyring) signedMessage() ([]byte, error) {
+	data, err := r.marshal(false)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(keyringContext + strings.TrimSpace(string(data)) + "\n"), nil
+}
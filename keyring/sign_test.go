@@ -0,0 +1,124 @@
+package keyring
+
+import (
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	r := testKeyring(t)
+	alice, bob, carol := loadTestPrivateKey(t, "alice"), loadTestPrivateKey(t, "bob"), loadTestPrivateKey(t, "carol")
+
+	// keyrings without admins need no signatures
+	_, err := r.Resolve("dev")
+	assert.Nil(t, err)
+	assert.NotNil(t, r.SetAdmins([]string{"alice-laptop", "mallory"}, 1))
+	assert.NotNil(t, r.SetAdmins([]string{"alice-laptop", "bob"}, 3))
+	assert.Nil(t, r.SetAdmins([]string{"alice-laptop", "bob"}, 2))
+	assert.Equal(t, ErrNotAdmin, r.Sign(carol))
+
+	tests := []struct {
+		name    string
+		signers []*rsa.PrivateKey
+		expErr  bool
+	}{
+		{name: "unsigned", expErr: true},
+		{name: "one of two", signers: []*rsa.PrivateKey{alice}, expErr: true},
+		{name: "the same admin twice", signers: []*rsa.PrivateKey{alice, alice}, expErr: true},
+		{name: "two of two", signers: []*rsa.PrivateKey{alice, bob}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := r.Marshal()
+			assert.Nil(t, err)
+			signed, err := Parse(data)
+			assert.Nil(t, err)
+			for _, priv := range test.signers {
+				assert.Nil(t, signed.Sign(priv))
+			}
+
+			// signatures survive a round trip
+			data, err = signed.Marshal()
+			assert.Nil(t, err)
+			parsed, err := Parse(data)
+			assert.Nil(t, err)
+			_, err = parsed.Resolve("ops")
+			assert.True(t, errors.Is(err, ErrNotAnchored))
+			parsed.AnchorTo(r)
+			_, err = parsed.Resolve("ops")
+			assert.Equal(t, test.expErr, err != nil)
+			if test.expErr {
+				assert.True(t, errors.Is(err, ErrNotSigned))
+				return
+			}
+			assert.Equal(t, []string{"alice-laptop", "bob"}, parsed.Signers())
+
+			// any change must be signed again
+			tampered := strings.Replace(string(data), "groups: [dev]", "groups: [dev, ops]", 1)
+			parsed, err = Parse([]byte(tampered))
+			assert.Nil(t, err)
+			assert.Empty(t, parsed.Signers())
+			parsed.AnchorTo(r)
+			_, err = parsed.Resolve("ops")
+			assert.True(t, errors.Is(err, ErrNotSigned))
+
+			// including dropping the admins, which the anchor still requires
+			unadministered := strings.Replace(tampered, "admins:\n  require: 2\n  keys: [alice-laptop, bob]\n", "", 1)
+			assert.NotEqual(t, tampered, unadministered)
+			parsed, err = Parse([]byte(unadministered))
+			assert.Nil(t, err)
+			parsed.AnchorTo(r)
+			_, err = parsed.Resolve("ops")
+			assert.True(t, errors.Is(err, ErrNotSigned))
+			assert.EqualError(t, err, "keyring is not signed by enough admins: 0 of 2 required signatures by its trusted admins")
+		})
+	}
+}
+
+func TestVerifyUpdate(t *testing.T) {
+	alice, bob, carol := loadTestPrivateKey(t, "alice"), loadTestPrivateKey(t, "bob"), loadTestPrivateKey(t, "carol")
+	previous := testKeyring(t)
+	assert.Nil(t, previous.SetAdmins([]string{"alice-laptop", "bob"}, 2))
+	assert.Nil(t, previous.Sign(alice))
+	assert.Nil(t, previous.Sign(bob))
+
+	// carol makes herself the only admin and signs the keyring
+	update := testKeyring(t)
+	assert.Nil(t, update.SetAdmins([]string{"carol"}, 1))
+	assert.Nil(t, update.Sign(carol))
+	_, err := update.Resolve("ops")
+	assert.Nil(t, err)
+	update.AnchorTo(previous)
+	_, err = update.Resolve("ops")
+	assert.True(t, errors.Is(err, ErrNotSigned))
+	err = update.VerifyUpdate(previous)
+	assert.True(t, errors.Is(err, ErrNotSigned))
+	assert.Equal(t, "keyring is not signed by enough admins: 0 of 2 required signatures by the admins of the previous keyring", err.Error())
+
+	assert.Equal(t, ErrNotAdmin, update.Sign(alice))
+
+	// unless the previous admins agree
+	update = testKeyring(t)
+	assert.Nil(t, update.SetAdmins([]string{"alice-laptop", "bob", "carol"}, 2))
+	assert.Nil(t, update.Sign(alice))
+	assert.True(t, errors.Is(update.VerifyUpdate(previous), ErrNotSigned))
+	assert.Nil(t, update.Sign(bob))
+	assert.Nil(t, update.VerifyUpdate(previous))
+	assert.Nil(t, update.VerifyUpdate(testKeyring(t)))
+
+	// revoked admins do not count
+	rv, err := NewRevocation(update.Keys()[0].Fingerprint(), "stolen", time.Now(), "bob", bob)
+	assert.Nil(t, err)
+	assert.Nil(t, update.Revoke(rv))
+	assert.Empty(t, update.Signatures())
+	assert.NotNil(t, update.Sign(alice))
+	assert.Nil(t, update.Sign(bob))
+	assert.Nil(t, update.Sign(carol))
+	assert.Nil(t, update.CheckSigned())
+	assert.True(t, errors.Is(update.VerifyUpdate(previous), ErrNotSigned))
+}
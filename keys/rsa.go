@@ -1,6 +1,7 @@
 package keys
 
 import (
+	"crypto"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
@@ -11,6 +12,7 @@ import (
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
+	"io"
 )
 
 // GenerateRSAKeyPair generates an RSA key-pair and returns it separately
@@ -68,8 +70,14 @@ func DecodePubKeyPEM(pk []byte) (*rsa.PublicKey, error) {
 
 // EncryptMessage encrypts a plaintext message with a public key
 func EncryptMessage(plaintxt []byte, pub *rsa.PublicKey) ([]byte, error) {
+	return EncryptMessageWithRand(plaintxt, pub, rand.Reader)
+}
+
+// EncryptMessageWithRand encrypts a plaintext message with a public key,
+// drawing the OAEP seed from the given source of randomness
+func EncryptMessageWithRand(plaintxt []byte, pub *rsa.PublicKey, random io.Reader) ([]byte, error) {
 	hash := sha512.New()
-	cyphertxt, err := rsa.EncryptOAEP(hash, rand.Reader, pub, plaintxt, nil)
+	cyphertxt, err := rsa.EncryptOAEP(hash, random, pub, plaintxt, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -103,3 +111,21 @@ func DecryptMessageWithPEMKey(cyphertxt []byte, priv []byte) ([]byte, error) {
 	}
 	return DecryptMessage(cyphertxt, k)
 }
+
+// SignMessage signs a message with a private key using RSA-PSS
+func SignMessage(msg []byte, priv *rsa.PrivateKey) ([]byte, error) {
+	return SignMessageWithRand(msg, priv, rand.Reader)
+}
+
+// SignMessageWithRand signs a message with a private key using RSA-PSS,
+// drawing the salt from the given source of randomness
+func SignMessageWithRand(msg []byte, priv *rsa.PrivateKey, random io.Reader) ([]byte, error) {
+	digest := sha512.Sum512(msg)
+	return rsa.SignPSS(random, priv, crypto.SHA512, digest[:], nil)
+}
+
+// VerifySignature verifies an RSA-PSS signature over a message
+func VerifySignature(msg []byte, sig []byte, pub *rsa.PublicKey) error {
+	digest := sha512.Sum512(msg)
+	return rsa.VerifyPSS(pub, crypto.SHA512, digest[:], sig, nil)
+}
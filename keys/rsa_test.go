@@ -2,6 +2,7 @@ package keys
 
 import (
 	"crypto/rsa"
+	mathrand "math/rand"
 	"strings"
 	"testing"
 
@@ -223,3 +224,43 @@ func TestDecryptMessageWithPEMKey(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, decrypted)
 }
+
+func TestEncryptMessageWithRand(t *testing.T) {
+	pub, err := DecodePubKeyPEM(pubA)
+	assert.Nil(t, err)
+	priv, err := DecodePrivKeyPEM(privA)
+	assert.Nil(t, err)
+
+	// same randomness produces the same ciphertext
+	a, err := EncryptMessageWithRand([]byte("secretmsg"), pub, mathrand.New(mathrand.NewSource(1)))
+	assert.Nil(t, err)
+	b, err := EncryptMessageWithRand([]byte("secretmsg"), pub, mathrand.New(mathrand.NewSource(1)))
+	assert.Nil(t, err)
+	assert.Equal(t, a, b)
+
+	decrypted, err := DecryptMessage(a, priv)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secretmsg"), decrypted)
+
+	// negative test - exhausted randomness
+	encrypted, err := EncryptMessageWithRand([]byte("secretmsg"), pub, strings.NewReader(""))
+	assert.NotNil(t, err)
+	assert.Nil(t, encrypted)
+}
+
+func TestSignVerify(t *testing.T) {
+	priv, err := DecodePrivKeyPEM(privA)
+	assert.Nil(t, err)
+	other, err := DecodePrivKeyPEM(privB)
+	assert.Nil(t, err)
+
+	sig, err := SignMessage([]byte("msg"), priv)
+	assert.Nil(t, err)
+
+	// positive test
+	assert.Nil(t, VerifySignature([]byte("msg"), sig, &priv.PublicKey))
+
+	// negative tests - tampered message, wrong key
+	assert.NotNil(t, VerifySignature([]byte("msg!"), sig, &priv.PublicKey))
+	assert.NotNil(t, VerifySignature([]byte("msg"), sig, &other.PublicKey))
+}
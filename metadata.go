@@ -0,0 +1,194 @@
+package multikey
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	headerName        = "Name"
+	headerDescription = "Description"
+	headerCreated     = "Created"
+	headerCreatedBy   = "Created-By"
+	headerContentType = "Content-Type"
+	headerLabels      = "Labels"
+	headerNotAfter    = "Not-After"
+	headerRotateBy    = "Rotate-By"
+
+	errMsgBadMetadata = "invalid metadata"
+)
+
+var (
+	// ErrInvalidMetadata is returned when encrypting a secret with metadata
+	// which can not be kept in its headers
+	ErrInvalidMetadata = errors.New(errMsgBadMetadata)
+
+	labelRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+)
+
+// Metadata describes a secret. It is kept in the clear in the headers of
+// the secret, so that it can be read without any of its keys, and is
+// authenticated along with the secret's payload, so that it can not be
+// changed without decryption, or verification of the secret's signature,
+// failing.
+type Metadata struct {
+	// Name and Description say what the secret is. They must fit on a line.
+	Name        string
+	Description string
+
+	// Created is when the secret was created, and CreatedBy the
+	// fingerprint of the key of who created it. Only the signer of a
+	// signed secret is vouched for by a key.
+	Created   time.Time
+	CreatedBy string
+
+	// ContentType is the media type of the plaintext, e.g. text/plain
+	ContentType string
+
+	// Labels are free-form key value pairs, e.g. env=prod. Their keys are
+	// letters, digits and ._/- characters.
+	Labels map[string]string
+
+	// NotAfter, when set, is when the secret expires: decrypting it
+	// fails from then on, unless explicitly allowed
+	NotAfter time.Time
+
+	// RotateBy, when set, is when the secret is due to be rotated
+	RotateBy time.Time
+}
+
+// headers returns the PEM headers holding the metadata
+func (m *Metadata) headers() map[string]string {
+	h := map[string]string{}
+	if m == nil {
+		return h
+	}
+	for name, value := range map[string]string{
+		headerName:        m.Name,
+		headerDescription: m.Description,
+		headerCreatedBy:   m.CreatedBy,
+		headerContentType: m.ContentType,
+	} {
+		if value != "" {
+			h[name] = value
+		}
+	}
+	for name, t := range map[string]time.Time{
+		headerCreated:  m.Created,
+		headerNotAfter: m.NotAfter,
+		headerRotateBy: m.RotateBy,
+	} {
+		if !t.IsZero() {
+			h[name] = t.UTC().Format(time.RFC3339)
+		}
+	}
+	if len(m.Labels) > 0 {
+		labels := url.Values{}
+		for k, v := range m.Labels {
+			labels.Set(k, v)
+		}
+		h[headerLabels] = labels.Encode()
+	}
+	return h
+}
+
+// validate checks that the metadata survives being kept in PEM headers
+func (m *Metadata) validate() error {
+	if m == nil {
+		return nil
+	}
+	for name, value := range map[string]string{
+		"name":        m.Name,
+		"description": m.Description,
+		"created by":  m.CreatedBy,
+	} {
+		if strings.ContainsAny(value, "\r\n") || strings.TrimSpace(value) != value {
+			return fmt.Errorf("%w: %s must be a single line without surrounding space", ErrInvalidMetadata, name)
+		}
+	}
+	if m.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(m.ContentType); err != nil {
+			return fmt.Errorf("%w: content type: %s", ErrInvalidMetadata, err)
+		}
+	}
+	for k := range m.Labels {
+		if !labelRegex.MatchString(k) {
+			return fmt.Errorf("%w: label %q must be letters, digits and ._/- characters", ErrInvalidMetadata, k)
+		}
+	}
+	return nil
+}
+
+// empty reports whether there is no metadata
+func (m *Metadata) empty() bool {
+	return m == nil || len(m.headers()) == 0
+}
+
+// parseMetadata returns the metadata kept in the PEM headers of a secret,
+// or nil if there is none
+func parseMetadata(h map[string]string) (*Metadata, error) {
+	m := &Metadata{
+		Name:        h[headerName],
+		Description: h[headerDescription],
+		CreatedBy:   h[headerCreatedBy],
+		ContentType: h[headerContentType],
+	}
+	for name, t := range map[string]*time.Time{
+		headerCreated:  &m.Created,
+		headerNotAfter: &m.NotAfter,
+		headerRotateBy: &m.RotateBy,
+	} {
+		value, ok := h[name]
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s time %q", errMsgBadHeader, name, value)
+		}
+		*t = parsed
+	}
+	if labels, ok := h[headerLabels]; ok {
+		values, err := url.ParseQuery(labels)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid labels %q", errMsgBadHeader, labels)
+		}
+		m.Labels = map[string]string{}
+		for k, v := range values {
+			m.Labels[k] = v[len(v)-1]
+		}
+	}
+	if m.empty() {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Expired reports whether the secret has expired at the given time
+func (m *Metadata) Expired(now time.Time) bool {
+	return m != nil && !m.NotAfter.IsZero() && !now.Before(m.NotAfter)
+}
+
+// Overdue reports whether the secret is due to be rotated at the given time
+func (m *Metadata) Overdue(now time.Time) bool {
+	return m != nil && !m.RotateBy.IsZero() && !now.Before(m.RotateBy)
+}
+
+// LabelKeys returns the keys of the labels of the metadata, sorted
+func (m *Metadata) LabelKeys() []string {
+	ks := []string{}
+	if m == nil {
+		return ks
+	}
+	for k := range m.Labels {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,128 @@
+package multikey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	md := &Metadata{
+		Name:        "db-password",
+		Description: "Password of the payments database: rotate with the DBA team",
+		Created:     time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC),
+		CreatedBy:   keys.GetFingerprint(pubs[0]),
+		ContentType: "text/plain; charset=utf-8",
+		Labels:      map[string]string{"env": "prod", "team": "payments & billing"},
+	}
+	enc, err := EncryptWithOptions([]byte("hunter2"), pubs, 2, &EncryptOptions{Metadata: md})
+	assert.Nil(t, err)
+	assert.Contains(t, enc, "Name: db-password\n")
+	assert.Contains(t, enc, "Created: 2026-10-19T12:30:00Z\n")
+	assert.Contains(t, enc, "Labels: env=prod&team=payments+%26+billing\n")
+
+	// metadata can be read without keys
+	info, err := Inspect(enc)
+	assert.Nil(t, err)
+	assert.Equal(t, md, info.Metadata)
+	assert.Equal(t, []string{"env", "team"}, info.Metadata.LabelKeys())
+
+	// and can not be changed without detection
+	for _, tampered := range []string{
+		strings.Replace(enc, "Name: db-password", "Name: api-token", 1),
+		strings.Replace(enc, "Labels: env=prod", "Labels: env=dev", 1),
+		strings.Replace(enc, "Content-Type: text/plain; charset=utf-8\n", "", 1),
+	} {
+		assert.NotEqual(t, enc, tampered)
+		_, err = Decrypt(tampered, privs)
+		assert.EqualError(t, err, errMsgCouldNotOpenPayload)
+	}
+	plain, err := Decrypt(enc, privs)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hunter2"), plain)
+
+	// updates keep the metadata, unless given new metadata
+	updated, err := Update(enc, []byte("hunter3"), privs, pubs, 2)
+	assert.Nil(t, err)
+	info, err = Inspect(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, md, info.Metadata)
+	updated, err = UpdateWithOptions(enc, []byte("hunter2"), privs, pubs, 2, &EncryptOptions{Metadata: &Metadata{Name: "renamed"}})
+	assert.Nil(t, err)
+	info, err = Inspect(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, &Metadata{Name: "renamed"}, info.Metadata)
+	plain, err = Decrypt(updated, privs)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hunter2"), plain)
+
+	rewrapped, err := Rewrap(enc, privs, pubs[:2], 1)
+	assert.Nil(t, err)
+	info, err = Inspect(rewrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, md, info.Metadata)
+	_, err = Decrypt(rewrapped, privs[:1])
+	assert.Nil(t, err)
+
+	unlabelled, err := Encrypt([]byte("hunter2"), pubs, 2)
+	assert.Nil(t, err)
+	info, err = Inspect(unlabelled)
+	assert.Nil(t, err)
+	assert.Nil(t, info.Metadata)
+}
+
+func TestInvalidMetadata(t *testing.T) {
+	_, pubs := loadTestKeys(t, "alice")
+	tests := []struct {
+		testName string
+		metadata *Metadata
+	}{
+		{testName: "multi-line description", metadata: &Metadata{Description: "line one\nline two"}},
+		{testName: "surrounding space", metadata: &Metadata{Name: " db "}},
+		{testName: "bad content type", metadata: &Metadata{ContentType: "text/"}},
+		{testName: "bad label key", metadata: &Metadata{Labels: map[string]string{"has space": "x"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			_, err := EncryptWithOptions([]byte("secret"), pubs, 1, &EncryptOptions{Metadata: test.metadata})
+			assert.True(t, errors.Is(err, ErrInvalidMetadata), "%v", err)
+		})
+	}
+}
+
+func TestExpiredSecret(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob")
+	yesterday := time.Now().UTC().Truncate(time.Second).Add(-24 * time.Hour)
+	expired, err := EncryptWithOptions([]byte("hunter2"), pubs, 1, &EncryptOptions{Metadata: &Metadata{NotAfter: yesterday, RotateBy: yesterday}})
+	assert.Nil(t, err)
+	assert.Contains(t, expired, "Not-After: "+yesterday.Format(time.RFC3339)+"\n")
+	assert.Contains(t, expired, "Rotate-By: "+yesterday.Format(time.RFC3339)+"\n")
+	info, err := Inspect(expired)
+	assert.Nil(t, err)
+	assert.True(t, info.Metadata.Expired(time.Now()))
+	assert.True(t, info.Metadata.Overdue(time.Now()))
+	assert.False(t, info.Metadata.Expired(yesterday.Add(-time.Second)))
+
+	_, err = Decrypt(expired, privs)
+	assert.True(t, errors.Is(err, ErrExpired), "%v", err)
+	plain, err := DecryptWithOptions(expired, privs, &DecryptOptions{AllowExpired: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hunter2"), plain)
+
+	// the not after time can not be changed without detection
+	extended := strings.Replace(expired, "Not-After: "+yesterday.Format(time.RFC3339), "Not-After: 2999-01-01T00:00:00Z", 1)
+	_, err = Decrypt(extended, privs)
+	assert.EqualError(t, err, errMsgCouldNotOpenPayload)
+
+	// but expired secrets can be renewed by updating them
+	renewed, err := UpdateWithOptions(expired, []byte("hunter3"), privs, pubs, 1, &EncryptOptions{Metadata: &Metadata{NotAfter: time.Now().Add(time.Hour)}})
+	assert.Nil(t, err)
+	plain, err = Decrypt(renewed, privs)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hunter3"), plain)
+}
@@ -1,62 +1,224 @@
 package multikey
 
 import (
+	"crypto/rand"
 	"crypto/rsa"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/adrianosela/multikey/keys"
 	"github.com/adrianosela/multikey/shamir"
 )
 
 const (
-	errMsgRequireTooBig = "require must be less than or equal to the amount of keys provided"
+	errMsgRequireTooBig      = "require must be less than or equal to the amount of keys provided"
+	errMsgInsufficientKeys   = "not enough keys were provided to decrypt the secret"
+	errMsgCouldNotSplitKey   = "error splitting data key"
+	errMsgEmptySecretPayload = "cannot encrypt an empty secret"
+	errMsgExpired            = "secret has expired"
 )
 
+var (
+	// ErrMalformedSecret is returned when an encrypted secret can not be decoded
+	ErrMalformedSecret = errors.New(errMsgCouldNotDecode)
+
+	// ErrInsufficientKeys is returned when fewer of a secret's keys than
+	// its threshold were provided to decrypt it
+	ErrInsufficientKeys = errors.New(errMsgInsufficientKeys)
+
+	// ErrExpired is returned when decrypting a secret past its not after
+	// time, unless that is allowed
+	ErrExpired = errors.New(errMsgExpired)
+)
+
+// EncryptOptions configures optional behaviour of EncryptWithOptions.
+type EncryptOptions struct {
+	// Rand is the source of randomness used to split and encrypt the
+	// secret. Defaults to crypto/rand.Reader when nil. Setting it to a
+	// deterministic stream makes the output reproducible, which is only
+	// ever appropriate in tests.
+	Rand io.Reader
+
+	// Signer, when set, signs the secret as its author, so that those
+	// decrypting it can tell it was not replaced by someone else
+	Signer *rsa.PrivateKey
+
+	// Context, when set, binds the secret to a context such as the path
+	// of the file it is kept in, the name of the secret or an
+	// environment. The context is not recorded in the secret: the same
+	// context must be given to decrypt it, so that a secret copied to
+	// another file or environment no longer decrypts.
+	Context string
+
+	// Metadata, when set, describes the secret in its headers. Updating
+	// or rewrapping a secret without it keeps the secret's metadata.
+	Metadata *Metadata
+}
+
+func (o *EncryptOptions) rand() io.Reader {
+	if o == nil || o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
+func (o *EncryptOptions) context() string {
+	if o == nil {
+		return ""
+	}
+	return o.Context
+}
+
+func (o *EncryptOptions) metadata() *Metadata {
+	if o == nil {
+		return nil
+	}
+	return o.Metadata
+}
+
+// keepMetadata returns options which describe a secret with the given
+// metadata, unless they give their own
+func (o *EncryptOptions) keepMetadata(m *Metadata) *EncryptOptions {
+	if o.metadata() != nil || m == nil {
+		return o
+	}
+	kept := &EncryptOptions{}
+	if o != nil {
+		*kept = *o
+	}
+	kept.Metadata = m
+	return kept
+}
+
+// sign signs a secret with the options' signer, if any, and otherwise
+// removes any signature it has
+func (o *EncryptOptions) sign(s *secret) error {
+	if o == nil || o.Signer == nil {
+		s.unsign()
+		return nil
+	}
+	return s.sign(o.Signer, o.rand())
+}
+
 // Encrypt encrypts a secret with a given set of public keys.
 // The secret will be decryptable with `require` of the given keys.
 func Encrypt(data []byte, pubs []*rsa.PublicKey, require int) (string, error) {
+	return EncryptWithOptions(data, pubs, require, nil)
+}
+
+// EncryptWithOptions is like Encrypt but allows for optional
+// behaviour to be configured through the given options.
+func EncryptWithOptions(data []byte, pubs []*rsa.PublicKey, require int, opts *EncryptOptions) (string, error) {
 	if require > len(pubs) {
 		return "", fmt.Errorf(errMsgRequireTooBig)
 	}
-	secret := &secret{
-		shards: []*encryptedShard{},
+	if len(data) == 0 {
+		return "", errors.New(errMsgEmptySecretPayload)
+	}
+	if err := opts.metadata().validate(); err != nil {
+		return "", err
 	}
-	parts, err := shamir.Split(data, len(pubs), require)
+	dataKey, err := newDataKey(opts.rand())
 	if err != nil {
-		return "", fmt.Errorf("error splitting rule components: %s", err)
+		return "", err
+	}
+	parts, err := shamir.SplitWithOptions(dataKey, len(pubs), require, &shamir.Options{Rand: opts.rand()})
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", errMsgCouldNotSplitKey, err)
+	}
+	secret, err := encryptParts(parts, pubs, opts)
+	if err != nil {
+		return "", err
+	}
+	for i, part := range parts {
+		secret.shards[i].X = int(part[len(part)-1])
+	}
+	secret.sortShards()
+	secret.version = currentVersion
+	secret.threshold = require
+	secret.bind(opts.context())
+	if !opts.metadata().empty() {
+		secret.metadata = opts.metadata()
+	}
+	if secret.payload, err = sealPayload(dataKey, data, secret.associatedData(), opts.rand()); err != nil {
+		return "", err
+	}
+	if err := opts.sign(secret); err != nil {
+		return "", err
+	}
+	return secret.encodePEM()
+}
+
+// encryptParts encrypts the i-th shamir part with the i-th public key
+// and returns the resulting encrypted secret
+func encryptParts(parts [][]byte, pubs []*rsa.PublicKey, opts *EncryptOptions) (*secret, error) {
+	secret := &secret{
+		shards: []*encryptedShard{},
 	}
 	for i, part := range parts {
 		s, err := newShard(part)
 		if err != nil {
-			return "", fmt.Errorf("error creating new shard object: %s", err)
+			return nil, fmt.Errorf("error creating new shard object: %s", err)
 		}
-		enc, err := s.encrypt(pubs[i])
+		enc, err := s.encryptWithRand(pubs[i], opts.rand())
 		if err != nil {
-			return "", fmt.Errorf("error encrypting shard: %s", err)
+			return nil, fmt.Errorf("error encrypting shard: %s", err)
 		}
 		secret.shards = append(secret.shards, enc)
 	}
-	return secret.encodePEM()
+	return secret, nil
+}
+
+// DecryptOptions configures optional behaviour of DecryptWithOptions.
+type DecryptOptions struct {
+	// Signers are the keys trusted to sign secrets. When set, secrets
+	// must be signed by one of them.
+	Signers []*rsa.PublicKey
+
+	// SignerPolicy decides which keys are trusted to sign secrets. When
+	// set, secrets must be signed by a key it trusts. It takes
+	// precedence over Signers.
+	SignerPolicy SignerPolicy
+
+	// Context is the context the secret was bound to when encrypted.
+	// Secrets which are not bound to a context decrypt whatever it is.
+	Context string
+
+	// AllowExpired allows decrypting secrets past their not after time
+	AllowExpired bool
+}
+
+func (o *DecryptOptions) context() string {
+	if o == nil {
+		return ""
+	}
+	return o.Context
 }
 
 // Decrypt decrypts a secret with a provided set of keys.
+// The signature of signed secrets is not checked; use DecryptWithOptions
+// to require one from a trusted key.
 func Decrypt(enc string, privs []*rsa.PrivateKey) ([]byte, error) {
+	return DecryptWithOptions(enc, privs, nil)
+}
+
+// DecryptWithOptions is like Decrypt but allows for optional
+// behaviour to be configured through the given options.
+func DecryptWithOptions(enc string, privs []*rsa.PrivateKey, opts *DecryptOptions) ([]byte, error) {
 	s, err := decodePEM(enc)
 	if err != nil {
-		return nil, errors.New(errMsgCouldNotDecode)
-	}
-	decryptedShBytes := [][]byte{}
-	for _, sh := range s.shards {
-		if k, ok := getKey(privs, sh.KeyID); ok {
-			decrypted, err := sh.decrypt(k)
-			if err != nil {
-				continue // pass
-			}
-			decryptedShBytes = append(decryptedShBytes, decrypted.Value)
-		}
+		return nil, ErrMalformedSecret
+	}
+	if err := s.verifySignature(opts); err != nil {
+		return nil, err
+	}
+	if s.metadata.Expired(time.Now()) && (opts == nil || !opts.AllowExpired) {
+		return nil, fmt.Errorf("%w on %s", ErrExpired, s.metadata.NotAfter.Format(time.RFC3339))
 	}
-	return shamir.Combine(decryptedShBytes)
+	s.context = opts.context()
+	return s.open(s.decryptShards(privs))
 }
 
 func getKey(privs []*rsa.PrivateKey, id string) (*rsa.PrivateKey, bool) {
@@ -1,10 +1,17 @@
 package multikey
 
 import (
+	"bytes"
 	"crypto/rsa"
+	"flag"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/shamir"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -43,3 +50,127 @@ func TestEncryptDecrypt(t *testing.T) {
 		}
 	}
 }
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+func loadTestKeys(t *testing.T, names ...string) ([]*rsa.PrivateKey, []*rsa.PublicKey) {
+	privs, pubs := []*rsa.PrivateKey{}, []*rsa.PublicKey{}
+	for _, name := range names {
+		pemBytes, err := os.ReadFile(filepath.Join("testdata", name+".pem"))
+		if err != nil {
+			assert.FailNow(t, "could not read test key", err.Error())
+		}
+		priv, err := keys.DecodePrivKeyPEM(pemBytes)
+		if err != nil {
+			assert.FailNow(t, "could not decode test key", err.Error())
+		}
+		privs = append(privs, priv)
+		pubs = append(pubs, &priv.PublicKey)
+	}
+	return privs, pubs
+}
+
+func TestEncryptWithOptionsDeterministic(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	testSecret := []byte("test secret value")
+
+	encrypt := func() string {
+		opts := &EncryptOptions{Rand: mathrand.New(mathrand.NewSource(1))}
+		enc, err := EncryptWithOptions(testSecret, pubs, 2, opts)
+		assert.Nil(t, err)
+		return enc
+	}
+
+	enc := encrypt()
+	assert.Equal(t, enc, encrypt(), "same randomness must produce identical output")
+
+	golden := filepath.Join("testdata", "deterministic.golden")
+	if *update {
+		assert.Nil(t, os.WriteFile(golden, []byte(enc), 0644))
+	}
+	expected, err := os.ReadFile(golden)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), enc)
+
+	plain, err := Decrypt(enc, privs[1:])
+	assert.Nil(t, err)
+	assert.Equal(t, testSecret, plain)
+}
+
+func TestEncryptDecryptLargeSecret(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	// much larger than what fits in a single RSA-OAEP block
+	testSecret := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+
+	enc, err := Encrypt(testSecret, pubs, 2)
+	assert.Nil(t, err)
+
+	plain, err := Decrypt(enc, privs[:2])
+	assert.Nil(t, err)
+	assert.Equal(t, testSecret, plain)
+
+	_, err = Decrypt(enc, privs[:1])
+	assert.Equal(t, ErrInsufficientKeys, err)
+
+	_, err = Decrypt("garbage", privs)
+	assert.Equal(t, ErrMalformedSecret, err)
+
+	_, err = Encrypt(nil, pubs, 2)
+	assert.EqualError(t, err, errMsgEmptySecretPayload)
+}
+
+func TestDecryptTamperedHeader(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	enc, err := Encrypt([]byte("test secret value"), pubs, 2)
+	assert.Nil(t, err)
+
+	// lowering the threshold is detected
+	tampered := strings.Replace(enc, "Threshold: 2", "Threshold: 1", 1)
+	_, err = Decrypt(tampered, privs)
+	assert.EqualError(t, err, errMsgCouldNotOpenPayload)
+
+	// as are unsupported versions
+	tampered = strings.Replace(enc, "Version: 2", "Version: 9", 1)
+	_, err = Decrypt(tampered, privs)
+	assert.Equal(t, ErrMalformedSecret, err)
+}
+
+func TestDecryptLegacySecret(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob")
+	// legacy secrets have the plaintext itself split into shards
+	parts, err := shamir.Split([]byte("legacy secret"), 2, 2)
+	assert.Nil(t, err)
+	sec, err := encryptParts(parts, pubs, nil)
+	assert.Nil(t, err)
+	enc, err := sec.encodePEM()
+	assert.Nil(t, err)
+	assert.NotContains(t, enc, "Version")
+
+	plain, err := Decrypt(enc, privs)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("legacy secret"), plain)
+
+	info, err := Inspect(enc)
+	assert.Nil(t, err)
+	assert.Equal(t, legacyVersion, info.Version)
+	assert.Equal(t, 0, info.Threshold)
+}
+
+func TestInspect(t *testing.T) {
+	_, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	enc, err := Encrypt([]byte("test secret value"), pubs, 2)
+	assert.Nil(t, err)
+
+	info, err := Inspect(enc)
+	assert.Nil(t, err)
+	assert.Equal(t, currentVersion, info.Version)
+	assert.Equal(t, 2, info.Threshold)
+	assert.Equal(t, []string{
+		keys.GetFingerprint(pubs[0]),
+		keys.GetFingerprint(pubs[1]),
+		keys.GetFingerprint(pubs[2]),
+	}, info.KeyIDs)
+
+	_, err = Inspect("garbage")
+	assert.Equal(t, ErrMalformedSecret, err)
+}
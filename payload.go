@@ -0,0 +1,69 @@
+package multikey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	dataKeySize = 32 // AES-256
+
+	errMsgCouldNotSealPayload = "could not encrypt secret payload"
+	errMsgCouldNotOpenPayload = "could not decrypt secret payload"
+)
+
+// ErrAuthenticationFailed is returned when the payload of a secret fails
+// authentication, because the secret was altered or, for secrets bound to
+// a context, decrypted with a different context
+var ErrAuthenticationFailed = errors.New(errMsgCouldNotOpenPayload)
+
+// newDataKey returns a random key for encrypting a secret's payload
+func newDataKey(rand io.Reader) ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand, key); err != nil {
+		return nil, fmt.Errorf("could not generate data key: %s", err)
+	}
+	return key, nil
+}
+
+// sealPayload encrypts and authenticates a plaintext with AES-256-GCM,
+// returning the nonce followed by the ciphertext
+func sealPayload(key, plaintext, ad []byte, rand io.Reader) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", errMsgCouldNotSealPayload, err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand, nonce); err != nil {
+		return nil, fmt.Errorf("%s: %s", errMsgCouldNotSealPayload, err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// openPayload reverses sealPayload
+func openPayload(key, payload, ad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", errMsgCouldNotOpenPayload, err)
+	}
+	if len(payload) < aead.NonceSize() {
+		return nil, ErrAuthenticationFailed
+	}
+	nonce, ciphertext := payload[:aead.NonceSize()], payload[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
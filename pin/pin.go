@@ -0,0 +1,170 @@
+// Package pin protects encryption from substituted recipient keys by
+// trusting them on first use. The first time a recipient name, such as the
+// path of a key file or the name of a key in a keyring, resolves to a key,
+// its fingerprint is pinned in a local state file. Should the name later
+// resolve to a different key, Check fails until the change is accepted.
+//
+// The admins of a keyring, who sign it, are pinned the same way, so that
+// only they can change it: its keys are only trusted if the admins pinned
+// for it signed it.
+package pin
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/adrianosela/multikey/keys"
+)
+
+const errMsgMismatch = "key does not match its pinned fingerprint"
+
+// ErrMismatch is returned, wrapped in a *MismatchError, when a name
+// resolves to a different key than the one pinned for it
+var ErrMismatch = errors.New(errMsgMismatch)
+
+// Pin is the fingerprint of the key a name was first seen to resolve to,
+// or the admins of a keyring and how many of them must sign it
+type Pin struct {
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Admins      []string  `json:"admins,omitempty"` // fingerprints
+	Require     int       `json:"require,omitempty"`
+	Pinned      time.Time `json:"pinned"`
+}
+
+// MismatchError describes a name which resolves to a different key than
+// the one pinned for it
+type MismatchError struct {
+	Name        string
+	Pinned      string // fingerprint
+	Fingerprint string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s: %s %s, it is now %s", e.Name, errMsgMismatch, e.Pinned, e.Fingerprint)
+}
+
+func (e *MismatchError) Unwrap() error {
+	return ErrMismatch
+}
+
+// Store is a set of pins kept in a file
+type Store struct {
+	path    string
+	pins    map[string]*Pin
+	changed bool
+}
+
+// DefaultPath returns the path of the user's pin store
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "multikey", "pins.json"), nil
+}
+
+// Load reads the pin store at path, which is empty if it does not exist
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, pins: map[string]*Pin{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pins []*Pin
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, p := range pins {
+		s.pins[p.Name] = p
+	}
+	return s, nil
+}
+
+// Check pins the key a name resolves to if the name is not pinned yet,
+// and otherwise returns a *MismatchError if the key is not the pinned one
+func (s *Store) Check(name string, pub *rsa.PublicKey) error {
+	fp := keys.GetFingerprint(pub)
+	p, ok := s.pins[name]
+	if !ok {
+		s.Accept(name, pub)
+		return nil
+	}
+	if p.Fingerprint != fp {
+		return &MismatchError{Name: name, Pinned: p.Fingerprint, Fingerprint: fp}
+	}
+	return nil
+}
+
+// Accept pins the key a name resolves to, replacing any previous pin
+func (s *Store) Accept(name string, pub *rsa.PublicKey) {
+	s.pins[name] = &Pin{Name: name, Fingerprint: keys.GetFingerprint(pub), Pinned: time.Now().UTC().Truncate(time.Second)}
+	s.changed = true
+}
+
+// Admins returns the fingerprints of the admins pinned for a keyring, and
+// how many of them must sign it
+func (s *Store) Admins(name string) ([]string, int, bool) {
+	p, ok := s.pins[name]
+	if !ok || p.Require == 0 {
+		return nil, 0, false
+	}
+	return append([]string{}, p.Admins...), p.Require, true
+}
+
+// AcceptAdmins pins the admins of a keyring, replacing any previous pin
+func (s *Store) AcceptAdmins(name string, fingerprints []string, require int) {
+	admins := append([]string{}, fingerprints...)
+	sort.Strings(admins)
+	s.pins[name] = &Pin{Name: name, Admins: admins, Require: require, Pinned: time.Now().UTC().Truncate(time.Second)}
+	s.changed = true
+}
+
+// Get returns the pin of a name
+func (s *Store) Get(name string) (*Pin, bool) {
+	p, ok := s.pins[name]
+	return p, ok
+}
+
+// Pins returns the pins of the store, sorted by name
+func (s *Store) Pins() []*Pin {
+	pins := []*Pin{}
+	for _, p := range s.pins {
+		pins = append(pins, p)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Name < pins[j].Name })
+	return pins
+}
+
+// Save writes the store to its file if pins were added or changed
+func (s *Store) Save() error {
+	if !s.changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.Pins(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.changed = false
+	return nil
+}
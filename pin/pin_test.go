@@ -0,0 +1,94 @@
+package pin
+
+import (
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// loadTestKeys loads the named public keys from the repository's testdata
+func loadTestKeys(t *testing.T, names ...string) []*rsa.PublicKey {
+	pubs := []*rsa.PublicKey{}
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join("..", "testdata", name+".pem"))
+		assert.Nil(t, err)
+		priv, err := keys.DecodePrivKeyPEM(raw)
+		assert.Nil(t, err)
+		pubs = append(pubs, &priv.PublicKey)
+	}
+	return pubs
+}
+
+func TestStore(t *testing.T) {
+	pubs := loadTestKeys(t, "alice", "bob")
+	alice, bob := pubs[0], pubs[1]
+	path := filepath.Join(t.TempDir(), "multikey", "pins.json")
+
+	s, err := Load(path)
+	assert.Nil(t, err)
+	assert.Empty(t, s.Pins())
+
+	// the first key a name resolves to is pinned
+	assert.Nil(t, s.Check("keys/alice.pub", alice))
+	assert.Nil(t, s.Check("keys/alice.pub", alice))
+	assert.Nil(t, s.Check("bob", bob))
+	assert.Nil(t, s.Save())
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// and any other key is refused until accepted
+	s, err = Load(path)
+	assert.Nil(t, err)
+	assert.Len(t, s.Pins(), 2)
+	err = s.Check("keys/alice.pub", bob)
+	assert.True(t, errors.Is(err, ErrMismatch))
+	var mismatch *MismatchError
+	assert.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, &MismatchError{Name: "keys/alice.pub", Pinned: keys.GetFingerprint(alice), Fingerprint: keys.GetFingerprint(bob)}, mismatch)
+	assert.Equal(t, "keys/alice.pub: key does not match its pinned fingerprint "+keys.GetFingerprint(alice)+", it is now "+keys.GetFingerprint(bob), err.Error())
+
+	s.Accept("keys/alice.pub", bob)
+	assert.Nil(t, s.Save())
+	s, err = Load(path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Check("keys/alice.pub", bob))
+	p, ok := s.Get("keys/alice.pub")
+	assert.True(t, ok)
+	assert.Equal(t, keys.GetFingerprint(bob), p.Fingerprint)
+	assert.False(t, p.Pinned.IsZero())
+
+	assert.Nil(t, os.WriteFile(path, []byte("not json"), 0600))
+	_, err = Load(path)
+	assert.NotNil(t, err)
+}
+
+func TestAdmins(t *testing.T) {
+	pubs := loadTestKeys(t, "alice", "bob")
+	alice, bob := keys.GetFingerprint(pubs[0]), keys.GetFingerprint(pubs[1])
+	path := filepath.Join(t.TempDir(), "pins.json")
+
+	s, err := Load(path)
+	assert.Nil(t, err)
+	_, _, ok := s.Admins("keyring.yaml admins")
+	assert.False(t, ok)
+
+	s.AcceptAdmins("keyring.yaml admins", []string{bob, alice}, 2)
+	assert.Nil(t, s.Save())
+	s, err = Load(path)
+	assert.Nil(t, err)
+	admins, require, ok := s.Admins("keyring.yaml admins")
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{alice, bob}, admins)
+	assert.Equal(t, 2, require)
+
+	// keys pinned for names are not admins
+	s.Accept("keys/alice.pub", pubs[0])
+	_, _, ok = s.Admins("keys/alice.pub")
+	assert.False(t, ok)
+}
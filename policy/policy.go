@@ -0,0 +1,419 @@
+// Package policy enforces repository-wide rules on who encrypted secrets
+// are encrypted for. A policy is kept in a .multikey.yaml file at the
+// root of a repository:
+//
+//	rules:
+//	  - path: prod/**
+//	    recipients: [keys/prod/]
+//	    require: 2
+//	    must_include: [keys/prod-kms.pub]
+//	  - path: "**"
+//	    recipients: [keys/]
+//	protected:
+//	  - "*.secret"
+//	  - prod/**
+//	revoked:
+//	  - keys/revoked/
+//	  - 61:83:df:df:10:73:0c:2a:96:1d:9e:99:7f:44:f1:3c
+//
+// The first rule whose path glob matches a file gives the recipients and
+// threshold to encrypt it with, and the minimum threshold and keys its
+// secrets must have. Files matching a protected glob must be encrypted,
+// and no secret may be encrypted for a revoked key.
+//
+// Globs match slash separated paths relative to the policy file. A "*"
+// matches within a path segment, "**" across segments, and globs without
+// a "/" match file names at any depth. Keys are given as public key
+// files, directories of *.pub files, or, where the keys themselves are
+// not needed, fingerprints.
+package policy
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/scan"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is the name of policy files
+const DefaultFile = ".multikey.yaml"
+
+const (
+	pubKeyExt = ".pub"
+
+	errMsgNotFound     = "no " + DefaultFile + " policy file found"
+	errMsgInvalid      = "invalid policy"
+	errMsgNoRecipients = "rule has no recipients"
+)
+
+// violation codes
+const (
+	CodeUnencrypted = "unencrypted"
+	CodeMalformed   = "malformed"
+	CodeThreshold   = "threshold"
+	CodeMissingKey  = "missing-key"
+	CodeRevokedKey  = "revoked-key"
+)
+
+var (
+	// ErrNotFound is returned by Find when there is no policy file
+	ErrNotFound = errors.New(errMsgNotFound)
+
+	// ErrNoRecipients is returned by Rule.PublicKeys for rules which only
+	// constrain secrets, without giving recipients to encrypt for
+	ErrNoRecipients = errors.New(errMsgNoRecipients)
+
+	fingerprintRegex = regexp.MustCompile(`^([0-9a-f]{2}:){15}[0-9a-f]{2}$`)
+	skippedDirs      = map[string]bool{".git": true}
+)
+
+// Policy is a set of rules for the encrypted secrets of a repository
+type Policy struct {
+	Rules     []*Rule  `yaml:"rules"`
+	Protected []string `yaml:"protected"`
+	Revoked   []string `yaml:"revoked"`
+
+	dir       string // key paths are relative to it
+	protected []*regexp.Regexp
+	revoked   map[string]bool // by fingerprint
+}
+
+// Rule determines who files matching a path glob are encrypted for
+type Rule struct {
+	// Path is the glob of the files the rule applies to
+	Path string `yaml:"path"`
+
+	// Recipients are the keys to encrypt files for by default
+	Recipients []string `yaml:"recipients"`
+
+	// Require is the threshold to encrypt files with by default, and the
+	// least threshold their secrets may have. Defaults to 1.
+	Require int `yaml:"require"`
+
+	// MustInclude are keys every secret in matching files must include
+	MustInclude []string `yaml:"must_include"`
+
+	regex       *regexp.Regexp
+	paths       []string // of recipients, relative to the working directory
+	pubs        []*rsa.PublicKey
+	mustInclude []string // fingerprints
+}
+
+// Violation is a breach of a policy
+type Violation struct {
+	Path    string `json:"file"`
+	Offset  int    `json:"offset"`
+	Rule    string `json:"rule,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Code == CodeUnencrypted {
+		return fmt.Sprintf("%s: %s: %s", v.Path, v.Code, v.Message)
+	}
+	return fmt.Sprintf("%s@%d: %s: %s", v.Path, v.Offset, v.Code, v.Message)
+}
+
+// Find looks for a policy file in dir and its parents
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, DefaultFile)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrNotFound
+		}
+		dir = parent
+	}
+}
+
+// Load reads a policy file, loading the keys it refers to
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return p, nil
+}
+
+// Parse parses a policy, loading the keys it refers to relative to dir
+func Parse(data []byte, dir string) (*Policy, error) {
+	p := &Policy{dir: dir, revoked: map[string]bool{}}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", errMsgInvalid, err)
+	}
+	for i, r := range p.Rules {
+		if r == nil || r.Path == "" {
+			return nil, fmt.Errorf("%s: rule %d has no path", errMsgInvalid, i+1)
+		}
+		if r.Require < 0 {
+			return nil, fmt.Errorf("%s: rule %s: require must not be negative", errMsgInvalid, r.Path)
+		}
+		if r.Require == 0 {
+			r.Require = 1
+		}
+		r.regex = globRegexp(r.Path)
+		r.paths = p.keyPaths(r.Recipients)
+		pubs, err := p.loadKeys(r.paths)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %s", r.Path, err)
+		}
+		if len(pubs) > 0 && r.Require > len(pubs) {
+			return nil, fmt.Errorf("%s: rule %s: require is more than its %d recipients", errMsgInvalid, r.Path, len(pubs))
+		}
+		r.pubs = pubs
+		if r.mustInclude, err = p.fingerprints(r.MustInclude); err != nil {
+			return nil, fmt.Errorf("rule %s: %s", r.Path, err)
+		}
+	}
+	for _, glob := range p.Protected {
+		p.protected = append(p.protected, globRegexp(glob))
+	}
+	revoked, err := p.fingerprints(p.Revoked)
+	if err != nil {
+		return nil, fmt.Errorf("revoked: %s", err)
+	}
+	for _, fp := range revoked {
+		p.revoked[fp] = true
+	}
+	return p, nil
+}
+
+// Dir returns the directory the policy applies to
+func (p *Policy) Dir() string {
+	return p.dir
+}
+
+// Match returns the first rule applying to a path relative to the
+// policy's directory, or nil if there is none
+func (p *Policy) Match(path string) *Rule {
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./")
+	for _, r := range p.Rules {
+		if r.regex.MatchString(path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// PublicKeys returns the keys to encrypt files matching the rule for
+func (r *Rule) PublicKeys() ([]*rsa.PublicKey, error) {
+	if len(r.pubs) == 0 {
+		return nil, ErrNoRecipients
+	}
+	return r.pubs, nil
+}
+
+// RecipientPaths returns the key files and directories of the recipients
+// of the rule, relative to the working directory rather than the policy's
+func (r *Rule) RecipientPaths() []string {
+	return append([]string{}, r.paths...)
+}
+
+// CheckSecret verifies a secret found at path, relative to the policy's
+// directory, against the policy
+func (p *Policy) CheckSecret(path string, offset int, info *multikey.Info) []Violation {
+	violations := []Violation{}
+	add := func(rule, code, format string, a ...interface{}) {
+		violations = append(violations, Violation{Path: path, Offset: offset, Rule: rule, Code: code, Message: fmt.Sprintf(format, a...)})
+	}
+	ids := map[string]bool{}
+	for _, id := range info.KeyIDs {
+		ids[id] = true
+		if p.revoked[id] {
+			add("", CodeRevokedKey, "encrypted for revoked key %s", id)
+		}
+	}
+	r := p.Match(path)
+	if r == nil {
+		return violations
+	}
+	if info.Threshold == 0 && r.Require > 1 {
+		add(r.Path, CodeThreshold, "threshold is not recorded, %d is required", r.Require)
+	} else if info.Threshold > 0 && info.Threshold < r.Require {
+		add(r.Path, CodeThreshold, "threshold is %d, at least %d is required", info.Threshold, r.Require)
+	}
+	for _, fp := range r.mustInclude {
+		if !ids[fp] {
+			add(r.Path, CodeMissingKey, "not encrypted for required key %s", fp)
+		}
+	}
+	return violations
+}
+
+// Check verifies every file within the policy's directory against the
+// policy, other than within .git directories. Violations are ordered by
+// path and offset.
+func (p *Policy) Check() ([]Violation, error) {
+	violations := []Violation{}
+	err := filepath.WalkDir(p.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if skippedDirs[d.Name()] && path != p.dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(p.dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		blocks := scan.Blocks(data)
+		if len(blocks) == 0 && p.isProtected(rel) {
+			violations = append(violations, Violation{Path: rel, Code: CodeUnencrypted, Message: "protected file is not encrypted"})
+		}
+		for _, b := range blocks {
+			if b.Err != nil {
+				violations = append(violations, Violation{Path: rel, Offset: b.Offset, Code: CodeMalformed, Message: b.Err.Error()})
+				continue
+			}
+			violations = append(violations, p.CheckSecret(rel, b.Offset, b.Info)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
+
+func (p *Policy) isProtected(path string) bool {
+	for _, re := range p.protected {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyPaths returns the given key paths, relative to the policy's directory,
+// relative to the working directory
+func (p *Policy) keyPaths(paths []string) []string {
+	resolved := []string{}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(p.dir, path)
+		}
+		resolved = append(resolved, path)
+	}
+	return resolved
+}
+
+// loadKeys loads the public keys at the given paths, as resolved by
+// keyPaths. Directories contribute every *.pub file in them.
+func (p *Policy) loadKeys(paths []string) ([]*rsa.PublicKey, error) {
+	pubs := []*rsa.PublicKey{}
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*"+pubKeyExt)); err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("%s: no %s key files in directory", path, pubKeyExt)
+			}
+			sort.Strings(files)
+		}
+		for _, f := range files {
+			raw, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := keys.DecodePubKeyPEM(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", f, err)
+			}
+			pubs = append(pubs, pub)
+		}
+	}
+	return pubs, nil
+}
+
+// fingerprints resolves keys given as fingerprints or paths to fingerprints
+func (p *Policy) fingerprints(refs []string) ([]string, error) {
+	fps := []string{}
+	for _, ref := range refs {
+		if fingerprintRegex.MatchString(ref) {
+			fps = append(fps, ref)
+			continue
+		}
+		pubs, err := p.loadKeys(p.keyPaths([]string{ref}))
+		if err != nil {
+			return nil, err
+		}
+		for _, pub := range pubs {
+			fps = append(fps, keys.GetFingerprint(pub))
+		}
+	}
+	return fps, nil
+}
+
+// globRegexp compiles a path glob to a regular expression
+func globRegexp(glob string) *regexp.Regexp {
+	glob = strings.TrimPrefix(glob, "/")
+	if strings.HasSuffix(glob, "/") {
+		glob += "**"
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(glob, "/") {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,211 @@
+package policy
+
+import (
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `rules:
+  - path: prod/**
+    recipients: [keys/bob.pub, keys/carol.pub]
+    require: 2
+    must_include: [keys/carol.pub]
+  - path: "**"
+    recipients: [keys/]
+protected:
+  - "*.secret"
+  - prod/
+revoked:
+  - keys/revoked/
+`
+
+// testRepo creates a directory holding the test keys under keys/, with
+// alice's key revoked, and the test policy
+func testRepo(t *testing.T) (string, map[string]*rsa.PublicKey) {
+	dir := t.TempDir()
+	pubs := map[string]*rsa.PublicKey{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		raw, err := os.ReadFile(filepath.Join("..", "testdata", name+".pem"))
+		assert.Nil(t, err)
+		priv, err := keys.DecodePrivKeyPEM(raw)
+		assert.Nil(t, err)
+		pubs[name] = &priv.PublicKey
+		keyDir := "keys"
+		if name == "alice" {
+			keyDir = filepath.Join("keys", "revoked")
+		}
+		writeTestFile(t, filepath.Join(dir, keyDir, name+".pub"), keys.EncodePubKeyPEM(&priv.PublicKey))
+	}
+	writeTestFile(t, filepath.Join(dir, DefaultFile), []byte(testPolicy))
+	return dir, pubs
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+}
+
+func encryptTestFile(t *testing.T, path string, require int, pubs ...*rsa.PublicKey) {
+	enc, err := multikey.Encrypt([]byte("secret"), pubs, require)
+	assert.Nil(t, err)
+	writeTestFile(t, path, []byte(enc))
+}
+
+func TestMatch(t *testing.T) {
+	dir, pubs := testRepo(t)
+	p, err := Load(filepath.Join(dir, DefaultFile))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		path       string
+		expRule    string
+		expRequire int
+		expKeys    []*rsa.PublicKey
+	}{
+		{path: "prod/db.secret", expRule: "prod/**", expRequire: 2, expKeys: []*rsa.PublicKey{pubs["bob"], pubs["carol"]}},
+		{path: "./prod/a/b/c", expRule: "prod/**", expRequire: 2, expKeys: []*rsa.PublicKey{pubs["bob"], pubs["carol"]}},
+		{path: "dev/prod/db.secret", expRule: "**", expRequire: 1, expKeys: []*rsa.PublicKey{pubs["bob"], pubs["carol"]}},
+		{path: "app.secret", expRule: "**", expRequire: 1, expKeys: []*rsa.PublicKey{pubs["bob"], pubs["carol"]}},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			r := p.Match(test.path)
+			assert.NotNil(t, r)
+			assert.Equal(t, test.expRule, r.Path)
+			assert.Equal(t, test.expRequire, r.Require)
+			got, err := r.PublicKeys()
+			assert.Nil(t, err)
+			assert.Equal(t, test.expKeys, got)
+		})
+	}
+}
+
+func TestRecipientPaths(t *testing.T) {
+	dir, _ := testRepo(t)
+	p, err := Load(filepath.Join(dir, DefaultFile))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "keys", "bob.pub"), filepath.Join(dir, "keys", "carol.pub")}, p.Match("prod/db.secret").RecipientPaths())
+	assert.Equal(t, []string{filepath.Join(dir, "keys")}, p.Match("app.secret").RecipientPaths())
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob     string
+		matches  []string
+		excludes []string
+	}{
+		{glob: "*.secret", matches: []string{"a.secret", "x/y/a.secret"}, excludes: []string{"a.secrets", "a.secret/b"}},
+		{glob: "prod/*", matches: []string{"prod/a"}, excludes: []string{"prod/a/b", "x/prod/a"}},
+		{glob: "prod/**", matches: []string{"prod/a", "prod/a/b"}, excludes: []string{"production/a"}},
+		{glob: "prod/", matches: []string{"prod/a/b"}, excludes: []string{"prod"}},
+		{glob: "**/*.env", matches: []string{"a.env", "x/y/a.env"}, excludes: []string{"a.env.example"}},
+		{glob: "/config/?.yaml", matches: []string{"config/a.yaml"}, excludes: []string{"config/ab.yaml", "x/config/a.yaml"}},
+	}
+	for _, test := range tests {
+		t.Run(test.glob, func(t *testing.T) {
+			re := globRegexp(test.glob)
+			for _, m := range test.matches {
+				assert.True(t, re.MatchString(m), m)
+			}
+			for _, e := range test.excludes {
+				assert.False(t, re.MatchString(e), e)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, pubs := testRepo(t)
+	alice, bob, carol := pubs["alice"], pubs["bob"], pubs["carol"]
+	encryptTestFile(t, filepath.Join(dir, "prod", "ok.secret"), 2, bob, carol)
+	encryptTestFile(t, filepath.Join(dir, "prod", "weak.secret"), 1, bob, carol)
+	encryptTestFile(t, filepath.Join(dir, "prod", "nokms.secret"), 2, alice, bob)
+	encryptTestFile(t, filepath.Join(dir, "dev", "ok.secret"), 1, bob)
+	writeTestFile(t, filepath.Join(dir, "dev", "plain.secret"), []byte("password=1\n"))
+	writeTestFile(t, filepath.Join(dir, "dev", "README"), []byte("not protected\n"))
+	writeTestFile(t, filepath.Join(dir, ".git", "plain.secret"), []byte("skipped\n"))
+
+	p, err := Load(filepath.Join(dir, DefaultFile))
+	assert.Nil(t, err)
+	violations, err := p.Check()
+	assert.Nil(t, err)
+	codes := []string{}
+	for _, v := range violations {
+		codes = append(codes, v.String())
+	}
+	assert.Equal(t, []string{
+		"dev/plain.secret: unencrypted: protected file is not encrypted",
+		"prod/nokms.secret@0: revoked-key: encrypted for revoked key " + keys.GetFingerprint(alice),
+		"prod/nokms.secret@0: missing-key: not encrypted for required key " + keys.GetFingerprint(carol),
+		"prod/weak.secret@0: threshold: threshold is 1, at least 2 is required",
+	}, codes)
+	assert.Equal(t, "prod/**", violations[2].Rule)
+
+	// legacy secrets do not record their threshold, which is only a
+	// violation of rules requiring more than one key
+	legacy := &multikey.Info{Version: 1, KeyIDs: []string{keys.GetFingerprint(bob), keys.GetFingerprint(carol)}}
+	assert.Empty(t, p.CheckSecret("dev/legacy.secret", 0, legacy))
+	violations = p.CheckSecret("prod/legacy.secret", 0, legacy)
+	assert.Len(t, violations, 1)
+	assert.Equal(t, "threshold is not recorded, 2 is required", violations[0].Message)
+}
+
+func TestParseErrors(t *testing.T) {
+	dir, _ := testRepo(t)
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "unknown field", policy: "rulez: []\n"},
+		{name: "rule without path", policy: "rules:\n  - require: 1\n"},
+		{name: "negative threshold", policy: "rules:\n  - path: a\n    require: -1\n"},
+		{name: "threshold above recipients", policy: "rules:\n  - path: a\n    recipients: [keys/bob.pub]\n    require: 2\n"},
+		{name: "missing key file", policy: "rules:\n  - path: a\n    recipients: [keys/nobody.pub]\n"},
+		{name: "bad revoked key", policy: "revoked: [keys/nobody.pub]\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.policy), dir)
+			assert.NotNil(t, err)
+		})
+	}
+
+	p, err := Parse(nil, dir)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Match("a"))
+}
+
+func TestLoadRelative(t *testing.T) {
+	dir, pubs := testRepo(t)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(filepath.Dir(dir)))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// key paths are relative to the policy's directory, and to the
+	// working directory once resolved
+	p, err := Load(filepath.Join(filepath.Base(dir), DefaultFile))
+	assert.Nil(t, err)
+	got, err := p.Match("prod/db.secret").PublicKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, []*rsa.PublicKey{pubs["bob"], pubs["carol"]}, got)
+	assert.Equal(t, []string{filepath.Join(filepath.Base(dir), "keys")}, p.Match("app.secret").RecipientPaths())
+}
+
+func TestFind(t *testing.T) {
+	dir, _ := testRepo(t)
+	sub := filepath.Join(dir, "a", "b")
+	assert.Nil(t, os.MkdirAll(sub, 0755))
+	found, err := Find(sub)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, DefaultFile), found)
+
+	_, err = Find(t.TempDir())
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,126 @@
+package quorum
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// audit events
+const (
+	EventSecretAdded      = "secret_added"
+	EventRequestOpened    = "request_opened"
+	EventApprovalAdded    = "approval_added"
+	EventApprovalRejected = "approval_rejected"
+	EventReleaseDenied    = "release_denied"
+	EventSecretReleased   = "secret_released"
+)
+
+// auditGenesisPrevHash is the previous hash of the first entry of a log
+const auditGenesisPrevHash = ""
+
+// AuditEntry is a single record of the audit log. Each entry carries the
+// hash of the previous entry, so that removing, reordering or editing any
+// entry breaks the chain (see VerifyAuditLog).
+type AuditEntry struct {
+	Seq       int       `json:"seq"`
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Secret    string    `json:"secret,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// AuditLog is an append-only, hash chained log of JSON lines
+type AuditLog struct {
+	mu       sync.Mutex
+	w        io.Writer
+	seq      int
+	prevHash string
+}
+
+// NewAuditLog returns an audit log which appends entries to the given writer
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w, prevHash: auditGenesisPrevHash}
+}
+
+// ResumeAuditLog verifies the existing entries of an audit log read from
+// r, and returns an audit log which appends entries following them to w,
+// e.g. a log file opened for reading and appending, so that the hash
+// chain continues across server restarts
+func ResumeAuditLog(r io.Reader, w io.Writer) (*AuditLog, error) {
+	entries, err := VerifyAuditLog(r)
+	if err != nil {
+		return nil, err
+	}
+	l := NewAuditLog(w)
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		l.seq, l.prevHash = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// Append writes an entry to the log, filling in its sequence number and hashes
+func (l *AuditLog) Append(e AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	e.Seq = l.seq
+	e.Time = e.Time.UTC()
+	e.PrevHash = l.prevHash
+	e.Hash = e.computeHash()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode audit entry: %s", err)
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write audit entry: %s", err)
+	}
+	l.prevHash = e.Hash
+	return nil
+}
+
+// VerifyAuditLog reads an audit log and verifies its hash chain,
+// returning the entries if the log is intact
+func VerifyAuditLog(r io.Reader) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	prevHash := auditGenesisPrevHash
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("entry %d: could not decode: %s", len(entries)+1, err)
+		}
+		if e.Seq != len(entries)+1 {
+			return nil, fmt.Errorf("entry %d: out of sequence", len(entries)+1)
+		}
+		if e.PrevHash != prevHash || e.Hash != e.computeHash() {
+			return nil, fmt.Errorf("entry %d: hash chain is broken", e.Seq)
+		}
+		prevHash = e.Hash
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read audit log: %s", err)
+	}
+	return entries, nil
+}
+
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e) // only contains plain types, can not fail
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
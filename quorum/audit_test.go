@@ -0,0 +1,56 @@
+package quorum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := NewAuditLog(buf)
+	for _, event := range []string{EventSecretAdded, EventRequestOpened, EventApprovalAdded} {
+		assert.Nil(t, log.Append(AuditEntry{Time: time.Now(), Event: event, Actor: "alice"}))
+	}
+
+	entries, err := VerifyAuditLog(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+
+	// removing an entry breaks the chain
+	_, err = VerifyAuditLog(strings.NewReader(lines[0] + lines[2]))
+	assert.NotNil(t, err)
+
+	// so does editing one
+	_, err = VerifyAuditLog(strings.NewReader(strings.Replace(buf.String(), "alice", "mallory", 1)))
+	assert.NotNil(t, err)
+}
+
+func TestResumeAuditLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := NewAuditLog(buf)
+	assert.Nil(t, log.Append(AuditEntry{Time: time.Now(), Event: EventSecretAdded}))
+	assert.Nil(t, log.Append(AuditEntry{Time: time.Now(), Event: EventRequestOpened}))
+
+	// a restarted server continues the chain of the existing entries
+	resumed, err := ResumeAuditLog(bytes.NewReader(buf.Bytes()), buf)
+	assert.Nil(t, err)
+	assert.Nil(t, resumed.Append(AuditEntry{Time: time.Now(), Event: EventApprovalAdded}))
+	entries, err := VerifyAuditLog(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, 3, entries[2].Seq)
+
+	resumed, err = ResumeAuditLog(strings.NewReader(""), &bytes.Buffer{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, resumed.seq)
+
+	_, err = ResumeAuditLog(strings.NewReader(strings.Replace(buf.String(), EventRequestOpened, EventSecretReleased, 1)), buf)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,421 @@
+// Package quorum implements a small HTTP service for break-glass access
+// to multikey encrypted secrets.
+//
+// A requester opens an access request for a registered secret with a
+// justification. Key holders approve the request by posting contributions
+// (see multikey.Contribute) wrapped for the server's combiner key. Once the
+// threshold recorded for the secret is met, the plaintext is released to
+// the requester, and only to the requester. Every step is recorded in an
+// append-only audit log.
+//
+// Endpoints:
+//
+//	GET  /v1/combiner                      combiner public key (PEM)
+//	GET  /v1/secrets/{name}                encrypted secret (PEM)
+//	POST /v1/requests                      open a request
+//	GET  /v1/requests/{id}                 request status
+//	POST /v1/requests/{id}/approvals       post a contribution (PEM)
+//	POST /v1/requests/{id}/release         release the plaintext (bearer token)
+package quorum
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+)
+
+const (
+	maxBodyBytes = 64 * 1024
+
+	errMsgUnknownSecret      = "unknown secret"
+	errMsgUnknownRequest     = "unknown request"
+	errMsgInvalidThreshold   = "threshold must be at least 1"
+	errMsgSecretExists       = "secret already exists"
+	errMsgMissingFields      = "secret, requester and justification are required"
+	errMsgAlreadyApproved    = "key holder has already approved this request"
+	errMsgAlreadyReleased    = "secret has already been released for this request"
+	errMsgThresholdNotMet    = "approval threshold has not been met"
+	errMsgInvalidToken       = "invalid request token"
+	errMsgMethodNotAllowed   = "method not allowed"
+	errMsgCouldNotReadBody   = "could not read request body"
+	errMsgCouldNotDecodeBody = "could not decode request body"
+)
+
+// Server is the quorum approval HTTP service. It implements http.Handler.
+type Server struct {
+	combiner *rsa.PrivateKey
+	audit    *AuditLog
+	now      func() time.Time
+
+	mu       sync.Mutex
+	secrets  map[string]*registeredSecret
+	requests map[string]*request
+}
+
+type registeredSecret struct {
+	enc       string
+	threshold int
+}
+
+type request struct {
+	Request
+	tokenHash     [sha256.Size]byte
+	contributions []string
+}
+
+// Request is the public status of an access request
+type Request struct {
+	ID            string    `json:"id"`
+	Secret        string    `json:"secret"`
+	Requester     string    `json:"requester"`
+	Justification string    `json:"justification"`
+	Threshold     int       `json:"threshold"`
+	Approvals     []string  `json:"approvals"`
+	Released      bool      `json:"released"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// OpenRequest is the body of a request to open an access request
+type OpenRequest struct {
+	Secret        string `json:"secret"`
+	Requester     string `json:"requester"`
+	Justification string `json:"justification"`
+}
+
+// OpenResponse is returned when an access request is opened. The token
+// must be presented to release the secret, and is never shown again.
+type OpenResponse struct {
+	Request
+	Token string `json:"token"`
+}
+
+// NewServer returns a quorum server which combines contributions with the
+// given combiner key, and appends its audit log to the given writer.
+func NewServer(combiner *rsa.PrivateKey, audit io.Writer) *Server {
+	return NewServerWithAuditLog(combiner, NewAuditLog(audit))
+}
+
+// NewServerWithAuditLog is like NewServer, appending to the given audit
+// log, such as one continued with ResumeAuditLog.
+func NewServerWithAuditLog(combiner *rsa.PrivateKey, audit *AuditLog) *Server {
+	return &Server{
+		combiner: combiner,
+		audit:    audit,
+		now:      time.Now,
+		secrets:  map[string]*registeredSecret{},
+		requests: map[string]*request{},
+	}
+}
+
+// AddSecret registers an encrypted secret under a name. The amount of key
+// holder approvals required to release it is the threshold the secret
+// records, or the given threshold for legacy secrets which do not.
+func (s *Server) AddSecret(name, enc string, threshold int) error {
+	info, err := multikey.Inspect(enc)
+	if err != nil {
+		return err
+	}
+	if info.Threshold > 0 {
+		threshold = info.Threshold
+	}
+	if threshold < 1 {
+		return errors.New(errMsgInvalidThreshold)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[name]; ok {
+		return errors.New(errMsgSecretExists)
+	}
+	s.secrets[name] = &registeredSecret{enc: enc, threshold: threshold}
+	return s.audit.Append(AuditEntry{
+		Time:   s.now(),
+		Event:  EventSecretAdded,
+		Secret: name,
+		Detail: fmt.Sprintf("threshold %d", threshold),
+	})
+}
+
+// ServeHTTP routes requests to the server's endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "v1/combiner":
+		s.route(w, r, http.MethodGet, s.handleGetCombiner)
+	case len(parts) == 3 && parts[0] == "v1" && parts[1] == "secrets":
+		s.route(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			s.handleGetSecret(w, r, parts[2])
+		})
+	case path == "v1/requests":
+		s.route(w, r, http.MethodPost, s.handleOpenRequest)
+	case len(parts) == 3 && parts[0] == "v1" && parts[1] == "requests":
+		s.route(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			s.handleGetRequest(w, r, parts[2])
+		})
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "requests" && parts[3] == "approvals":
+		s.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			s.handleApprove(w, r, parts[2])
+		})
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "requests" && parts[3] == "release":
+		s.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			s.handleRelease(w, r, parts[2])
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, method string, h http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errMsgMethodNotAllowed)
+		return
+	}
+	h(w, r)
+}
+
+func (s *Server) handleGetCombiner(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(keys.EncodePubKeyPEM(&s.combiner.PublicKey))
+}
+
+func (s *Server) handleGetSecret(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	sec, ok := s.secrets[name]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errMsgUnknownSecret)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	io.WriteString(w, sec.enc)
+}
+
+func (s *Server) handleOpenRequest(w http.ResponseWriter, r *http.Request) {
+	var body OpenRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodyBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errMsgCouldNotDecodeBody)
+		return
+	}
+	if body.Secret == "" || body.Requester == "" || body.Justification == "" {
+		writeError(w, http.StatusBadRequest, errMsgMissingFields)
+		return
+	}
+	id, token, err := newRequestCredentials()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, ok := s.secrets[body.Secret]
+	if !ok {
+		writeError(w, http.StatusNotFound, errMsgUnknownSecret)
+		return
+	}
+	req := &request{
+		Request: Request{
+			ID:            id,
+			Secret:        body.Secret,
+			Requester:     body.Requester,
+			Justification: body.Justification,
+			Threshold:     sec.threshold,
+			Approvals:     []string{},
+			CreatedAt:     s.now().UTC(),
+		},
+		tokenHash: sha256.Sum256([]byte(token)),
+	}
+	if err := s.audit.Append(AuditEntry{
+		Time:      s.now(),
+		Event:     EventRequestOpened,
+		Secret:    body.Secret,
+		RequestID: id,
+		Actor:     body.Requester,
+		Detail:    body.Justification,
+	}); err != nil {
+		// nothing may happen without leaving a trace
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.requests[id] = req
+	writeJSON(w, http.StatusCreated, &OpenResponse{Request: req.status(), Token: token})
+}
+
+func (s *Server) handleGetRequest(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, errMsgUnknownRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, req.status())
+}
+
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request, id string) {
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errMsgCouldNotReadBody)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, errMsgUnknownRequest)
+		return
+	}
+	reject := func(status int, actor, msg string) {
+		if err := s.audit.Append(AuditEntry{
+			Time:      s.now(),
+			Event:     EventApprovalRejected,
+			Secret:    req.Secret,
+			RequestID: id,
+			Actor:     actor,
+			Detail:    msg,
+		}); err != nil {
+			msg = err.Error()
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, msg)
+	}
+	if req.Released {
+		reject(http.StatusConflict, "", errMsgAlreadyReleased)
+		return
+	}
+	c, err := multikey.DecodeContribution(string(raw))
+	if err != nil {
+		reject(http.StatusBadRequest, "", err.Error())
+		return
+	}
+	holder := c.HolderID()
+	combinerID := keys.GetFingerprint(&s.combiner.PublicKey)
+	if err := c.Check(s.secrets[req.Secret].enc, id, combinerID, s.now()); err != nil {
+		reject(http.StatusBadRequest, holder, err.Error())
+		return
+	}
+	for _, approver := range req.Approvals {
+		if approver == holder {
+			reject(http.StatusConflict, holder, errMsgAlreadyApproved)
+			return
+		}
+	}
+	if err := s.audit.Append(AuditEntry{
+		Time:      s.now(),
+		Event:     EventApprovalAdded,
+		Secret:    req.Secret,
+		RequestID: id,
+		Actor:     holder,
+		Detail:    fmt.Sprintf("%d of %d approvals", len(req.Approvals)+1, req.Threshold),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req.Approvals = append(req.Approvals, holder)
+	req.contributions = append(req.contributions, string(raw))
+	writeJSON(w, http.StatusOK, req.status())
+}
+
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request, id string) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, errMsgUnknownRequest)
+		return
+	}
+	deny := func(status int, msg string) {
+		if err := s.audit.Append(AuditEntry{
+			Time:      s.now(),
+			Event:     EventReleaseDenied,
+			Secret:    req.Secret,
+			RequestID: id,
+			Detail:    msg,
+		}); err != nil {
+			msg = err.Error()
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, msg)
+	}
+	tokenHash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(tokenHash[:], req.tokenHash[:]) != 1 {
+		deny(http.StatusForbidden, errMsgInvalidToken)
+		return
+	}
+	if req.Released {
+		deny(http.StatusGone, errMsgAlreadyReleased)
+		return
+	}
+	if len(req.Approvals) < req.Threshold {
+		deny(http.StatusForbidden, errMsgThresholdNotMet)
+		return
+	}
+	plain, err := multikey.CombineContributions(s.secrets[req.Secret].enc, req.contributions, s.combiner, id)
+	if err != nil {
+		// contributions may have expired since they were posted
+		deny(http.StatusConflict, err.Error())
+		return
+	}
+	if err := s.audit.Append(AuditEntry{
+		Time:      s.now(),
+		Event:     EventSecretReleased,
+		Secret:    req.Secret,
+		RequestID: id,
+		Actor:     req.Requester,
+		Detail:    strings.Join(req.Approvals, ","),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req.Released = true
+	req.contributions = nil
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(plain)
+}
+
+func (r *request) status() Request {
+	status := r.Request
+	status.Approvals = append([]string{}, r.Approvals...)
+	return status
+}
+
+func newRequestCredentials() (string, string, error) {
+	id := make([]byte, 16)
+	token := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", fmt.Errorf("could not generate request id: %s", err)
+	}
+	if _, err := rand.Read(token); err != nil {
+		return "", "", fmt.Errorf("could not generate request token: %s", err)
+	}
+	return hex.EncodeToString(id), base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,206 @@
+package quorum
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEnv struct {
+	srv     *httptest.Server
+	audit   *bytes.Buffer
+	holders []*rsa.PrivateKey
+	enc     string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	combiner, _, err := keys.GenerateRSAKeyPair(2048)
+	if err != nil {
+		assert.FailNow(t, "could not generate combiner key")
+	}
+	holders, pubs := []*rsa.PrivateKey{}, []*rsa.PublicKey{}
+	for i := 0; i < 3; i++ {
+		priv, pub, err := keys.GenerateRSAKeyPair(2048)
+		if err != nil {
+			assert.FailNow(t, "could not generate holder key")
+		}
+		holders = append(holders, priv)
+		pubs = append(pubs, pub)
+	}
+	enc, err := multikey.Encrypt([]byte("root password"), pubs, 2)
+	assert.Nil(t, err)
+
+	audit := &bytes.Buffer{}
+	s := NewServer(combiner, audit)
+	assert.Nil(t, s.AddSecret("db-root", enc, 2))
+
+	env := &testEnv{srv: httptest.NewServer(s), audit: audit, holders: holders, enc: enc}
+	t.Cleanup(env.srv.Close)
+	return env
+}
+
+func (env *testEnv) do(t *testing.T, method, path, token string, body io.Reader) (int, []byte) {
+	req, err := http.NewRequest(method, env.srv.URL+path, body)
+	assert.Nil(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp.StatusCode, b
+}
+
+func (env *testEnv) open(t *testing.T) OpenResponse {
+	body := `{"secret":"db-root","requester":"oncall","justification":"INC-1234 primary is down"}`
+	status, b := env.do(t, http.MethodPost, "/v1/requests", "", strings.NewReader(body))
+	assert.Equal(t, http.StatusCreated, status)
+	var opened OpenResponse
+	assert.Nil(t, json.Unmarshal(b, &opened))
+	return opened
+}
+
+func (env *testEnv) approve(t *testing.T, holder *rsa.PrivateKey, requestID string, expiresAt time.Time) (int, []byte) {
+	_, combinerPEM := env.do(t, http.MethodGet, "/v1/combiner", "", nil)
+	combiner, err := keys.DecodePubKeyPEM(combinerPEM)
+	assert.Nil(t, err)
+	_, enc := env.do(t, http.MethodGet, "/v1/secrets/db-root", "", nil)
+	c, err := multikey.Contribute(string(enc), holder, combiner, requestID, expiresAt)
+	assert.Nil(t, err)
+	return env.do(t, http.MethodPost, "/v1/requests/"+requestID+"/approvals", "", strings.NewReader(c))
+}
+
+func TestQuorumFlow(t *testing.T) {
+	env := newTestEnv(t)
+	opened := env.open(t)
+	assert.Equal(t, 2, opened.Threshold)
+	assert.NotEmpty(t, opened.Token)
+	expiry := time.Now().Add(time.Hour)
+
+	// not released before the threshold is met
+	status, _ := env.do(t, http.MethodPost, "/v1/requests/"+opened.ID+"/release", opened.Token, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = env.approve(t, env.holders[0], opened.ID, expiry)
+	assert.Equal(t, http.StatusOK, status)
+
+	// the same holder can not approve twice
+	status, _ = env.approve(t, env.holders[0], opened.ID, expiry)
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, b := env.approve(t, env.holders[2], opened.ID, expiry)
+	assert.Equal(t, http.StatusOK, status)
+	var req Request
+	assert.Nil(t, json.Unmarshal(b, &req))
+	assert.Len(t, req.Approvals, 2)
+
+	// only the requester's token releases the secret
+	status, _ = env.do(t, http.MethodPost, "/v1/requests/"+opened.ID+"/release", "not-the-token", nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, b = env.do(t, http.MethodPost, "/v1/requests/"+opened.ID+"/release", opened.Token, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "root password", string(b))
+
+	// and only once
+	status, _ = env.do(t, http.MethodPost, "/v1/requests/"+opened.ID+"/release", opened.Token, nil)
+	assert.Equal(t, http.StatusGone, status)
+
+	status, b = env.do(t, http.MethodGet, "/v1/requests/"+opened.ID, "", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, json.Unmarshal(b, &req))
+	assert.True(t, req.Released)
+
+	entries, err := VerifyAuditLog(bytes.NewReader(env.audit.Bytes()))
+	assert.Nil(t, err)
+	events := []string{}
+	for _, e := range entries {
+		events = append(events, e.Event)
+	}
+	assert.Equal(t, []string{
+		EventSecretAdded,
+		EventRequestOpened,
+		EventReleaseDenied,
+		EventApprovalAdded,
+		EventApprovalRejected,
+		EventApprovalAdded,
+		EventReleaseDenied,
+		EventSecretReleased,
+		EventReleaseDenied,
+	}, events)
+	assert.NotContains(t, env.audit.String(), "root password")
+}
+
+func TestQuorumRejectsBadApprovals(t *testing.T) {
+	env := newTestEnv(t)
+	opened := env.open(t)
+	other := env.open(t)
+
+	// a contribution for another request
+	status, _ := env.approve(t, env.holders[0], other.ID, time.Now().Add(time.Hour))
+	assert.Equal(t, http.StatusOK, status)
+	_, combinerPEM := env.do(t, http.MethodGet, "/v1/combiner", "", nil)
+	combiner, err := keys.DecodePubKeyPEM(combinerPEM)
+	assert.Nil(t, err)
+	c, err := multikey.Contribute(env.enc, env.holders[1], combiner, other.ID, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	status, _ = env.do(t, http.MethodPost, "/v1/requests/"+opened.ID+"/approvals", "", strings.NewReader(c))
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// an expired contribution
+	status, _ = env.approve(t, env.holders[1], opened.ID, time.Now().Add(-time.Minute))
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// garbage
+	status, _ = env.do(t, http.MethodPost, "/v1/requests/"+opened.ID+"/approvals", "", strings.NewReader("garbage"))
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestQuorumRouting(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, _ := env.do(t, http.MethodGet, "/v1/secrets/unknown", "", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = env.do(t, http.MethodGet, "/v1/requests/unknown", "", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = env.do(t, http.MethodGet, "/v1/requests", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+	status, _ = env.do(t, http.MethodGet, "/nope", "", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = env.do(t, http.MethodPost, "/v1/requests", "", strings.NewReader(`{"secret":"db-root"}`))
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = env.do(t, http.MethodPost, "/v1/requests", "", strings.NewReader(`{"secret":"unknown","requester":"a","justification":"b"}`))
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestAddSecretThreshold(t *testing.T) {
+	env := newTestEnv(t)
+	s := NewServer(env.holders[0], io.Discard)
+
+	// the threshold recorded by the secret is used over the one given
+	assert.Nil(t, s.AddSecret("recorded", env.enc, 1))
+	assert.Equal(t, 2, s.secrets["recorded"].threshold)
+
+	// which is only needed for legacy secrets
+	legacy, err := os.ReadFile(filepath.Join("..", "testdata", "legacy.secret"))
+	assert.Nil(t, err)
+	assert.EqualError(t, s.AddSecret("legacy", string(legacy), 0), errMsgInvalidThreshold)
+	assert.Nil(t, s.AddSecret("legacy", string(legacy), 2))
+	assert.Equal(t, 2, s.secrets["legacy"].threshold)
+
+	assert.Equal(t, multikey.ErrMalformedSecret, s.AddSecret("malformed", "garbage", 1))
+}
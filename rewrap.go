@@ -0,0 +1,104 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/adrianosela/multikey/shamir"
+)
+
+// Rewrap re-encrypts a secret for a new set of recipients and threshold
+// without changing its plaintext. Its plaintext is sealed under a new
+// data key which is split between the new recipients, so that the shards
+// held by removed recipients, e.g. in version control history, can not
+// decrypt the rewrapped secret, nor any later update of it. Enough of the
+// secret's keys to decrypt it must be given.
+// The rewrapped secret is bound to the context of the given options, which
+// must also be the one the secret was bound to, if any, and keeps its
+// metadata unless the given options have their own.
+// Rewrapped secrets are signed by the signer of the given options, if any,
+// and are otherwise unsigned.
+//
+// Legacy secrets, which do not record their threshold, are only rewrapped
+// if the given keys decrypt at least as many of their shards as the new
+// threshold, lest too few of them decrypt to garbage.
+//
+// Recipients removed from a secret may still have kept its plaintext;
+// change the secret itself if that matters.
+func Rewrap(enc string, privs []*rsa.PrivateKey, pubs []*rsa.PublicKey, require int) (string, error) {
+	return RewrapWithOptions(enc, privs, pubs, require, nil)
+}
+
+// RewrapWithOptions is like Rewrap but allows for optional
+// behaviour to be configured through the given options.
+func RewrapWithOptions(enc string, privs []*rsa.PrivateKey, pubs []*rsa.PublicKey, require int, opts *EncryptOptions) (string, error) {
+	if require > len(pubs) {
+		return "", errors.New(errMsgRequireTooBig)
+	}
+	s, err := decodePEM(enc)
+	if err != nil {
+		return "", ErrMalformedSecret
+	}
+	if err := opts.metadata().validate(); err != nil {
+		return "", err
+	}
+	opts = opts.keepMetadata(s.metadata)
+	parts := s.decryptShards(privs)
+	if s.version < currentVersion {
+		// legacy secrets do not record their threshold, and combining fewer
+		// of their shards than it yields garbage rather than an error, so
+		// at least as many shards as the new threshold must be decrypted
+		if len(parts) < require {
+			return "", ErrInsufficientKeys
+		}
+		data, err := Decrypt(enc, privs)
+		if err != nil {
+			return "", err
+		}
+		return EncryptWithOptions(data, pubs, require, opts)
+	}
+
+	s.context = opts.context()
+	if len(parts) < s.threshold {
+		return "", ErrInsufficientKeys
+	}
+	dataKey, err := shamir.Combine(parts)
+	if err != nil {
+		return "", err
+	}
+	plain, err := s.openPayload(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	// the old data key is known to the removed recipients
+	if dataKey, err = newDataKey(opts.rand()); err != nil {
+		return "", err
+	}
+	parts, err = shamir.SplitWithOptions(dataKey, len(pubs), require, &shamir.Options{Rand: opts.rand()})
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", errMsgCouldNotSplitKey, err)
+	}
+	rewrapped, err := encryptParts(parts, pubs, opts)
+	if err != nil {
+		return "", err
+	}
+	for i, part := range parts {
+		rewrapped.shards[i].X = int(part[len(part)-1])
+	}
+	rewrapped.sortShards()
+	rewrapped.version = currentVersion
+	rewrapped.threshold = require
+	rewrapped.bind(opts.context())
+	if !opts.metadata().empty() {
+		rewrapped.metadata = opts.metadata()
+	}
+	if rewrapped.payload, err = sealPayload(dataKey, plain, rewrapped.associatedData(), opts.rand()); err != nil {
+		return "", err
+	}
+	if err := opts.sign(rewrapped); err != nil {
+		return "", err
+	}
+	return rewrapped.encodePEM()
+}
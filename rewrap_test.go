@@ -0,0 +1,74 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewrap(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	alice, bob, carol := privs[0], privs[1], privs[2]
+	enc, err := Encrypt([]byte("secret"), pubs[:2], 2)
+	assert.Nil(t, err)
+	before, err := decodePEM(enc)
+	assert.Nil(t, err)
+	oldKey := recoverTestDataKey(t, before, alice, bob)
+
+	tests := []struct {
+		name        string
+		pubs        []int
+		require     int
+		expOpenWith []int
+		expShutOut  []int
+	}{
+		{name: "replace a recipient", pubs: []int{1, 2}, require: 2, expOpenWith: []int{1, 2}, expShutOut: []int{0, 1}},
+		{name: "lower the threshold", pubs: []int{0, 1, 2}, require: 1, expOpenWith: []int{2}},
+		{name: "raise the threshold", pubs: []int{0, 1, 2}, require: 3, expOpenWith: []int{0, 1, 2}, expShutOut: []int{0, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newPubs := []*rsa.PublicKey{}
+			for _, i := range test.pubs {
+				newPubs = append(newPubs, pubs[i])
+			}
+			rewrapped, err := Rewrap(enc, privs[:2], newPubs, test.require)
+			assert.Nil(t, err)
+			info, err := Inspect(rewrapped)
+			assert.Nil(t, err)
+			assert.Equal(t, test.require, info.Threshold)
+			assert.Len(t, info.KeyIDs, len(test.pubs))
+
+			with := []*rsa.PrivateKey{}
+			for _, i := range test.expOpenWith {
+				with = append(with, privs[i])
+			}
+			plain, err := Decrypt(rewrapped, with)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("secret"), plain)
+			if test.expShutOut != nil {
+				without := []*rsa.PrivateKey{}
+				for _, i := range test.expShutOut {
+					without = append(without, privs[i])
+				}
+				_, err = Decrypt(rewrapped, without)
+				assert.Equal(t, ErrInsufficientKeys, err)
+			}
+
+			// the payload is sealed under a new data key, which the
+			// shards of the secret before it was rewrapped do not recover
+			after, err := decodePEM(rewrapped)
+			assert.Nil(t, err)
+			_, err = after.openPayload(oldKey)
+			assert.EqualError(t, err, errMsgCouldNotOpenPayload)
+		})
+	}
+
+	_, err = Rewrap(enc, []*rsa.PrivateKey{alice}, pubs, 2)
+	assert.Equal(t, ErrInsufficientKeys, err)
+	_, err = Rewrap(enc, []*rsa.PrivateKey{alice, bob}, pubs[2:], 2)
+	assert.NotNil(t, err)
+	_, err = Rewrap("not a secret", []*rsa.PrivateKey{carol}, pubs, 1)
+	assert.Equal(t, ErrMalformedSecret, err)
+}
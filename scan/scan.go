@@ -0,0 +1,250 @@
+// Package scan finds multikey encrypted secrets in files, including
+// secrets embedded within other files such as the data keys of encrypted
+// dotenv and structured files, and reports who can decrypt them.
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adrianosela/multikey"
+)
+
+const (
+	beginMarker = "-----BEGIN MULTIKEY ENCRYPTED SECRET-----"
+	endMarker   = "-----END MULTIKEY ENCRYPTED SECRET-----"
+
+	errMsgUnterminated = "secret has no end marker"
+)
+
+var (
+	errUnterminated = errors.New(errMsgUnterminated)
+
+	// skippedDirs are not descended into by Dir
+	skippedDirs = map[string]bool{".git": true}
+)
+
+// Block is an encrypted secret found within a file
+type Block struct {
+	// Offset is the byte offset of the start of the secret in the file
+	Offset int
+
+	// Length is the length in bytes of the secret in the file, from the
+	// start of its begin marker to the end of its end marker
+	Length int
+
+	// Info describes the secret, nil if it could not be decoded
+	Info *multikey.Info
+
+	// Err is why the secret could not be decoded
+	Err error
+}
+
+// Secret is an encrypted secret found within a directory
+type Secret struct {
+	// Path is the slash separated path of the file, relative to the directory
+	Path string
+	Block
+}
+
+// Blocks finds the encrypted secrets in data. Secrets may be indented or
+// prefixed as in YAML block scalars or comments, in which case the prefix
+// of their first line is removed from every line, or be a single line
+// with escaped newlines as in JSON strings.
+func Blocks(data []byte) []Block {
+	blocks := []Block{}
+	for pos := 0; ; {
+		i := bytes.Index(data[pos:], []byte(beginMarker))
+		if i < 0 {
+			return blocks
+		}
+		start := pos + i
+		j := bytes.Index(data[start:], []byte(endMarker))
+		if j < 0 {
+			return append(blocks, Block{Offset: start, Length: len(data) - start, Err: errUnterminated})
+		}
+		end := start + j + len(endMarker)
+		lineStart := bytes.LastIndexByte(data[:start], '\n') + 1
+		block := Block{Offset: start, Length: end - start}
+		block.Info, block.Err = multikey.Inspect(extract(string(data[start:end]), string(data[lineStart:start])))
+		blocks = append(blocks, block)
+		pos = end
+	}
+}
+
+// extract returns the PEM block of a secret found in a file, given the
+// text preceding it on its first line
+func extract(raw, prefix string) string {
+	if !strings.Contains(raw, "\n") {
+		raw = strings.ReplaceAll(strings.ReplaceAll(raw, `\r\n`, "\n"), `\n`, "\n")
+	}
+	lines := strings.Split(raw, "\n")
+	for i, l := range lines {
+		l = strings.TrimRight(l, "\r")
+		if i > 0 {
+			if prefix != "" && strings.HasPrefix(l, prefix) {
+				l = l[len(prefix):]
+			}
+			l = strings.TrimSpace(l)
+		}
+		lines[i] = l
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Dir finds the encrypted secrets in every file within a directory,
+// other than within .git directories. Secrets are returned ordered by
+// path and offset.
+func Dir(root string) ([]Secret, error) {
+	secrets := []Secret{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if skippedDirs[d.Name()] && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		for _, b := range Blocks(data) {
+			secrets = append(secrets, Secret{Path: filepath.ToSlash(rel), Block: b})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// Report is a description of who can decrypt a set of secrets
+type Report struct {
+	Secrets []SecretAccess  `json:"secrets"`
+	Keys    []KeyAccess     `json:"keys"`
+	Revoked []RevokedAccess `json:"revoked,omitempty"`
+}
+
+// SecretAccess describes the keys of a secret
+type SecretAccess struct {
+	Location
+	Version   int    `json:"version,omitempty"`
+	Threshold int    `json:"threshold"`
+	Keys      []Key  `json:"keys"`
+	Error     string `json:"error,omitempty"`
+}
+
+// KeyAccess describes the secrets a key can help decrypt
+type KeyAccess struct {
+	Key
+	Secrets []Location `json:"secrets"`
+}
+
+// RevokedAccess describes a secret which a quorum including revoked keys
+// can decrypt
+type RevokedAccess struct {
+	Location
+	Threshold int   `json:"threshold"`
+	Keys      []Key `json:"revoked_keys"`
+
+	// Exposed is set when the revoked keys alone are a quorum
+	Exposed bool `json:"exposed"`
+}
+
+// Location is where a secret was found
+type Location struct {
+	Path   string `json:"file"`
+	Offset int    `json:"offset"`
+}
+
+// Key identifies a key by its fingerprint, and its name if known
+type Key struct {
+	Fingerprint string `json:"fingerprint"`
+	Name        string `json:"name,omitempty"`
+}
+
+// NewReport describes who can decrypt the given secrets, naming keys by
+// their fingerprint in names where present. Keys are ordered by name,
+// followed by unnamed keys ordered by fingerprint.
+func NewReport(secrets []Secret, names map[string]string) *Report {
+	r := &Report{Secrets: []SecretAccess{}, Keys: []KeyAccess{}}
+	byKey := map[string]*KeyAccess{}
+	for _, s := range secrets {
+		loc := Location{Path: s.Path, Offset: s.Offset}
+		access := SecretAccess{Location: loc, Keys: []Key{}}
+		if s.Err != nil {
+			access.Error = s.Err.Error()
+			r.Secrets = append(r.Secrets, access)
+			continue
+		}
+		access.Version, access.Threshold = s.Info.Version, s.Info.Threshold
+		for _, id := range s.Info.KeyIDs {
+			key := Key{Fingerprint: id, Name: names[id]}
+			access.Keys = append(access.Keys, key)
+			if byKey[id] == nil {
+				byKey[id] = &KeyAccess{Key: key, Secrets: []Location{}}
+			}
+			byKey[id].Secrets = append(byKey[id].Secrets, loc)
+		}
+		r.Secrets = append(r.Secrets, access)
+	}
+	for _, k := range byKey {
+		r.Keys = append(r.Keys, *k)
+	}
+	sort.Slice(r.Keys, func(i, j int) bool {
+		a, b := r.Keys[i], r.Keys[j]
+		if (a.Name == "") != (b.Name == "") {
+			return a.Name != "" // named keys first
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+	return r
+}
+
+// FindRevoked lists the secrets of the report which can still be
+// decrypted by a quorum including any of the given revoked keys, by
+// fingerprint. Secrets whose threshold is not recorded are assumed to
+// need a single key.
+func (r *Report) FindRevoked(revoked map[string]bool) {
+	r.Revoked = nil
+	for _, s := range r.Secrets {
+		access := RevokedAccess{Location: s.Location, Threshold: s.Threshold, Keys: []Key{}}
+		for _, k := range s.Keys {
+			if revoked[k.Fingerprint] {
+				access.Keys = append(access.Keys, k)
+			}
+		}
+		if len(access.Keys) == 0 {
+			continue
+		}
+		access.Exposed = len(access.Keys) >= s.Threshold
+		r.Revoked = append(r.Revoked, access)
+	}
+}
+
+// String returns the name of a key, or its fingerprint if it has none
+func (k Key) String() string {
+	if k.Name != "" {
+		return k.Name
+	}
+	return k.Fingerprint
+}
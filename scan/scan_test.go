@@ -0,0 +1,127 @@
+package scan
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/dotenv"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// loadTestKeys loads the named public keys from the repository's testdata
+func loadTestKeys(t *testing.T, names ...string) []*rsa.PublicKey {
+	pubs := []*rsa.PublicKey{}
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join("..", "testdata", name+".pem"))
+		assert.Nil(t, err)
+		priv, err := keys.DecodePrivKeyPEM(raw)
+		assert.Nil(t, err)
+		pubs = append(pubs, &priv.PublicKey)
+	}
+	return pubs
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+}
+
+func TestBlocks(t *testing.T) {
+	pubs := loadTestKeys(t, "alice", "bob")
+	enc, err := multikey.Encrypt([]byte("secret"), pubs, 2)
+	assert.Nil(t, err)
+	quoted, err := json.Marshal(enc)
+	assert.Nil(t, err)
+	indented := "    " + strings.ReplaceAll(strings.TrimSuffix(enc, "\n"), "\n", "\n    ")
+	commented := "# " + strings.ReplaceAll(strings.TrimSuffix(enc, "\n"), "\n", "\n# ")
+
+	tests := []struct {
+		name      string
+		data      string
+		expBlocks int
+		expOffset int
+		expErr    bool
+	}{
+		{name: "secret", data: enc, expBlocks: 1, expOffset: 0},
+		{name: "none", data: "no secrets here\n", expBlocks: 0},
+		{name: "indented", data: "secret: |\n" + indented + "\n", expBlocks: 1, expOffset: 14},
+		{name: "commented", data: commented, expBlocks: 1, expOffset: 2},
+		{name: "json string", data: `{"secret": ` + string(quoted) + "}", expBlocks: 1, expOffset: 12},
+		{name: "two secrets", data: enc + "\n" + enc, expBlocks: 2, expOffset: 0},
+		{name: "unterminated", data: "x" + enc[:100], expBlocks: 1, expOffset: 1, expErr: true},
+		{name: "malformed", data: beginMarker + "\nnot base64\n" + endMarker + "\n", expBlocks: 1, expErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blocks := Blocks([]byte(test.data))
+			assert.Len(t, blocks, test.expBlocks)
+			for _, b := range blocks {
+				if test.expErr {
+					assert.NotNil(t, b.Err)
+					assert.Nil(t, b.Info)
+					continue
+				}
+				assert.Nil(t, b.Err)
+				assert.Equal(t, 2, b.Info.Threshold)
+				assert.Len(t, b.Info.KeyIDs, 2)
+			}
+			if test.expBlocks > 0 {
+				assert.Equal(t, test.expOffset, blocks[0].Offset)
+				assert.True(t, strings.HasPrefix(test.data[blocks[0].Offset:], beginMarker))
+				if !test.expErr {
+					assert.True(t, strings.HasSuffix(test.data[:blocks[0].Offset+blocks[0].Length], endMarker))
+				}
+			}
+		})
+	}
+}
+
+func TestDirReport(t *testing.T) {
+	alice, bob, carol := loadTestKeys(t, "alice")[0], loadTestKeys(t, "bob")[0], loadTestKeys(t, "carol")[0]
+	enc, err := multikey.Encrypt([]byte("secret"), []*rsa.PublicKey{alice, bob}, 1)
+	assert.Nil(t, err)
+	env, err := dotenv.Encrypt([]byte("A=1\n"), []*rsa.PublicKey{bob, carol}, 2, nil)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "app.secret"), []byte(enc))
+	writeTestFile(t, filepath.Join(dir, "config", "app.env"), env)
+	writeTestFile(t, filepath.Join(dir, "README"), []byte("nothing to see\n"))
+	writeTestFile(t, filepath.Join(dir, ".git", "objects", "blob"), []byte(enc))
+
+	secrets, err := Dir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, secrets, 2)
+	assert.Equal(t, "app.secret", secrets[0].Path)
+	assert.Equal(t, "config/app.env", secrets[1].Path)
+
+	fpAlice, fpBob, fpCarol := keys.GetFingerprint(alice), keys.GetFingerprint(bob), keys.GetFingerprint(carol)
+	report := NewReport(secrets, map[string]string{fpAlice: "alice", fpBob: "bob"})
+	assert.Len(t, report.Secrets, 2)
+	assert.Equal(t, 1, report.Secrets[0].Threshold)
+	assert.Equal(t, 2, report.Secrets[1].Threshold)
+	assert.Equal(t, Location{Path: "app.secret", Offset: 0}, report.Secrets[0].Location)
+
+	// named keys come first, and list every secret they can help decrypt
+	assert.Equal(t, []KeyAccess{
+		{Key: Key{Fingerprint: fpAlice, Name: "alice"}, Secrets: []Location{report.Secrets[0].Location}},
+		{Key: Key{Fingerprint: fpBob, Name: "bob"}, Secrets: []Location{report.Secrets[0].Location, report.Secrets[1].Location}},
+		{Key: Key{Fingerprint: fpCarol}, Secrets: []Location{report.Secrets[1].Location}},
+	}, report.Keys)
+	assert.Equal(t, fpCarol, report.Keys[2].String())
+
+	// alice alone can decrypt the first secret, carol only with bob the second
+	report.FindRevoked(map[string]bool{fpAlice: true, fpCarol: true})
+	assert.Equal(t, []RevokedAccess{
+		{Location: report.Secrets[0].Location, Threshold: 1, Keys: []Key{{Fingerprint: fpAlice, Name: "alice"}}, Exposed: true},
+		{Location: report.Secrets[1].Location, Threshold: 2, Keys: []Key{{Fingerprint: fpCarol}}},
+	}, report.Revoked)
+	report.FindRevoked(map[string]bool{})
+	assert.Empty(t, report.Revoked)
+}
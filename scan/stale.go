@@ -0,0 +1,45 @@
+package scan
+
+import (
+	"time"
+)
+
+// StaleSecret is a secret past its rotate by or not after time
+type StaleSecret struct {
+	Location
+	Name     string     `json:"name,omitempty"`
+	RotateBy *time.Time `json:"rotate_by,omitempty"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+
+	// Expired is set when the secret is past its not after time, and can
+	// no longer be decrypted without explicitly allowing it
+	Expired bool `json:"expired"`
+}
+
+// Stale lists the secrets which are due to be rotated, or have expired,
+// at the given time, in the order they are given
+func Stale(secrets []Secret, now time.Time) []StaleSecret {
+	stale := []StaleSecret{}
+	for _, s := range secrets {
+		if s.Info == nil {
+			continue
+		}
+		md := s.Info.Metadata
+		if !md.Overdue(now) && !md.Expired(now) {
+			continue
+		}
+		entry := StaleSecret{
+			Location: Location{Path: s.Path, Offset: s.Offset},
+			Name:     md.Name,
+			Expired:  md.Expired(now),
+		}
+		if !md.RotateBy.IsZero() {
+			entry.RotateBy = &md.RotateBy
+		}
+		if !md.NotAfter.IsZero() {
+			entry.NotAfter = &md.NotAfter
+		}
+		stale = append(stale, entry)
+	}
+	return stale
+}
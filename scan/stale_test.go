@@ -0,0 +1,36 @@
+package scan
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/multikey"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStale(t *testing.T) {
+	pubs := loadTestKeys(t, "alice")
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	lastWeek, nextWeek := now.AddDate(0, 0, -7), now.AddDate(0, 0, 7)
+	encrypt := func(md *multikey.Metadata) []byte {
+		enc, err := multikey.EncryptWithOptions([]byte("secret"), pubs, 1, &multikey.EncryptOptions{Metadata: md})
+		assert.Nil(t, err)
+		return []byte(enc)
+	}
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a-overdue.secret"), encrypt(&multikey.Metadata{Name: "db", RotateBy: lastWeek}))
+	writeTestFile(t, filepath.Join(dir, "b-expired.secret"), encrypt(&multikey.Metadata{NotAfter: now}))
+	writeTestFile(t, filepath.Join(dir, "c-fresh.secret"), encrypt(&multikey.Metadata{RotateBy: nextWeek, NotAfter: nextWeek}))
+	writeTestFile(t, filepath.Join(dir, "d-undated.secret"), encrypt(nil))
+	writeTestFile(t, filepath.Join(dir, "e-malformed.secret"), []byte(beginMarker+"\n"))
+
+	secrets, err := Dir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []StaleSecret{
+		{Location: Location{Path: "a-overdue.secret"}, Name: "db", RotateBy: &lastWeek},
+		{Location: Location{Path: "b-expired.secret"}, NotAfter: &now, Expired: true},
+	}, Stale(secrets, now))
+	assert.Empty(t, Stale(secrets, lastWeek.Add(-time.Second)))
+	assert.Len(t, Stale(secrets, nextWeek), 3)
+}
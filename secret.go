@@ -1,32 +1,92 @@
 package multikey
 
 import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/adrianosela/multikey/shamir"
 )
 
 const (
 	pemBlockType       = "MULTIKEY ENCRYPTED SECRET"
 	simpleFmtSeparator = "\n"
 
+	// payloadKeyID is the reserved key id of the line in the simple
+	// format which carries the encrypted payload of a version 2 secret
+	payloadKeyID = "data"
+
+	// signatureKeyID is the reserved key id of the line in the simple
+	// format which carries the author signature of a signed secret
+	signatureKeyID = "signature"
+
+	// xSeparator separates a key id from the x coordinate of its shard
+	xSeparator = "@"
+
+	// pemLineBytes is the number of bytes encoded in each (64 character)
+	// line of a PEM block. Lines of version 2 secrets are padded with
+	// spaces to a multiple of it, so that each line of the simple format
+	// maps to whole lines of the PEM block, and changing one shard only
+	// changes the PEM lines of that shard.
+	pemLineBytes = 48
+
+	headerVersion   = "Version"
+	headerThreshold = "Threshold"
+	headerSignedBy  = "Signed-By"
+
+	// legacyVersion secrets have the plaintext itself split into shards.
+	// currentVersion secrets have a random data key split into shards,
+	// with the plaintext encrypted under it and the PEM headers bound
+	// to the ciphertext as associated data.
+	legacyVersion  = 1
+	currentVersion = 2
+
 	errMsgInvalidSimpleFmt  = "bad format"
 	errMsgCouldNotDecodePEM = "could not decode pem block"
+	errMsgBadHeader         = "bad secret header"
+	errMsgBadPayload        = "secret must have exactly one payload"
+	errMsgBadSignature      = "signed secrets must have exactly one signature"
 )
 
 // secret represents an encrypted secret
 type secret struct {
-	shards []*encryptedShard
+	version   int
+	threshold int
+	shards    []*encryptedShard
+	payload   []byte
+
+	// signer is the fingerprint of the key which signed the secret, and
+	// signature its signature, if the secret is signed
+	signer    string
+	signature []byte
+
+	// bound secrets are bound to a context, which is not encoded
+	bound   bool
+	context string
+
+	metadata *Metadata
 }
 
 // encodePEM returns an encrypted secret in a PEM block
 func (s *secret) encodePEM() (string, error) {
-	pemBytes := pem.EncodeToMemory(&pem.Block{
+	block := &pem.Block{
 		Type:  pemBlockType,
 		Bytes: []byte(s.encodeSimple()),
-	})
-	return string(pemBytes), nil
+	}
+	if s.version >= currentVersion {
+		block.Headers = s.headers()
+		if s.signer != "" {
+			block.Headers[headerSignedBy] = s.signer
+		}
+	}
+	return string(pem.EncodeToMemory(block)), nil
 }
 
 // decodePEM returns an encrypted secret from a pem block
@@ -39,18 +99,210 @@ func decodePEM(s string) (*secret, error) {
 	if err != nil {
 		return nil, err
 	}
-	return sec, nil
+	if len(block.Headers) == 0 {
+		return sec, nil
+	}
+	if err := sec.parseHeaders(block.Headers); err != nil {
+		return nil, err
+	}
+	return sec, sec.extractPayload()
+}
+
+// headers returns the PEM headers of a version 2 secret
+func (s *secret) headers() map[string]string {
+	h := map[string]string{
+		headerVersion:   strconv.Itoa(s.version),
+		headerThreshold: strconv.Itoa(s.threshold),
+	}
+	if s.bound {
+		h[headerContext] = contextBound
+	}
+	for name, value := range s.metadata.headers() {
+		h[name] = value
+	}
+	return h
+}
+
+// parseHeaders populates a secret's fields from its PEM headers
+func (s *secret) parseHeaders(h map[string]string) error {
+	version, err := strconv.Atoi(h[headerVersion])
+	if err != nil || version != currentVersion {
+		return fmt.Errorf("%s: unsupported version %q", errMsgBadHeader, h[headerVersion])
+	}
+	threshold, err := strconv.Atoi(h[headerThreshold])
+	if err != nil || threshold < 1 {
+		return fmt.Errorf("%s: invalid threshold %q", errMsgBadHeader, h[headerThreshold])
+	}
+	if context, ok := h[headerContext]; ok && context != contextBound {
+		return fmt.Errorf("%s: invalid context %q", errMsgBadHeader, context)
+	}
+	metadata, err := parseMetadata(h)
+	if err != nil {
+		return err
+	}
+	s.version, s.threshold = version, threshold
+	s.signer = h[headerSignedBy]
+	s.bound = h[headerContext] == contextBound
+	s.metadata = metadata
+	return nil
+}
+
+// extractPayload moves the payload line, and the signature line of
+// signed secrets, of a version 2 secret out of its shards
+func (s *secret) extractPayload() error {
+	shards := []*encryptedShard{}
+	for _, sh := range s.shards {
+		if sh.KeyID == signatureKeyID {
+			if s.signer == "" || s.signature != nil {
+				return errors.New(errMsgBadSignature)
+			}
+			signature, err := base64.StdEncoding.DecodeString(sh.Value)
+			if err != nil {
+				return fmt.Errorf("%s: %s", errMsgBadSignature, err)
+			}
+			s.signature = signature
+			continue
+		}
+		if sh.KeyID != payloadKeyID {
+			shards = append(shards, sh)
+			continue
+		}
+		if s.payload != nil {
+			return errors.New(errMsgBadPayload)
+		}
+		payload, err := base64.StdEncoding.DecodeString(sh.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", errMsgBadPayload, err)
+		}
+		s.payload = payload
+	}
+	if s.payload == nil {
+		return errors.New(errMsgBadPayload)
+	}
+	if s.signer != "" && s.signature == nil {
+		return errors.New(errMsgBadSignature)
+	}
+	s.shards = shards
+	return nil
+}
+
+// associatedData returns the canonical encoding of the secret's headers
+// and of the context it is bound to, which authenticates them as part of
+// the payload's ciphertext
+func (s *secret) associatedData() []byte {
+	return []byte(string(s.headerData()) + s.contextData())
+}
+
+// headerData returns the canonical encoding of the secret's headers
+func (s *secret) headerData() []byte {
+	h := s.headers()
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ad := pemBlockType + simpleFmtSeparator
+	for _, name := range names {
+		ad += fmt.Sprintf("%s: %s%s", name, h[name], simpleFmtSeparator)
+	}
+	return []byte(ad)
+}
+
+// decryptShards returns the values of the shards of the secret which the
+// given keys decrypt
+func (s *secret) decryptShards(privs []*rsa.PrivateKey) [][]byte {
+	parts := [][]byte{}
+	for _, sh := range s.shards {
+		if k, ok := getKey(privs, sh.KeyID); ok {
+			decrypted, err := sh.decrypt(k)
+			if err != nil {
+				continue // pass
+			}
+			parts = append(parts, decrypted.Value)
+		}
+	}
+	return parts
+}
+
+// open reconstructs the plaintext of the secret from its decrypted shards
+func (s *secret) open(parts [][]byte) ([]byte, error) {
+	if len(parts) == 0 || len(parts) < s.threshold {
+		return nil, ErrInsufficientKeys
+	}
+	combined, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, err
+	}
+	if s.version < currentVersion {
+		return combined, nil
+	}
+	return s.openPayload(combined)
+}
+
+// openPayload decrypts the payload of a version 2 secret with its data key.
+// Bound secrets can not be opened without their context, and a wrong
+// context can not be told apart from an altered secret.
+func (s *secret) openPayload(dataKey []byte) ([]byte, error) {
+	if s.bound && s.context == "" {
+		return nil, ErrContextMismatch
+	}
+	plain, err := openPayload(dataKey, s.payload, s.associatedData())
+	if err == nil || !s.bound {
+		return plain, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrAuthenticationFailed, errMsgAlteredOrContextMismatch)
+}
+
+// SecretID returns a stable identifier for an encrypted secret: the hex
+// encoded SHA-256 digest of its canonical PEM encoding. Differences in
+// surrounding whitespace or line endings do not change the identifier.
+func SecretID(enc string) (string, error) {
+	block, _ := pem.Decode([]byte(enc))
+	if block == nil || block.Type != pemBlockType {
+		return "", errors.New(errMsgCouldNotDecodePEM)
+	}
+	digest := sha256.Sum256(pem.EncodeToMemory(block))
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// sortShards puts the shards of a secret in their canonical order, by key id
+func (s *secret) sortShards() {
+	sort.SliceStable(s.shards, func(i, j int) bool {
+		return s.shards[i].KeyID < s.shards[j].KeyID
+	})
 }
 
 // EncodeSimple returns a simple string representation of the encrypted secret.
-// This format is KEY_ID(VALUE)
+// This format is KEY_ID(VALUE), or KEY_ID@X(VALUE) for shards which record
+// their x coordinate
 func (s *secret) encodeSimple() string {
 	ret := ""
-	for i, sh := range s.shards {
-		ret = strings.Join([]string{ret, fmt.Sprintf("%s(%s)", sh.KeyID, sh.Value)}, "")
-		if i != len(s.shards)-1 {
-			ret = strings.Join([]string{ret, simpleFmtSeparator}, "")
+	shards := s.shards
+	if s.payload != nil {
+		shards = append(shards[:len(shards):len(shards)], &encryptedShard{
+			KeyID: payloadKeyID,
+			Value: base64.StdEncoding.EncodeToString(s.payload),
+		})
+	}
+	if s.signature != nil {
+		shards = append(shards[:len(shards):len(shards)], &encryptedShard{
+			KeyID: signatureKeyID,
+			Value: base64.StdEncoding.EncodeToString(s.signature),
+		})
+	}
+	for i, sh := range shards {
+		id := sh.KeyID
+		if sh.X != 0 {
+			id = fmt.Sprintf("%s%s%d", sh.KeyID, xSeparator, sh.X)
 		}
+		line := fmt.Sprintf("%s(%s)", id, sh.Value)
+		if i != len(shards)-1 {
+			if s.version >= currentVersion {
+				line += strings.Repeat(" ", (pemLineBytes-(len(line)+len(simpleFmtSeparator))%pemLineBytes)%pemLineBytes)
+			}
+			line += simpleFmtSeparator
+		}
+		ret = strings.Join([]string{ret, line}, "")
 	}
 	return ret
 }
@@ -78,8 +330,16 @@ func decodeSimple(simpleEncodedShard string) (*encryptedShard, error) {
 	if len(p2) < 2 {
 		return nil, errors.New(errMsgInvalidSimpleFmt)
 	}
-	return &encryptedShard{
+	es := &encryptedShard{
 		KeyID: p1[0],
 		Value: p2[0],
-	}, nil
+	}
+	if i := strings.LastIndex(es.KeyID, xSeparator); i >= 0 {
+		x, err := strconv.Atoi(es.KeyID[i+1:])
+		if err != nil || x < 1 || x > 255 {
+			return nil, errors.New(errMsgInvalidSimpleFmt)
+		}
+		es.KeyID, es.X = es.KeyID[:i], x
+	}
+	return es, nil
 }
@@ -1,19 +1,40 @@
 package shamir
 
 import (
+	"crypto/rand"
 	"fmt"
-	mathrand "math/rand"
-	"time"
+	"io"
 
 	"github.com/adrianosela/multikey/galois"
 )
 
+// Options configures optional behaviour of SplitWithOptions.
+type Options struct {
+	// Rand is the source of randomness used to pick x coordinates and
+	// polynomial co-efficients. Defaults to crypto/rand.Reader when nil.
+	// Only tests should ever set this to a deterministic stream.
+	Rand io.Reader
+}
+
+func (o *Options) rand() io.Reader {
+	if o == nil || o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
 // Split takes an arbitrarily long secret and generates a `parts`
 // number of shares, `threshold` of which are required to reconstruct
 // the secret. The parts and threshold must be at least 2, and less
 // than 256. The returned shares are each one byte longer than the secret
 // as they attach a tag used to reconstruct the secret.
 func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	return SplitWithOptions(secret, parts, threshold, nil)
+}
+
+// SplitWithOptions is like Split but allows for the source of
+// randomness to be overridden through the given options.
+func SplitWithOptions(secret []byte, parts, threshold int, opts *Options) ([][]byte, error) {
 	// Sanity check the input
 	if parts < threshold {
 		return nil, fmt.Errorf("parts cannot be less than threshold")
@@ -32,8 +53,10 @@ func Split(secret []byte, parts, threshold int) ([][]byte, error) {
 	}
 
 	// Generate random list of x coordinates
-	mathrand.Seed(time.Now().UnixNano())
-	xCoordinates := mathrand.Perm(255)
+	xCoordinates, err := randomPerm(opts.rand(), 255)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate x coordinates: %s", err)
+	}
 
 	// Allocate the output array, initialize the final byte
 	// of the output with the offset. The representation of each
@@ -49,7 +72,10 @@ func Split(secret []byte, parts, threshold int) ([][]byte, error) {
 	// a single byte as the intercept of the polynomial, so we must
 	// use a new polynomial for each byte.
 	for idx, val := range secret {
-		p := galois.MakePolynomial(val, uint8(threshold-1))
+		p, err := galois.MakePolynomialWithRand(val, uint8(threshold-1), opts.rand())
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate polynomial: %s", err)
+		}
 
 		// Generate a `parts` number of (x,y) pairs
 		// We cheat by encoding the x value once as the final index,
@@ -68,6 +94,33 @@ func Split(secret []byte, parts, threshold int) ([][]byte, error) {
 // Combine is used to reverse a Split and reconstruct a secret
 // once a `threshold` number of parts are available.
 func Combine(parts [][]byte) ([]byte, error) {
+	return interpolate(parts, 0)
+}
+
+// Extend returns a new part of a secret, at the given x coordinate, from
+// enough existing parts to reconstruct it. The new part is consistent
+// with the existing ones: it can be combined with any threshold-1 of them
+// to reconstruct the secret. x must be non-zero and must not be the x
+// coordinate of any other part of the secret, including parts which are
+// not given.
+func Extend(parts [][]byte, x uint8) ([]byte, error) {
+	if x == 0 {
+		return nil, fmt.Errorf("x coordinate of a part must be non-zero")
+	}
+	for _, part := range parts {
+		if len(part) > 0 && part[len(part)-1] == x {
+			return nil, fmt.Errorf("x coordinate is already in use")
+		}
+	}
+	y, err := interpolate(parts, x)
+	if err != nil {
+		return nil, err
+	}
+	return append(y, x), nil
+}
+
+// interpolate evaluates, at x, the polynomials of which the parts are samples
+func interpolate(parts [][]byte, x uint8) ([]byte, error) {
 	// Verify enough parts provided
 	if len(parts) < 1 {
 		return nil, fmt.Errorf("less than one parts cannot be used to reconstruct the secret")
@@ -83,8 +136,8 @@ func Combine(parts [][]byte) ([]byte, error) {
 		}
 	}
 
-	// Create a buffer to store the reconstructed secret
-	secret := make([]byte, firstPartLen-1)
+	// Create a buffer to store the reconstructed values
+	values := make([]byte, firstPartLen-1)
 
 	// Buffer to store the samples
 	xSamples := make([]uint8, len(parts))
@@ -102,18 +155,42 @@ func Combine(parts [][]byte) ([]byte, error) {
 		xSamples[i] = samp
 	}
 
-	// Reconstruct each byte
-	for idx := range secret {
+	// Interpolate each byte
+	for idx := range values {
 		// Set the y value for each sample
 		for i, part := range parts {
 			ySamples[i] = part[idx]
 		}
 
-		// Interpolate the polynomial and compute the value at 0
-		val := galois.InterpolatePolynomial(xSamples, ySamples, 0)
+		// Interpolate the polynomial and compute its value at x
+		values[idx] = galois.InterpolatePolynomial(xSamples, ySamples, x)
+	}
+	return values, nil
+}
 
-		// Evaluate the 0th value to get the intercept
-		secret[idx] = val
+// randomPerm returns a uniformly random permutation of the integers
+// [0, n) for n <= 256, using a Fisher-Yates shuffle driven by the
+// given source of randomness.
+func randomPerm(rand io.Reader, n int) ([]int, error) {
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	buf := make([]byte, 1)
+	for i := n - 1; i > 0; i-- {
+		// reject samples past the largest multiple of (i+1)
+		// to avoid modulo bias in the chosen index
+		limit := 256 - (256 % (i + 1))
+		for {
+			if _, err := io.ReadFull(rand, buf); err != nil {
+				return nil, err
+			}
+			if int(buf[0]) < limit {
+				break
+			}
+		}
+		j := int(buf[0]) % (i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
 	}
-	return secret, nil
+	return perm, nil
 }
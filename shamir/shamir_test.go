@@ -2,6 +2,8 @@ package shamir
 
 import (
 	"bytes"
+	"encoding/hex"
+	mathrand "math/rand"
 	"testing"
 )
 
@@ -114,3 +116,106 @@ func TestCombine(t *testing.T) {
 		}
 	}
 }
+
+func TestExtend(t *testing.T) {
+	secret := []byte("test")
+
+	out, err := Split(secret, 3, 2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	used := map[byte]bool{}
+	for _, part := range out {
+		used[part[len(part)-1]] = true
+	}
+	x := uint8(1)
+	for used[x] {
+		x++
+	}
+
+	extra, err := Extend(out[:2], x)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if extra[len(extra)-1] != x {
+		t.Fatalf("bad x coordinate: %d", extra[len(extra)-1])
+	}
+
+	// the new part combines with any of the existing ones
+	for _, part := range out {
+		recomb, err := Combine([][]byte{part, extra})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !bytes.Equal(recomb, secret) {
+			t.Fatalf("bad: %v %v", recomb, secret)
+		}
+	}
+
+	if _, err := Extend(out[:2], 0); err == nil {
+		t.Fatalf("should err")
+	}
+	if _, err := Extend(out[:2], out[1][len(out[1])-1]); err == nil {
+		t.Fatalf("should err")
+	}
+}
+
+func TestSplitWithOptions_deterministic(t *testing.T) {
+	secret := []byte("test")
+
+	split := func() [][]byte {
+		opts := &Options{Rand: mathrand.New(mathrand.NewSource(1))}
+		out, err := SplitWithOptions(secret, 3, 2, opts)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return out
+	}
+
+	expected := []string{"430810e43e", "7b75a6e225", "07c89b5699"}
+	out := split()
+	if len(out) != len(expected) {
+		t.Fatalf("bad: %v", out)
+	}
+	for i, share := range out {
+		if got := hex.EncodeToString(share); got != expected[i] {
+			t.Fatalf("share %d: expected %s, got %s", i, expected[i], got)
+		}
+	}
+
+	again := split()
+	for i := range out {
+		if !bytes.Equal(out[i], again[i]) {
+			t.Fatalf("same randomness must produce identical shares")
+		}
+	}
+
+	recomb, err := Combine(out[1:])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(recomb, secret) {
+		t.Fatalf("bad: %v %v", recomb, secret)
+	}
+}
+
+func TestSplitWithOptions_randError(t *testing.T) {
+	opts := &Options{Rand: bytes.NewReader(nil)}
+	if _, err := SplitWithOptions([]byte("test"), 3, 2, opts); err == nil {
+		t.Fatalf("expect error")
+	}
+}
+
+func TestRandomPerm(t *testing.T) {
+	perm, err := randomPerm(mathrand.New(mathrand.NewSource(1)), 255)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	seen := map[int]bool{}
+	for _, v := range perm {
+		if v < 0 || v >= 255 || seen[v] {
+			t.Fatalf("bad permutation: %v", perm)
+		}
+		seen[v] = true
+	}
+}
@@ -0,0 +1,549 @@
+package shamir
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/adrianosela/multikey/galois"
+)
+
+// This file implements SLIP-0039 mnemonic shares as specified in
+// https://github.com/satoshilabs/slips/blob/master/slip-0039.md
+//
+// SLIP-0039 uses the same GF(2^8) field as Split and Combine, but lays out
+// and protects its shares differently (a digest share, a two level group
+// scheme and passphrase encryption of the master secret), so mnemonic
+// shares are not interchangeable with the raw shares returned by Split.
+
+const (
+	slip39RadixBits          = 10
+	slip39RadixMask          = 1<<slip39RadixBits - 1
+	slip39ChecksumWords      = 3
+	slip39DigestLength       = 4
+	slip39DigestIndex        = 254
+	slip39SecretIndex        = 255
+	slip39BaseIterationCount = 10000
+	slip39RoundCount         = 4
+	slip39MinSecretBytes     = 16
+	slip39MaxShareCount      = 16
+	slip39MaxIterationExp    = 15
+	slip39MinMnemonicWords   = 20
+
+	slip39Customization           = "shamir"
+	slip39CustomizationExtendable = "shamir_extendable"
+)
+
+var slip39WordIndex = func() map[string]int {
+	index := make(map[string]int, len(slip39Wordlist))
+	for i, w := range slip39Wordlist {
+		index[w] = i
+	}
+	return index
+}()
+
+// MnemonicGroup describes one group of a SLIP-0039 sharing scheme:
+// Count member shares are created, Threshold of which recover the group.
+type MnemonicGroup struct {
+	Threshold int
+	Count     int
+}
+
+// MnemonicOptions configures optional behaviour of SplitMnemonics.
+type MnemonicOptions struct {
+	// Passphrase encrypts the master secret. It must be printable ASCII
+	// and is required again to recover the same master secret.
+	Passphrase []byte
+
+	// IterationExponent increases the PBKDF2 work factor of the
+	// passphrase encryption by a factor of 2^IterationExponent.
+	IterationExponent int
+
+	// Extendable marks the shares as belonging to an extendable backup,
+	// in which further share sets can be created for the same secret.
+	Extendable bool
+
+	// Rand is the source of randomness. Defaults to crypto/rand.Reader.
+	Rand io.Reader
+}
+
+func (o *MnemonicOptions) rand() io.Reader {
+	if o == nil || o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
+// mnemonicShare is a single decoded SLIP-0039 share
+type mnemonicShare struct {
+	identifier        uint16
+	extendable        bool
+	iterationExponent int
+	groupIndex        int
+	groupThreshold    int
+	groupCount        int
+	memberIndex       int
+	memberThreshold   int
+	value             []byte
+}
+
+// SplitMnemonics splits a master secret into SLIP-0039 mnemonic shares.
+// A group threshold of the given groups must be recovered to reconstruct
+// the master secret. The returned mnemonics are indexed by group, and
+// each mnemonic is a space separated string of 20 to 33 words.
+func SplitMnemonics(masterSecret []byte, groupThreshold int, groups []MnemonicGroup, opts *MnemonicOptions) ([][]string, error) {
+	// Sanity check the input
+	if len(masterSecret) < slip39MinSecretBytes {
+		return nil, fmt.Errorf("master secret must be at least %d bytes", slip39MinSecretBytes)
+	}
+	if len(masterSecret)%2 != 0 {
+		return nil, fmt.Errorf("master secret must be an even number of bytes")
+	}
+	if len(groups) < 1 || len(groups) > slip39MaxShareCount {
+		return nil, fmt.Errorf("group count must be between 1 and %d", slip39MaxShareCount)
+	}
+	if groupThreshold < 1 || groupThreshold > len(groups) {
+		return nil, fmt.Errorf("group threshold must be between 1 and the group count")
+	}
+	for i, g := range groups {
+		if g.Count < 1 || g.Count > slip39MaxShareCount {
+			return nil, fmt.Errorf("group %d: member count must be between 1 and %d", i, slip39MaxShareCount)
+		}
+		if g.Threshold < 1 || g.Threshold > g.Count {
+			return nil, fmt.Errorf("group %d: member threshold must be between 1 and the member count", i)
+		}
+		if g.Threshold == 1 && g.Count > 1 {
+			return nil, fmt.Errorf("group %d: multiple member shares with a threshold of 1 are not allowed, use 1-of-1 instead", i)
+		}
+	}
+	var passphrase []byte
+	exponent, extendable := 0, false
+	if opts != nil {
+		passphrase, exponent, extendable = opts.Passphrase, opts.IterationExponent, opts.Extendable
+	}
+	if err := checkPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+	if exponent < 0 || exponent > slip39MaxIterationExp {
+		return nil, fmt.Errorf("iteration exponent must be between 0 and %d", slip39MaxIterationExp)
+	}
+
+	// Generate a random 15 bit identifier
+	idBytes := make([]byte, 2)
+	if _, err := io.ReadFull(opts.rand(), idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate identifier: %s", err)
+	}
+	identifier := binary.BigEndian.Uint16(idBytes) & 0x7fff
+
+	ems := slip39Feistel(masterSecret, passphrase, exponent, identifier, extendable, true)
+
+	groupShares, err := slip39SplitSecret(groupThreshold, len(groups), ems, opts.rand())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]string, len(groups))
+	for gi, g := range groups {
+		memberShares, err := slip39SplitSecret(g.Threshold, g.Count, groupShares[gi], opts.rand())
+		if err != nil {
+			return nil, err
+		}
+		for mi, value := range memberShares {
+			s := &mnemonicShare{
+				identifier:        identifier,
+				extendable:        extendable,
+				iterationExponent: exponent,
+				groupIndex:        gi,
+				groupThreshold:    groupThreshold,
+				groupCount:        len(groups),
+				memberIndex:       mi,
+				memberThreshold:   g.Threshold,
+				value:             value,
+			}
+			out[gi] = append(out[gi], s.mnemonic())
+		}
+	}
+	return out, nil
+}
+
+// CombineMnemonics is used to reverse SplitMnemonics and recover the
+// master secret from a sufficient set of SLIP-0039 mnemonic shares. The
+// passphrase must match the one used when splitting, but note that any
+// passphrase will decrypt to some master secret.
+func CombineMnemonics(mnemonics []string, passphrase []byte) ([]byte, error) {
+	if len(mnemonics) < 1 {
+		return nil, fmt.Errorf("no mnemonics provided")
+	}
+	if err := checkPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	shares := []*mnemonicShare{}
+	for i, m := range mnemonics {
+		s, err := decodeMnemonic(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mnemonic %d: %s", i, err)
+		}
+		shares = append(shares, s)
+	}
+
+	// Verify all shares belong to the same secret, and bucket them by group
+	first := shares[0]
+	groups := map[int][]*mnemonicShare{}
+	for _, s := range shares {
+		if s.identifier != first.identifier || s.extendable != first.extendable ||
+			s.iterationExponent != first.iterationExponent ||
+			s.groupThreshold != first.groupThreshold || s.groupCount != first.groupCount {
+			return nil, fmt.Errorf("all mnemonics must belong to the same secret")
+		}
+		if len(s.value) != len(first.value) {
+			return nil, fmt.Errorf("all mnemonics must be the same length")
+		}
+		for _, o := range groups[s.groupIndex] {
+			if o.memberThreshold != s.memberThreshold {
+				return nil, fmt.Errorf("mnemonics of group %d have differing member thresholds", s.groupIndex)
+			}
+			if o.memberIndex == s.memberIndex {
+				return nil, fmt.Errorf("duplicate member %d in group %d", s.memberIndex, s.groupIndex)
+			}
+		}
+		groups[s.groupIndex] = append(groups[s.groupIndex], s)
+	}
+
+	// Recover every group for which enough members are available
+	groupIndexes := []int{}
+	for gi := range groups {
+		groupIndexes = append(groupIndexes, gi)
+	}
+	sort.Ints(groupIndexes)
+	groupXs, groupYs := []uint8{}, [][]byte{}
+	for _, gi := range groupIndexes {
+		members := groups[gi]
+		if len(members) < members[0].memberThreshold {
+			continue
+		}
+		xs, ys := []uint8{}, [][]byte{}
+		for _, m := range members {
+			xs = append(xs, uint8(m.memberIndex))
+			ys = append(ys, m.value)
+		}
+		groupSecret, err := slip39RecoverSecret(members[0].memberThreshold, xs, ys)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %s", gi, err)
+		}
+		groupXs = append(groupXs, uint8(gi))
+		groupYs = append(groupYs, groupSecret)
+	}
+	if len(groupXs) < first.groupThreshold {
+		return nil, fmt.Errorf("insufficient mnemonics: %d of the required %d groups are complete", len(groupXs), first.groupThreshold)
+	}
+
+	ems, err := slip39RecoverSecret(first.groupThreshold, groupXs, groupYs)
+	if err != nil {
+		return nil, err
+	}
+	return slip39Feistel(ems, passphrase, first.iterationExponent, first.identifier, first.extendable, false), nil
+}
+
+// mnemonic encodes a share as a space separated list of words
+func (s *mnemonicShare) mnemonic() string {
+	ext := 0
+	if s.extendable {
+		ext = 1
+	}
+	idExp := int(s.identifier)<<5 | ext<<4 | s.iterationExponent
+	params := s.groupIndex<<16 | (s.groupThreshold-1)<<12 | (s.groupCount-1)<<8 |
+		s.memberIndex<<4 | (s.memberThreshold - 1)
+
+	data := []int{
+		idExp >> slip39RadixBits, idExp & slip39RadixMask,
+		params >> slip39RadixBits, params & slip39RadixMask,
+	}
+	data = append(data, bytesToWords(s.value)...)
+	data = append(data, rs1024Checksum(s.customization(), data)...)
+
+	words := make([]string, len(data))
+	for i, d := range data {
+		words[i] = slip39Wordlist[d]
+	}
+	return strings.Join(words, " ")
+}
+
+func (s *mnemonicShare) customization() string {
+	if s.extendable {
+		return slip39CustomizationExtendable
+	}
+	return slip39Customization
+}
+
+// decodeMnemonic parses and verifies the checksum of a single mnemonic
+func decodeMnemonic(mnemonic string) (*mnemonicShare, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < slip39MinMnemonicWords {
+		return nil, fmt.Errorf("mnemonic must be at least %d words", slip39MinMnemonicWords)
+	}
+	data := make([]int, len(words))
+	for i, w := range words {
+		idx, ok := slip39WordIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("word %d (%q) is not in the wordlist", i+1, w)
+		}
+		data[i] = idx
+	}
+	valueWords := len(data) - 4 - slip39ChecksumWords
+	if (valueWords*slip39RadixBits)%16 > 8 {
+		return nil, fmt.Errorf("invalid mnemonic length")
+	}
+
+	idExp := data[0]<<slip39RadixBits | data[1]
+	s := &mnemonicShare{
+		identifier:        uint16(idExp >> 5),
+		extendable:        (idExp>>4)&1 == 1,
+		iterationExponent: idExp & 0xf,
+	}
+	if !rs1024Verify(s.customization(), data) {
+		return nil, fmt.Errorf("invalid mnemonic checksum")
+	}
+
+	params := data[2]<<slip39RadixBits | data[3]
+	s.groupIndex = params >> 16
+	s.groupThreshold = (params>>12)&0xf + 1
+	s.groupCount = (params>>8)&0xf + 1
+	s.memberIndex = (params >> 4) & 0xf
+	s.memberThreshold = params&0xf + 1
+	if s.groupThreshold > s.groupCount {
+		return nil, fmt.Errorf("group threshold cannot exceed the group count")
+	}
+
+	value, err := wordsToBytes(data[4 : len(data)-slip39ChecksumWords])
+	if err != nil {
+		return nil, err
+	}
+	s.value = value
+	return s, nil
+}
+
+func checkPassphrase(passphrase []byte) error {
+	for _, c := range passphrase {
+		if c < 32 || c > 126 {
+			return fmt.Errorf("passphrase must only contain printable ASCII characters")
+		}
+	}
+	return nil
+}
+
+// bytesToWords converts a big-endian value to 10 bit words,
+// left padding it with zero bits as necessary
+func bytesToWords(value []byte) []int {
+	words := make([]int, (len(value)*8+slip39RadixBits-1)/slip39RadixBits)
+	acc, accBits := uint32(0), len(words)*slip39RadixBits-len(value)*8
+	wi := 0
+	for _, b := range value {
+		acc = acc<<8 | uint32(b)
+		accBits += 8
+		for accBits >= slip39RadixBits {
+			accBits -= slip39RadixBits
+			words[wi] = int(acc>>accBits) & slip39RadixMask
+			wi++
+		}
+		acc &= 1<<accBits - 1
+	}
+	return words
+}
+
+// wordsToBytes reverses bytesToWords, verifying the padding bits are zero
+func wordsToBytes(words []int) ([]byte, error) {
+	bits := len(words) * slip39RadixBits
+	padding := bits % 16
+	out := make([]byte, 0, (bits-padding)/8)
+	acc, accBits := uint32(0), 0
+	for i, w := range words {
+		acc = acc<<slip39RadixBits | uint32(w)
+		accBits += slip39RadixBits
+		if i == 0 {
+			if acc>>(accBits-padding) != 0 {
+				return nil, fmt.Errorf("invalid mnemonic padding")
+			}
+			accBits -= padding
+			acc &= 1<<accBits - 1
+		}
+		for accBits >= 8 {
+			accBits -= 8
+			out = append(out, byte(acc>>accBits))
+			acc &= 1<<accBits - 1
+		}
+	}
+	return out, nil
+}
+
+var rs1024Generator = [10]uint32{
+	0xe0e040, 0x1c1c080, 0x3838100, 0x7070200, 0xe0e0009,
+	0x1c0c2412, 0x38086c24, 0x3090fc48, 0x21b1f890, 0x3f3f120,
+}
+
+func rs1024Polymod(customization string, data []int) uint32 {
+	chk := uint32(1)
+	step := func(v uint32) {
+		b := chk >> 20
+		chk = (chk&0xfffff)<<10 ^ v
+		for i := 0; i < 10; i++ {
+			if (b>>i)&1 == 1 {
+				chk ^= rs1024Generator[i]
+			}
+		}
+	}
+	for i := 0; i < len(customization); i++ {
+		step(uint32(customization[i]))
+	}
+	for _, v := range data {
+		step(uint32(v))
+	}
+	return chk
+}
+
+func rs1024Checksum(customization string, data []int) []int {
+	padded := append(append([]int{}, data...), make([]int, slip39ChecksumWords)...)
+	pm := rs1024Polymod(customization, padded) ^ 1
+	checksum := make([]int, slip39ChecksumWords)
+	for i := range checksum {
+		checksum[i] = int(pm>>(slip39RadixBits*(slip39ChecksumWords-1-i))) & slip39RadixMask
+	}
+	return checksum
+}
+
+func rs1024Verify(customization string, data []int) bool {
+	return rs1024Polymod(customization, data) == 1
+}
+
+// slip39SplitSecret splits a secret into count shares with the given
+// threshold, where the i-th share has x coordinate i. The polynomial also
+// passes through a digest of the secret at slip39DigestIndex so that the
+// recovered secret can be verified.
+func slip39SplitSecret(threshold, count int, secret []byte, rand io.Reader) ([][]byte, error) {
+	shares := make([][]byte, count)
+	if threshold == 1 {
+		for i := range shares {
+			shares[i] = append([]byte{}, secret...)
+		}
+		return shares, nil
+	}
+
+	xs, ys := []uint8{}, [][]byte{}
+	for i := 0; i < threshold-2; i++ {
+		shares[i] = make([]byte, len(secret))
+		if _, err := io.ReadFull(rand, shares[i]); err != nil {
+			return nil, fmt.Errorf("failed to generate random share: %s", err)
+		}
+		xs = append(xs, uint8(i))
+		ys = append(ys, shares[i])
+	}
+
+	randomPart := make([]byte, len(secret)-slip39DigestLength)
+	if _, err := io.ReadFull(rand, randomPart); err != nil {
+		return nil, fmt.Errorf("failed to generate digest: %s", err)
+	}
+	digest := append(slip39Digest(randomPart, secret), randomPart...)
+	xs = append(xs, slip39DigestIndex, slip39SecretIndex)
+	ys = append(ys, digest, secret)
+
+	for i := threshold - 2; i < count; i++ {
+		shares[i] = interpolateBytes(xs, ys, uint8(i))
+	}
+	return shares, nil
+}
+
+// slip39RecoverSecret reverses slip39SplitSecret, verifying the digest
+func slip39RecoverSecret(threshold int, xs []uint8, ys [][]byte) ([]byte, error) {
+	if threshold == 1 {
+		return ys[0], nil
+	}
+	secret := interpolateBytes(xs, ys, slip39SecretIndex)
+	digest := interpolateBytes(xs, ys, slip39DigestIndex)
+	if !hmac.Equal(digest[:slip39DigestLength], slip39Digest(digest[slip39DigestLength:], secret)) {
+		return nil, fmt.Errorf("invalid digest of the shared secret")
+	}
+	return secret, nil
+}
+
+func slip39Digest(randomPart, secret []byte) []byte {
+	mac := hmac.New(sha256.New, randomPart)
+	mac.Write(secret)
+	return mac.Sum(nil)[:slip39DigestLength]
+}
+
+// interpolateBytes evaluates at x the polynomials passing through
+// each byte position of the given samples
+func interpolateBytes(xs []uint8, ys [][]byte, x uint8) []byte {
+	out := make([]byte, len(ys[0]))
+	samples := make([]uint8, len(ys))
+	for idx := range out {
+		for i, y := range ys {
+			samples[i] = y[idx]
+		}
+		out[idx] = galois.InterpolatePolynomial(xs, samples, x)
+	}
+	return out
+}
+
+// slip39Feistel encrypts (or decrypts) the master secret with the
+// passphrase using the four round Feistel network of SLIP-0039
+func slip39Feistel(secret, passphrase []byte, exponent int, identifier uint16, extendable, encrypt bool) []byte {
+	half := len(secret) / 2
+	l := append([]byte{}, secret[:half]...)
+	r := append([]byte{}, secret[half:]...)
+
+	salt := []byte{}
+	if !extendable {
+		salt = append([]byte(slip39Customization), byte(identifier>>8), byte(identifier))
+	}
+	iterations := (slip39BaseIterationCount << exponent) / slip39RoundCount
+
+	for i := 0; i < slip39RoundCount; i++ {
+		round := i
+		if !encrypt {
+			round = slip39RoundCount - 1 - i
+		}
+		password := append([]byte{byte(round)}, passphrase...)
+		f := pbkdf2SHA256(password, append(append([]byte{}, salt...), r...), iterations, half)
+		for j := range f {
+			f[j] ^= l[j]
+		}
+		l, r = r, f
+	}
+	return append(r, l...)
+}
+
+// pbkdf2SHA256 derives a key as per RFC 8018 with HMAC-SHA256 as the PRF
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	counter := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		prf.Write(counter)
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
@@ -0,0 +1,270 @@
+package shamir
+
+import (
+	"bytes"
+	"encoding/hex"
+	mathrand "math/rand"
+	"strings"
+	"testing"
+)
+
+// slip39Vectors are taken from the official SLIP-0039 test vectors
+// https://github.com/trezor/python-shamir-mnemonic/blob/master/vectors.json
+// all of which use the passphrase "TREZOR".
+var slip39Vectors = []struct {
+	description string
+	mnemonics   []string
+	secret      string
+}{
+	{
+		description: "valid mnemonic without sharing (128 bits)",
+		mnemonics: []string{
+			"duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision keyboard",
+		},
+		secret: "bb54aac4b89dc868ba37d9cc21b2cece",
+	},
+	{
+		description: "basic sharing 2-of-3 (128 bits)",
+		mnemonics: []string{
+			"shadow pistol academic always adequate wildlife fancy gross oasis cylinder mustang wrist rescue view short owner flip making coding armed",
+			"shadow pistol academic acid actress prayer class unknown daughter sweater depict flip twice unkind craft early superior advocate guest smoking",
+		},
+		secret: "b43ceb7e57a0ea8766221624d01b0864",
+	},
+	{
+		description: "mnemonics with group threshold 2 and members of two groups (128 bits)",
+		mnemonics: []string{
+			"eraser senior decision roster beard treat identify grumpy salt index fake aviation theater cubic bike cause research dragon emphasis counter",
+			"eraser senior ceramic snake clay various huge numb argue hesitate auction category timber browser greatest hanger petition script leaf pickup",
+			"eraser senior ceramic shaft dynamic become junior wrist silver peasant force math alto coal amazing segment yelp velvet image paces",
+			"eraser senior ceramic round column hawk trust auction smug shame alive greatest sheriff living perfect corner chest sled fumes adequate",
+			"eraser senior decision smug corner ruin rescue cubic angel tackle skin skunk program roster trash rumor slush angel flea amazing",
+		},
+		secret: "7c3397a292a5941682d7a4ae2d898d11",
+	},
+	{
+		description: "valid mnemonic without sharing (256 bits)",
+		mnemonics: []string{
+			"theory painting academic academic armed sweater year military elder discuss acne wildlife boring employer fused large satoshi bundle carbon diagnose anatomy hamster leaves tracks paces beyond phantom capital marvel lips brave detect luck",
+		},
+		secret: "989baf9dcaad5b10ca33dfd8cc75e42477025dce88ae83e75a230086a0e00e92",
+	},
+	{
+		description: "valid extendable mnemonic without sharing (128 bits)",
+		mnemonics: []string{
+			"testify swimming academic academic column loyalty smear include exotic bedroom exotic wrist lobe cover grief golden smart junior estimate learn",
+		},
+		secret: "1679b4516e0ee5954351d288a838f45e",
+	},
+}
+
+func TestCombineMnemonics_vectors(t *testing.T) {
+	for _, v := range slip39Vectors {
+		secret, err := CombineMnemonics(v.mnemonics, []byte("TREZOR"))
+		if err != nil {
+			t.Fatalf("%s: err: %v", v.description, err)
+		}
+		if out := hex.EncodeToString(secret); out != v.secret {
+			t.Fatalf("%s: bad: %s", v.description, out)
+		}
+	}
+}
+
+func TestCombineMnemonics_invalid(t *testing.T) {
+	tests := []struct {
+		description string
+		mnemonics   []string
+	}{
+		{
+			description: "no mnemonics",
+			mnemonics:   nil,
+		},
+		{
+			description: "invalid checksum",
+			mnemonics: []string{
+				"duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision kidney",
+			},
+		},
+		{
+			description: "word not in wordlist",
+			mnemonics: []string{
+				"duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision keyboards",
+			},
+		},
+		{
+			description: "too short",
+			mnemonics:   []string{"duckling enlarge academic academic agency"},
+		},
+		{
+			description: "insufficient members",
+			mnemonics:   slip39Vectors[1].mnemonics[:1],
+		},
+		{
+			description: "insufficient groups",
+			mnemonics:   slip39Vectors[2].mnemonics[1:4],
+		},
+		{
+			description: "mnemonics of different secrets",
+			mnemonics: []string{
+				slip39Vectors[1].mnemonics[0],
+				slip39Vectors[2].mnemonics[0],
+			},
+		},
+		{
+			description: "duplicate member",
+			mnemonics: []string{
+				slip39Vectors[1].mnemonics[0],
+				slip39Vectors[1].mnemonics[0],
+			},
+		},
+	}
+	for _, test := range tests {
+		if _, err := CombineMnemonics(test.mnemonics, []byte("TREZOR")); err == nil {
+			t.Fatalf("%s: expect error", test.description)
+		}
+	}
+
+	if _, err := CombineMnemonics(slip39Vectors[0].mnemonics, []byte("\x00")); err == nil {
+		t.Fatalf("expect error for non printable passphrase")
+	}
+}
+
+func TestSplitMnemonics_invalid(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	groups := []MnemonicGroup{{Threshold: 2, Count: 3}}
+
+	if _, err := SplitMnemonics(secret[:14], 1, groups, nil); err == nil {
+		t.Fatalf("expect error for short secret")
+	}
+	if _, err := SplitMnemonics(append(secret, 'x'), 1, groups, nil); err == nil {
+		t.Fatalf("expect error for odd length secret")
+	}
+	if _, err := SplitMnemonics(secret, 2, groups, nil); err == nil {
+		t.Fatalf("expect error for group threshold above group count")
+	}
+	if _, err := SplitMnemonics(secret, 1, []MnemonicGroup{{Threshold: 1, Count: 2}}, nil); err == nil {
+		t.Fatalf("expect error for 1-of-2 member sharing")
+	}
+	if _, err := SplitMnemonics(secret, 1, []MnemonicGroup{{Threshold: 2, Count: 17}}, nil); err == nil {
+		t.Fatalf("expect error for too many members")
+	}
+	if _, err := SplitMnemonics(secret, 1, groups, &MnemonicOptions{IterationExponent: 16}); err == nil {
+		t.Fatalf("expect error for iteration exponent")
+	}
+}
+
+func TestSplitCombineMnemonics(t *testing.T) {
+	tests := []struct {
+		description    string
+		secretLen      int
+		groupThreshold int
+		groups         []MnemonicGroup
+		opts           *MnemonicOptions
+		wordCount      int
+	}{
+		{
+			description:    "single 1-of-1 group",
+			secretLen:      16,
+			groupThreshold: 1,
+			groups:         []MnemonicGroup{{Threshold: 1, Count: 1}},
+			wordCount:      20,
+		},
+		{
+			description:    "single 3-of-5 group with passphrase",
+			secretLen:      16,
+			groupThreshold: 1,
+			groups:         []MnemonicGroup{{Threshold: 3, Count: 5}},
+			opts:           &MnemonicOptions{Passphrase: []byte("correct horse")},
+			wordCount:      20,
+		},
+		{
+			description:    "2-of-3 groups of a 256 bit secret",
+			secretLen:      32,
+			groupThreshold: 2,
+			groups: []MnemonicGroup{
+				{Threshold: 1, Count: 1},
+				{Threshold: 2, Count: 3},
+				{Threshold: 3, Count: 5},
+			},
+			opts:      &MnemonicOptions{IterationExponent: 1},
+			wordCount: 33,
+		},
+		{
+			description:    "extendable",
+			secretLen:      16,
+			groupThreshold: 1,
+			groups:         []MnemonicGroup{{Threshold: 2, Count: 2}},
+			opts:           &MnemonicOptions{Extendable: true},
+			wordCount:      20,
+		},
+	}
+	for _, test := range tests {
+		secret := make([]byte, test.secretLen)
+		mathrand.New(mathrand.NewSource(1)).Read(secret)
+
+		mnemonics, err := SplitMnemonics(secret, test.groupThreshold, test.groups, test.opts)
+		if err != nil {
+			t.Fatalf("%s: err: %v", test.description, err)
+		}
+		if len(mnemonics) != len(test.groups) {
+			t.Fatalf("%s: bad: %v", test.description, mnemonics)
+		}
+
+		// combine the threshold of members of the last groups
+		selected := []string{}
+		for gi := len(test.groups) - test.groupThreshold; gi < len(test.groups); gi++ {
+			if len(mnemonics[gi]) != test.groups[gi].Count {
+				t.Fatalf("%s: bad: %v", test.description, mnemonics[gi])
+			}
+			for _, m := range mnemonics[gi] {
+				if n := len(strings.Fields(m)); n != test.wordCount {
+					t.Fatalf("%s: expected %d words, got %d", test.description, test.wordCount, n)
+				}
+			}
+			selected = append(selected, mnemonics[gi][len(mnemonics[gi])-test.groups[gi].Threshold:]...)
+		}
+
+		var passphrase []byte
+		if test.opts != nil {
+			passphrase = test.opts.Passphrase
+		}
+		recovered, err := CombineMnemonics(selected, passphrase)
+		if err != nil {
+			t.Fatalf("%s: err: %v", test.description, err)
+		}
+		if !bytes.Equal(recovered, secret) {
+			t.Fatalf("%s: bad: %x %x", test.description, recovered, secret)
+		}
+	}
+}
+
+func TestSplitMnemonics_deterministic(t *testing.T) {
+	split := func() [][]string {
+		opts := &MnemonicOptions{Rand: mathrand.New(mathrand.NewSource(1))}
+		out, err := SplitMnemonics([]byte("0123456789abcdef"), 1, []MnemonicGroup{{Threshold: 2, Count: 3}}, opts)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return out
+	}
+	a, b := split(), split()
+	for i := range a[0] {
+		if a[0][i] != b[0][i] {
+			t.Fatalf("same randomness must produce identical mnemonics")
+		}
+	}
+}
+
+func TestWordsBytesRoundTrip(t *testing.T) {
+	for _, n := range []int{16, 18, 32} {
+		value := make([]byte, n)
+		mathrand.New(mathrand.NewSource(int64(n))).Read(value)
+		out, err := wordsToBytes(bytesToWords(value))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !bytes.Equal(out, value) {
+			t.Fatalf("bad: %x %x", out, value)
+		}
+	}
+}
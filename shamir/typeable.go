@@ -0,0 +1,193 @@
+package shamir
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// Typeable shares are meant to be read over the phone or typed from a
+// printout. A share and its CRC-32 are encoded with Crockford's base32
+// alphabet (no I, L, O or U, case insensitive) and split into groups of
+// four characters, each followed by a check character:
+//
+//	7ZK2M-Q9X0A-...
+//
+// A typo in a single character is caught by the check character of its
+// group, which pinpoints where the typo lies. The overall CRC catches any
+// combination of typos that the per-group checks miss.
+
+const (
+	typeableAlphabet    = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	typeableGroupData   = 4
+	typeableGroupSize   = typeableGroupData + 1
+	typeableSeparator   = "-"
+	typeableCRCLength   = 4
+	typeableGFPoly      = 0x25 // x^5 + x^2 + 1
+	typeableGFGenerator = 2
+)
+
+var (
+	typeableEncoding = base32.NewEncoding(typeableAlphabet).WithPadding(base32.NoPadding)
+
+	// typeableReplacer folds the characters Crockford's base32 accepts as
+	// aliases onto their canonical symbols, and drops separators
+	typeableReplacer = strings.NewReplacer(
+		"O", "0", "I", "1", "L", "1",
+		"-", "", " ", "", "\t", "", "\n", "", "\r", "",
+	)
+)
+
+// ChecksumError is returned when a typeable share fails its checksum
+type ChecksumError struct {
+	// Share is the index of the offending share when decoding
+	// several shares, or -1 when decoding a single share
+	Share int
+
+	// Group is the 1-based group of characters which contains a typo,
+	// or 0 when only the overall CRC failed to verify
+	Group int
+}
+
+func (e *ChecksumError) Error() string {
+	where := "share"
+	if e.Share >= 0 {
+		where = fmt.Sprintf("share %d", e.Share)
+	}
+	if e.Group == 0 {
+		return fmt.Sprintf("%s failed its overall checksum", where)
+	}
+	first := (e.Group-1)*typeableGroupSize + 1
+	return fmt.Sprintf("%s failed its checksum in group %d (characters %d-%d)",
+		where, e.Group, first, first+typeableGroupSize-1)
+}
+
+// EncodeTypeableShare returns a share in the transcription-safe typeable format
+func EncodeTypeableShare(share []byte) string {
+	payload := make([]byte, len(share), len(share)+typeableCRCLength)
+	copy(payload, share)
+	payload = binary.BigEndian.AppendUint32(payload, crc32.ChecksumIEEE(share))
+
+	data := typeableEncoding.EncodeToString(payload)
+	groups := []string{}
+	for len(data) > 0 {
+		n := typeableGroupData
+		if len(data) < n {
+			n = len(data)
+		}
+		group := data[:n]
+		groups = append(groups, group+string(typeableAlphabet[typeableCheck(group)]))
+		data = data[n:]
+	}
+	return strings.Join(groups, typeableSeparator)
+}
+
+// DecodeTypeableShare decodes a share in the typeable format. Lower case
+// characters, the aliases I, L and O, and any separators are tolerated.
+// A *ChecksumError is returned if a typo is detected.
+func DecodeTypeableShare(s string) ([]byte, error) {
+	share, err := decodeTypeableShare(s)
+	if cerr, ok := err.(*ChecksumError); ok {
+		cerr.Share = -1
+	}
+	return share, err
+}
+
+// DecodeTypeableShares decodes a list of shares as per DecodeTypeableShare.
+// A *ChecksumError identifying the offending share is returned if a typo
+// is detected in any of them.
+func DecodeTypeableShares(encoded []string) ([][]byte, error) {
+	shares := [][]byte{}
+	for i, s := range encoded {
+		share, err := decodeTypeableShare(s)
+		if err != nil {
+			if cerr, ok := err.(*ChecksumError); ok {
+				cerr.Share = i
+				return nil, cerr
+			}
+			return nil, fmt.Errorf("invalid share %d: %s", i, err)
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// CombineTypeableShares decodes shares in the typeable format and combines
+// them, reporting which share failed its checksum should any of them
+// contain a typo.
+func CombineTypeableShares(encoded []string) ([]byte, error) {
+	shares, err := DecodeTypeableShares(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return Combine(shares)
+}
+
+func decodeTypeableShare(s string) ([]byte, error) {
+	normalized := typeableReplacer.Replace(strings.ToUpper(s))
+	for i, c := range normalized {
+		if !strings.ContainsRune(typeableAlphabet, c) {
+			return nil, fmt.Errorf("invalid character %q at position %d", c, i+1)
+		}
+	}
+	if len(normalized)%typeableGroupSize == 1 {
+		return nil, fmt.Errorf("invalid length, a character may be missing")
+	}
+
+	// verify and strip the check character of each group
+	data := ""
+	for group := 1; len(normalized) > 0; group++ {
+		n := typeableGroupSize
+		if len(normalized) < n {
+			n = len(normalized)
+		}
+		chars, check := normalized[:n-1], normalized[n-1]
+		if typeableAlphabet[typeableCheck(chars)] != check {
+			return nil, &ChecksumError{Group: group}
+		}
+		data += chars
+		normalized = normalized[n:]
+	}
+
+	payload, err := typeableEncoding.DecodeString(data)
+	if err != nil || len(payload) <= typeableCRCLength {
+		return nil, fmt.Errorf("invalid length, a character may be missing")
+	}
+	share := payload[:len(payload)-typeableCRCLength]
+	if binary.BigEndian.Uint32(payload[len(share):]) != crc32.ChecksumIEEE(share) {
+		return nil, &ChecksumError{}
+	}
+	return share, nil
+}
+
+// typeableCheck computes the check symbol of a group of characters as
+// sum(v_i * g^(i+1)) over GF(32). Since each position has a distinct
+// non-zero weight, any single substitution or adjacent transposition
+// within the group changes the check symbol.
+func typeableCheck(group string) uint8 {
+	var check, weight uint8 = 0, 1
+	for i := 0; i < len(group); i++ {
+		weight = gf32Mult(weight, typeableGFGenerator)
+		v := uint8(strings.IndexByte(typeableAlphabet, group[i]))
+		check ^= gf32Mult(v, weight)
+	}
+	return check
+}
+
+// gf32Mult multiplies two numbers in GF(2^5)
+func gf32Mult(a, b uint8) uint8 {
+	var product uint8
+	for b > 0 {
+		if b&1 == 1 {
+			product ^= a
+		}
+		a <<= 1
+		if a&0x20 != 0 {
+			a ^= typeableGFPoly
+		}
+		b >>= 1
+	}
+	return product
+}
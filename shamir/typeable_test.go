@@ -0,0 +1,118 @@
+package shamir
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTypeableShareRoundTrip(t *testing.T) {
+	shares, err := DecodeVaultShares([]string{vaultVectors[0].hex})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	encoded := EncodeTypeableShare(shares[0])
+	for _, group := range strings.Split(encoded, typeableSeparator) {
+		if len(group) > typeableGroupSize {
+			t.Fatalf("bad group: %s", group)
+		}
+	}
+
+	// case, aliases and separators do not matter
+	variants := []string{
+		encoded,
+		strings.ToLower(encoded),
+		strings.ReplaceAll(encoded, typeableSeparator, " "),
+		strings.ReplaceAll(strings.ReplaceAll(encoded, "0", "o"), "1", "l"),
+	}
+	for _, v := range variants {
+		decoded, err := DecodeTypeableShare(v)
+		if err != nil {
+			t.Fatalf("%s: err: %v", v, err)
+		}
+		if !bytes.Equal(decoded, shares[0]) {
+			t.Fatalf("bad: %x %x", decoded, shares[0])
+		}
+	}
+}
+
+func TestDecodeTypeableShare_typos(t *testing.T) {
+	encoded := strings.ReplaceAll(EncodeTypeableShare([]byte("a test share value")), typeableSeparator, "")
+
+	// every single character substitution is pinpointed to its group
+	for i := range encoded {
+		for _, c := range typeableAlphabet {
+			if byte(c) == encoded[i] {
+				continue
+			}
+			typo := encoded[:i] + string(c) + encoded[i+1:]
+			_, err := DecodeTypeableShare(typo)
+			cerr, ok := err.(*ChecksumError)
+			if !ok {
+				t.Fatalf("position %d: expected checksum error, got %v", i, err)
+			}
+			if cerr.Group != i/typeableGroupSize+1 || cerr.Share != -1 {
+				t.Fatalf("position %d: bad: %+v", i, cerr)
+			}
+		}
+	}
+
+	// adjacent transpositions within a group are detected
+	for i := 0; i < len(encoded)-1; i++ {
+		if encoded[i] == encoded[i+1] || i%typeableGroupSize == typeableGroupSize-1 {
+			continue
+		}
+		typo := encoded[:i] + string(encoded[i+1]) + string(encoded[i]) + encoded[i+2:]
+		if _, err := DecodeTypeableShare(typo); err == nil {
+			t.Fatalf("position %d: expected transposition to be detected", i)
+		}
+	}
+}
+
+func TestDecodeTypeableShare_invalid(t *testing.T) {
+	encoded := EncodeTypeableShare([]byte("a test share value"))
+	for _, s := range []string{"", "U", encoded + "-A", encoded[:len(encoded)-1], strings.Replace(encoded, "-", "!", 1)} {
+		if _, err := DecodeTypeableShare(s); err == nil {
+			t.Fatalf("expect error for %q", s)
+		}
+	}
+}
+
+func TestCombineTypeableShares(t *testing.T) {
+	secret := []byte("test")
+	out, err := Split(secret, 3, 2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	encoded := []string{}
+	for _, share := range out {
+		encoded = append(encoded, EncodeTypeableShare(share))
+	}
+
+	recomb, err := CombineTypeableShares(encoded[1:])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(recomb, secret) {
+		t.Fatalf("bad: %v %v", recomb, secret)
+	}
+
+	// flip a character of the last share
+	typo := []byte(encoded[2])
+	if typo[0] == 'A' {
+		typo[0] = 'B'
+	} else {
+		typo[0] = 'A'
+	}
+	_, err = CombineTypeableShares([]string{encoded[0], encoded[1], string(typo)})
+	cerr, ok := err.(*ChecksumError)
+	if !ok {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if cerr.Share != 2 || cerr.Group != 1 {
+		t.Fatalf("bad: %+v", cerr)
+	}
+	if cerr.Error() != "share 2 failed its checksum in group 1 (characters 1-5)" {
+		t.Fatalf("bad: %s", cerr.Error())
+	}
+}
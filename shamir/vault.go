@@ -0,0 +1,64 @@
+package shamir
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Shares produced by Split use the exact layout of Hashicorp Vault's
+// shamir package: the y values for each byte of the secret followed by
+// a single trailing x coordinate byte, over the same GF(2^8) field.
+// Vault displays those raw bytes as base64 ("Unseal Key 1: ...") or
+// as hex (the "keys" field of `vault operator init -format=json`).
+
+// EncodeVaultShareBase64 returns a share in Vault's base64 representation
+func EncodeVaultShareBase64(share []byte) string {
+	return base64.StdEncoding.EncodeToString(share)
+}
+
+// EncodeVaultShareHex returns a share in Vault's hex representation
+func EncodeVaultShareHex(share []byte) string {
+	return hex.EncodeToString(share)
+}
+
+// DecodeVaultShare decodes a share in either of Vault's base64 or hex
+// representations. Hex is attempted first since every hex string is also
+// made up of valid base64 characters, while the reverse is not true.
+func DecodeVaultShare(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s)%2 == 0 {
+		if share, err := hex.DecodeString(s); err == nil {
+			return checkVaultShare(share)
+		}
+	}
+	share, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("share is neither valid hex nor valid base64")
+	}
+	return checkVaultShare(share)
+}
+
+// DecodeVaultShares decodes a list of shares as per DecodeVaultShare
+func DecodeVaultShares(encoded []string) ([][]byte, error) {
+	shares := [][]byte{}
+	for i, s := range encoded {
+		share, err := DecodeVaultShare(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid share %d: %s", i, err)
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+func checkVaultShare(share []byte) ([]byte, error) {
+	if len(share) < 2 {
+		return nil, fmt.Errorf("shares must be at least two bytes")
+	}
+	if share[len(share)-1] == 0 {
+		return nil, fmt.Errorf("share has an invalid x coordinate of zero")
+	}
+	return share, nil
+}
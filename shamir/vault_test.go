@@ -0,0 +1,95 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+// vaultVectors are 2-of-3 shares of the secret "vault-unseal-key" in the
+// layout used by Hashicorp Vault's shamir package. They were computed
+// independently of this package with a shift-and-add GF(2^8) multiply
+// over x^8 + x^4 + x^3 + x + 1, using the co-efficients 0x10..0x1f and
+// the x coordinates 0x2a, 0x7f and 0xc3.
+var vaultVectors = []struct {
+	b64 string
+	hex string
+}{
+	{b64: "4N23hEo5Hy6ukujPWDREcio=", hex: "e0ddb7844a391f2eae92e8cf583444722a"},
+	{b64: "x686XCIE3bkXfvuJrpcYe38=", hex: "c7af3a5c2204ddb9177efb89ae97187b7f"},
+	{b64: "8iZsttFLTZW1YDr0yk8fwMM=", hex: "f2266cb6d14b4d95b5603af4ca4f1fc0c3"},
+}
+
+var vaultSecret = []byte("vault-unseal-key")
+
+func TestDecodeVaultShare(t *testing.T) {
+	for i, v := range vaultVectors {
+		fromB64, err := DecodeVaultShare(v.b64)
+		if err != nil {
+			t.Fatalf("vector %d: err: %v", i, err)
+		}
+		fromHex, err := DecodeVaultShare(v.hex)
+		if err != nil {
+			t.Fatalf("vector %d: err: %v", i, err)
+		}
+		if !bytes.Equal(fromB64, fromHex) {
+			t.Fatalf("vector %d: b64 and hex shares differ", i)
+		}
+		if out := EncodeVaultShareBase64(fromHex); out != v.b64 {
+			t.Fatalf("vector %d: bad: %s", i, out)
+		}
+		if out := EncodeVaultShareHex(fromB64); out != v.hex {
+			t.Fatalf("vector %d: bad: %s", i, out)
+		}
+	}
+}
+
+func TestDecodeVaultShare_invalid(t *testing.T) {
+	for _, s := range []string{"", "not a share!", "AA==", "4100"} {
+		if _, err := DecodeVaultShare(s); err == nil {
+			t.Fatalf("expect error for %q", s)
+		}
+	}
+}
+
+func TestCombineVaultVectors(t *testing.T) {
+	for i := 0; i < len(vaultVectors); i++ {
+		for j := 0; j < len(vaultVectors); j++ {
+			if i == j {
+				continue
+			}
+			shares, err := DecodeVaultShares([]string{vaultVectors[i].b64, vaultVectors[j].hex})
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			secret, err := Combine(shares)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !bytes.Equal(secret, vaultSecret) {
+				t.Fatalf("(i:%d, j:%d) bad: %q", i, j, secret)
+			}
+		}
+	}
+}
+
+func TestSplitVaultRoundTrip(t *testing.T) {
+	shares, err := Split(vaultSecret, 5, 3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	encoded := []string{}
+	for _, share := range shares[:3] {
+		encoded = append(encoded, EncodeVaultShareBase64(share))
+	}
+	decoded, err := DecodeVaultShares(encoded)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	secret, err := Combine(decoded)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(secret, vaultSecret) {
+		t.Fatalf("bad: %q", secret)
+	}
+}
@@ -1,9 +1,11 @@
 package multikey
 
 import (
+	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
 	"errors"
+	"io"
 
 	"fmt"
 
@@ -28,6 +30,11 @@ type shard struct {
 type encryptedShard struct {
 	Value string `json:"value"`
 	KeyID string `json:"key_id"`
+
+	// X is the (public) x coordinate of the shamir part within the shard,
+	// or zero if it is not recorded. Knowing which coordinates are in use
+	// allows new parts to be added to a secret without decrypting all of it.
+	X int `json:"-"`
 }
 
 // newShard returns a populated Shard struct
@@ -42,10 +49,16 @@ func newShard(value []byte) (*shard, error) {
 
 // encrypt encrypts and ASCII armours a shard's value
 func (s *shard) encrypt(k *rsa.PublicKey) (*encryptedShard, error) {
+	return s.encryptWithRand(k, rand.Reader)
+}
+
+// encryptWithRand encrypts and ASCII armours a shard's value,
+// drawing any randomness needed from the given reader
+func (s *shard) encryptWithRand(k *rsa.PublicKey, random io.Reader) (*encryptedShard, error) {
 	if len(s.Value) == 0 {
 		return nil, errors.New(errMsgEmptyValue)
 	}
-	armoured, err := encryptAndArmourShamirPart(s.Value, k)
+	armoured, err := encryptAndArmourShamirPart(s.Value, k, random)
 	if err != nil {
 		return nil, err
 	}
@@ -84,9 +97,9 @@ func decryptAndUnarmourShamirPart(data string, k *rsa.PrivateKey) ([]byte, error
 }
 
 // encryptAndArmourShamirPart -
-func encryptAndArmourShamirPart(data []byte, k *rsa.PublicKey) (string, error) {
+func encryptAndArmourShamirPart(data []byte, k *rsa.PublicKey, random io.Reader) (string, error) {
 	// encrypt shard value
-	enc, err := keys.EncryptMessage(data, k)
+	enc, err := keys.EncryptMessageWithRand(data, k, random)
 	if err != nil {
 		return "", fmt.Errorf("%s: %s", errMsgCouldNotEncrypt, err)
 	}
@@ -0,0 +1,53 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/adrianosela/multikey/keys"
+)
+
+const (
+	errMsgSharesKeysMismatch = "the amount of shares must match the amount of keys provided"
+	errMsgNoShardForKey      = "the secret has no shard for the provided key"
+)
+
+// EncryptShares wraps pre-existing Shamir shares, such as Hashicorp Vault
+// unseal keys (see shamir.DecodeVaultShare), into an encrypted secret.
+// The i-th share is encrypted with the i-th public key, so the secret is
+// decryptable with as many of the keys as the threshold the shares were
+// originally split with.
+func EncryptShares(shares [][]byte, pubs []*rsa.PublicKey) (string, error) {
+	if len(shares) != len(pubs) {
+		return "", errors.New(errMsgSharesKeysMismatch)
+	}
+	secret, err := encryptParts(shares, pubs, nil)
+	if err != nil {
+		return "", err
+	}
+	return secret.encodePEM()
+}
+
+// DecryptShare decrypts only the shard of a secret which belongs to the
+// given key, returning the raw Shamir share. For secrets created with
+// EncryptShares this allows a single key holder to export their share
+// e.g. as a Hashicorp Vault unseal key (see shamir.EncodeVaultShareBase64).
+func DecryptShare(enc string, priv *rsa.PrivateKey) ([]byte, error) {
+	s, err := decodePEM(enc)
+	if err != nil {
+		return nil, ErrMalformedSecret
+	}
+	fp := keys.GetFingerprint(&priv.PublicKey)
+	for _, sh := range s.shards {
+		if sh.KeyID != fp {
+			continue
+		}
+		decrypted, err := sh.decrypt(priv)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting shard: %s", err)
+		}
+		return decrypted.Value, nil
+	}
+	return nil, errors.New(errMsgNoShardForKey)
+}
@@ -0,0 +1,52 @@
+package multikey
+
+import (
+	"testing"
+
+	"github.com/adrianosela/multikey/shamir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecryptShares(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+
+	// shares as printed by `vault operator init` for a 2-of-3 split
+	unsealKeys := []string{
+		"4N23hEo5Hy6ukujPWDREcio=",
+		"x686XCIE3bkXfvuJrpcYe38=",
+		"8iZsttFLTZW1YDr0yk8fwMM=",
+	}
+	shares, err := shamir.DecodeVaultShares(unsealKeys)
+	assert.Nil(t, err)
+
+	enc, err := EncryptShares(shares, pubs)
+	assert.Nil(t, err)
+
+	// any two keys reconstruct the original vault secret
+	plain, err := Decrypt(enc, privs[1:])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("vault-unseal-key"), plain)
+
+	// each key holder can export their own unseal key
+	for i, priv := range privs {
+		share, err := DecryptShare(enc, priv)
+		assert.Nil(t, err)
+		assert.Equal(t, unsealKeys[i], shamir.EncodeVaultShareBase64(share))
+	}
+
+	// mismatched amount of shares and keys
+	_, err = EncryptShares(shares[:2], pubs)
+	assert.EqualError(t, err, errMsgSharesKeysMismatch)
+}
+
+func TestDecryptShareNoShard(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob")
+	enc, err := Encrypt([]byte("test secret value"), pubs[:1], 1)
+	assert.Nil(t, err)
+
+	_, err = DecryptShare(enc, privs[1])
+	assert.EqualError(t, err, errMsgNoShardForKey)
+
+	_, err = DecryptShare("not a secret", privs[0])
+	assert.EqualError(t, err, errMsgCouldNotDecode)
+}
@@ -0,0 +1,110 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/adrianosela/multikey/keys"
+)
+
+const (
+	errMsgUnsigned         = "secret is not signed"
+	errMsgUntrustedSigner  = "secret is not signed by a trusted key"
+	errMsgInvalidSignature = "secret signature is invalid"
+
+	// signatureContext prefixes the messages author signatures sign
+	signatureContext = "multikey secret signature\n"
+)
+
+var (
+	// ErrUnsigned is returned when decrypting a secret which is not signed
+	// while trusted signers are required
+	ErrUnsigned = errors.New(errMsgUnsigned)
+
+	// ErrUntrustedSigner is returned when decrypting a secret signed by a
+	// key which is not trusted to sign it
+	ErrUntrustedSigner = errors.New(errMsgUntrustedSigner)
+
+	// ErrInvalidSignature is returned when decrypting a secret whose
+	// signature does not match its contents
+	ErrInvalidSignature = errors.New(errMsgInvalidSignature)
+)
+
+// SignerPolicy returns the public key of a trusted signer given its
+// fingerprint, or an error if the signer is not trusted
+type SignerPolicy func(fingerprint string) (*rsa.PublicKey, error)
+
+// requiresSignature reports whether the options require secrets to be signed
+func (o *DecryptOptions) requiresSignature() bool {
+	return o != nil && (o.SignerPolicy != nil || len(o.Signers) > 0)
+}
+
+// signerKey returns the trusted key with the given fingerprint
+func (o *DecryptOptions) signerKey(fingerprint string) (*rsa.PublicKey, error) {
+	if o.SignerPolicy != nil {
+		pub, err := o.SignerPolicy(fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrUntrustedSigner, fingerprint, err)
+		}
+		if keys.GetFingerprint(pub) != fingerprint {
+			return nil, fmt.Errorf("%w: %s", ErrUntrustedSigner, fingerprint)
+		}
+		return pub, nil
+	}
+	for _, pub := range o.Signers {
+		if keys.GetFingerprint(pub) == fingerprint {
+			return pub, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUntrustedSigner, fingerprint)
+}
+
+// sign signs the headers, shards and payload of a version 2 secret
+func (s *secret) sign(priv *rsa.PrivateKey, random io.Reader) error {
+	s.signer = keys.GetFingerprint(&priv.PublicKey)
+	s.signature = nil
+	sig, err := keys.SignMessageWithRand(s.signedMessage(), priv, random)
+	if err != nil {
+		return err
+	}
+	s.signature = sig
+	return nil
+}
+
+// unsign removes the signature of a secret
+func (s *secret) unsign() {
+	s.signer, s.signature = "", nil
+}
+
+// verifySignature checks that the secret is signed by a key trusted by
+// the given options, if they require signatures
+func (s *secret) verifySignature(opts *DecryptOptions) error {
+	if !opts.requiresSignature() {
+		return nil
+	}
+	if s.signature == nil {
+		return ErrUnsigned
+	}
+	pub, err := opts.signerKey(s.signer)
+	if err != nil {
+		return err
+	}
+	if keys.VerifySignature(s.signedMessage(), s.signature, pub) != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// signedMessage returns what the signature of a secret covers: its
+// authenticated headers, its signer, and its encoding without its
+// signature. The context of bound secrets is left out: it is checked by
+// opening their payload.
+func (s *secret) signedMessage() []byte {
+	unsigned := *s
+	unsigned.signature = nil
+	msg := signatureContext + string(s.headerData())
+	msg += fmt.Sprintf("%s: %s%s", headerSignedBy, s.signer, simpleFmtSeparator)
+	return []byte(msg + unsigned.encodeSimple())
+}
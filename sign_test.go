@@ -0,0 +1,150 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedSecret(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	alice, bob := keys.GetFingerprint(pubs[0]), keys.GetFingerprint(pubs[1])
+	testSecret := []byte("postgres://db.internal/prod")
+
+	signed, err := EncryptWithOptions(testSecret, pubs[1:], 1, &EncryptOptions{Signer: privs[0]})
+	assert.Nil(t, err)
+	assert.Contains(t, signed, "Signed-By: "+alice)
+	unsigned, err := Encrypt(testSecret, pubs[1:], 1)
+	assert.Nil(t, err)
+	// bob replaces the secret with one of his own, claiming alice wrote it
+	forged, err := EncryptWithOptions([]byte("postgres://bob.example/prod"), pubs[1:], 1, &EncryptOptions{Signer: privs[1]})
+	assert.Nil(t, err)
+	forged = strings.Replace(forged, "Signed-By: "+bob, "Signed-By: "+alice, 1)
+
+	info, err := Inspect(signed)
+	assert.Nil(t, err)
+	assert.Equal(t, alice, info.Signer)
+	info, err = Inspect(unsigned)
+	assert.Nil(t, err)
+	assert.Equal(t, "", info.Signer)
+
+	trustAlice := &DecryptOptions{Signers: []*rsa.PublicKey{pubs[0]}}
+	tests := []struct {
+		testName  string
+		enc       string
+		opts      *DecryptOptions
+		expectErr error
+	}{
+		{
+			testName: "signatures are not required by default",
+			enc:      unsigned,
+		},
+		{
+			testName: "signed by a trusted key",
+			enc:      signed,
+			opts:     trustAlice,
+		},
+		{
+			testName:  "unsigned",
+			enc:       unsigned,
+			opts:      trustAlice,
+			expectErr: ErrUnsigned,
+		},
+		{
+			testName:  "signed by an untrusted key",
+			enc:       signed,
+			opts:      &DecryptOptions{Signers: []*rsa.PublicKey{pubs[1]}},
+			expectErr: ErrUntrustedSigner,
+		},
+		{
+			testName:  "forged signer",
+			enc:       forged,
+			opts:      trustAlice,
+			expectErr: ErrInvalidSignature,
+		},
+		{
+			testName: "signer policy",
+			enc:      signed,
+			opts: &DecryptOptions{SignerPolicy: func(fp string) (*rsa.PublicKey, error) {
+				if fp != alice {
+					return nil, errors.New("not an author")
+				}
+				return pubs[0], nil
+			}},
+		},
+		{
+			testName: "signer policy refusing the signer",
+			enc:      signed,
+			opts: &DecryptOptions{SignerPolicy: func(fp string) (*rsa.PublicKey, error) {
+				return nil, errors.New("not an author")
+			}},
+			expectErr: ErrUntrustedSigner,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			plain, err := DecryptWithOptions(test.enc, privs[1:2], test.opts)
+			if test.expectErr != nil {
+				assert.True(t, errors.Is(err, test.expectErr), "%v", err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.NotEmpty(t, plain)
+		})
+	}
+
+	// tampering with the signature line is detected too
+	lines := strings.Split(signed, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "c2lnbmF0dXJl") { // base64 of "signature"
+			lines[i] = "c2lnbmF0dXJl" + strings.Repeat("A", len(line)-len("c2lnbmF0dXJl"))
+		}
+	}
+	tampered := strings.Join(lines, "\n")
+	assert.NotEqual(t, signed, tampered)
+	_, err = DecryptWithOptions(tampered, privs[1:2], trustAlice)
+	assert.NotNil(t, err)
+}
+
+func TestUpdateSignedSecret(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	trustAlice := &DecryptOptions{Signers: []*rsa.PublicKey{pubs[0]}}
+	signed, err := EncryptWithOptions([]byte("v1"), pubs, 2, &EncryptOptions{Signer: privs[0]})
+	assert.Nil(t, err)
+
+	// unchanged secrets keep their signature
+	updated, err := Update(signed, []byte("v1"), privs, pubs, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, signed, updated)
+
+	// changed ones are signed again by the given signer
+	updated, err = UpdateWithOptions(signed, []byte("v2"), privs, pubs, 2, &EncryptOptions{Signer: privs[0]})
+	assert.Nil(t, err)
+	plain, err := DecryptWithOptions(updated, privs, trustAlice)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), plain)
+
+	// and otherwise lose their signature
+	updated, err = Update(signed, []byte("v2"), privs, pubs, 2)
+	assert.Nil(t, err)
+	info, err := Inspect(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, "", info.Signer)
+
+	// signing an unchanged secret with another key re-signs it
+	updated, err = UpdateWithOptions(signed, []byte("v1"), privs, pubs, 2, &EncryptOptions{Signer: privs[1]})
+	assert.Nil(t, err)
+	info, err = Inspect(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, keys.GetFingerprint(pubs[1]), info.Signer)
+
+	rewrapped, err := RewrapWithOptions(signed, privs, pubs[:2], 2, &EncryptOptions{Signer: privs[0]})
+	assert.Nil(t, err)
+	plain, err = DecryptWithOptions(rewrapped, privs, trustAlice)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), plain)
+}
@@ -0,0 +1,555 @@
+// Package structured encrypts the values of JSON and YAML documents while
+// leaving their keys readable, so that changes to encrypted configuration
+// files can still be reviewed:
+//
+//	database:
+//	  user: MK[0,str,8vJq...]
+//	  port: MK[0,int,Qm9z...]
+//	multikey:
+//	  version: 1
+//	  groups:
+//	    - path_regex: ^/database/
+//	      secret: |
+//	        -----BEGIN MULTIKEY ENCRYPTED SECRET-----
+//	        ...
+//	      mac: 2hV0...
+//
+// Values are encrypted with AES-256-GCM under the data key of the group
+// of the first rule matching their path, and bound to that path so that
+// they can not be moved around. Each group's data key is a multikey
+// secret, decryptable with n-of-N of the rule's keys. Each group also
+// carries a MAC over the entire document, so that removing, adding or
+// renaming values is detected by anyone able to decrypt any of it.
+package structured
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/adrianosela/multikey"
+)
+
+// Format is the syntax of a structured document
+type Format int
+
+// supported formats
+const (
+	JSON Format = iota
+	YAML
+)
+
+const (
+	metadataKey     = "multikey"
+	metadataVersion = "1"
+	macContext      = "multikey structured v1"
+	dataKeySize     = 32
+
+	valuePrefix = "MK["
+	valueSuffix = "]"
+
+	errMsgNotEncrypted     = "document is not encrypted"
+	errMsgAlreadyEncrypted = "document is already encrypted"
+	errMsgTampered         = "document MAC does not match, it has been tampered with"
+	errMsgRootNotMap       = "document must be a map at the top level"
+	errMsgBadMetadata      = "invalid multikey metadata"
+	errMsgUnknownFormat    = "unknown document format"
+)
+
+var (
+	// ErrNotEncrypted is returned when decrypting a document which has
+	// no multikey metadata
+	ErrNotEncrypted = errors.New(errMsgNotEncrypted)
+
+	// ErrTampered is returned when a document's MAC does not match its contents
+	ErrTampered = errors.New(errMsgTampered)
+)
+
+// FormatFromPath returns the format of a file given its name
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	}
+	return 0, fmt.Errorf("%s: %s", errMsgUnknownFormat, path)
+}
+
+// Rule determines who can decrypt the values of a document
+type Rule struct {
+	// PathRegex selects the values the rule applies to, by their JSON
+	// pointer (RFC 6901) path, e.g. /database/password. An empty regex
+	// matches every value.
+	PathRegex string
+
+	// Keys are the keys the values are encrypted with, Require of which
+	// are needed to decrypt them
+	Keys    []*rsa.PublicKey
+	Require int
+}
+
+// Options configures optional behaviour of Encrypt, Rewrap and the
+// decryption functions
+type Options struct {
+	// Rand is the source of randomness used to encrypt the document.
+	// Defaults to crypto/rand.Reader when nil; setting it to a
+	// deterministic stream is only ever appropriate in tests.
+	Rand io.Reader
+
+	// Context binds the document to a context such as its path, as with
+	// multikey.EncryptOptions, in addition to each value being bound to
+	// its path within the document. The same context is required to
+	// decrypt it.
+	Context string
+
+	// Signer, when set, signs the data keys of the document as their
+	// author, as with multikey.EncryptOptions. Rewrapping a document
+	// without it unsigns them.
+	Signer *rsa.PrivateKey
+
+	// Signers and SignerPolicy decide which keys are trusted to sign the
+	// data keys of the document when decrypting it, as with
+	// multikey.DecryptOptions
+	Signers      []*rsa.PublicKey
+	SignerPolicy multikey.SignerPolicy
+}
+
+func (o *Options) rand() io.Reader {
+	if o == nil || o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
+func (o *Options) context() string {
+	if o == nil {
+		return ""
+	}
+	return o.Context
+}
+
+// encryptOptions returns the options with which to encrypt data keys
+func (o *Options) encryptOptions() *multikey.EncryptOptions {
+	opts := &multikey.EncryptOptions{Rand: o.rand(), Context: o.context()}
+	if o != nil {
+		opts.Signer = o.Signer
+	}
+	return opts
+}
+
+// decryptOptions returns the options with which to decrypt data keys
+func (o *Options) decryptOptions() *multikey.DecryptOptions {
+	opts := &multikey.DecryptOptions{Context: o.context()}
+	if o != nil {
+		opts.Signers = o.Signers
+		opts.SignerPolicy = o.SignerPolicy
+	}
+	return opts
+}
+
+// group is a data key shared by all of the values a rule applies to
+type group struct {
+	pathRegex string
+	secret    string // the data key, as a multikey encrypted secret
+	mac       []byte
+	key       []byte // nil unless the group has been opened
+}
+
+// Encrypt encrypts every value of a document with the first of the given
+// rules which matches its path. Every value must be matched by a rule.
+func Encrypt(doc []byte, format Format, rules []Rule, opts *Options) ([]byte, error) {
+	root, err := parse(doc, format)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := root.get(metadataKey); ok {
+		return nil, errors.New(errMsgAlreadyEncrypted)
+	}
+	regexes := make([]*regexp.Regexp, len(rules))
+	for i, r := range rules {
+		if regexes[i], err = regexp.Compile(r.PathRegex); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid path regex: %s", i, err)
+		}
+	}
+
+	// groups are created for the rules which are used, in order of first use
+	groups := []*group{}
+	ruleGroups := map[int]int{}
+	err = root.walk("", func(path string, leaf *node) error {
+		rule := -1
+		for i, re := range regexes {
+			if re.MatchString(path) {
+				rule = i
+				break
+			}
+		}
+		if rule < 0 {
+			return fmt.Errorf("no rule matches %s", path)
+		}
+		g, ok := ruleGroups[rule]
+		if !ok {
+			key := make([]byte, dataKeySize)
+			if _, err := io.ReadFull(opts.rand(), key); err != nil {
+				return fmt.Errorf("could not create data key: %s", err)
+			}
+			secret, err := multikey.EncryptWithOptions(key, rules[rule].Keys, rules[rule].Require, opts.encryptOptions())
+			if err != nil {
+				return fmt.Errorf("rule %d: %s", rule, err)
+			}
+			g = len(groups)
+			ruleGroups[rule] = g
+			groups = append(groups, &group{pathRegex: rules[rule].PathRegex, secret: secret, key: key})
+		}
+		return encryptValue(leaf, path, g, groups[g].key, opts.rand())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	canonical := canonicalBytes(root, groups)
+	for _, g := range groups {
+		g.mac = computeMAC(g.key, canonical)
+	}
+	root.set(metadataKey, metadataNode(groups))
+	return format.format(root)
+}
+
+// Decrypt decrypts every value of a document. Enough keys to decrypt
+// every group of values are required.
+func Decrypt(doc []byte, format Format, privs []*rsa.PrivateKey) ([]byte, error) {
+	return DecryptWithOptions(doc, format, privs, nil)
+}
+
+// DecryptWithOptions is like Decrypt, for documents bound to the context
+// of the given options or which must be signed by a key they trust
+func DecryptWithOptions(doc []byte, format Format, privs []*rsa.PrivateKey, opts *Options) ([]byte, error) {
+	root, groups, err := open(doc, format, privs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := decryptValues(root, "", groups); err != nil {
+		return nil, err
+	}
+	return format.format(root)
+}
+
+// DecryptPath decrypts the value at a JSON pointer (RFC 6901) path of a
+// document, e.g. /database/password. Only enough keys to decrypt the
+// values under the path are required. A single value is returned as is,
+// maps and lists are returned as documents in the given format.
+func DecryptPath(doc []byte, format Format, path string, privs []*rsa.PrivateKey) ([]byte, error) {
+	return DecryptPathWithOptions(doc, format, path, privs, nil)
+}
+
+// DecryptPathWithOptions is like DecryptPath, for documents bound to the
+// context of the given options or which must be signed by a key they trust
+func DecryptPathWithOptions(doc []byte, format Format, path string, privs []*rsa.PrivateKey, opts *Options) ([]byte, error) {
+	root, groups, err := open(doc, format, privs, opts)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := root.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := decryptValues(sub, path, groups); err != nil {
+		return nil, err
+	}
+	if sub.kind == scalarNode {
+		return []byte(sub.text), nil
+	}
+	return format.format(sub)
+}
+
+// Rewrap re-encrypts the data keys of the groups of a document, as
+// multikey.Rewrap does, for the keys and threshold which recipients
+// returns given a description of each group's data key. Groups for which
+// recipients returns no keys are kept as they are, as are the values of
+// the document. The MAC of each group covers the data keys of every
+// group, so enough keys to decrypt every group are required.
+func Rewrap(doc []byte, format Format, privs []*rsa.PrivateKey, recipients func(*multikey.Info) ([]*rsa.PublicKey, int, error), opts *Options) ([]byte, error) {
+	root, groups, err := open(doc, format, privs, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if g.key == nil {
+			return nil, multikey.ErrInsufficientKeys
+		}
+	}
+	for i, g := range groups {
+		info, err := multikey.Inspect(g.secret)
+		if err != nil {
+			return nil, err
+		}
+		pubs, require, err := recipients(info)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %s", i, err)
+		}
+		if len(pubs) == 0 {
+			continue
+		}
+		if g.secret, err = multikey.RewrapWithOptions(g.secret, privs, pubs, require, opts.encryptOptions()); err != nil {
+			return nil, fmt.Errorf("group %d: %s", i, err)
+		}
+	}
+
+	canonical := canonicalBytes(root, groups)
+	for _, g := range groups {
+		g.mac = computeMAC(g.key, canonical)
+	}
+	root.set(metadataKey, metadataNode(groups))
+	return format.format(root)
+}
+
+// open parses an encrypted document, opens the groups which can be opened
+// with the given keys and verifies the document's MAC with each of them
+func open(doc []byte, format Format, privs []*rsa.PrivateKey, opts *Options) (*node, []*group, error) {
+	root, err := parse(doc, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, ok := root.get(metadataKey)
+	if !ok {
+		return nil, nil, ErrNotEncrypted
+	}
+	root.remove(metadataKey)
+	groups, err := parseMetadata(meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opened := 0
+	for _, g := range groups {
+		key, err := multikey.DecryptWithOptions(g.secret, privs, opts.decryptOptions())
+		if err == multikey.ErrInsufficientKeys {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(key) != dataKeySize {
+			return nil, nil, errors.New(errMsgBadMetadata)
+		}
+		g.key = key
+		opened++
+	}
+	if opened == 0 {
+		return nil, nil, multikey.ErrInsufficientKeys
+	}
+
+	canonical := canonicalBytes(root, groups)
+	for _, g := range groups {
+		if g.key != nil && !hmac.Equal(g.mac, computeMAC(g.key, canonical)) {
+			return nil, nil, ErrTampered
+		}
+	}
+	return root, groups, nil
+}
+
+// encryptValue replaces a scalar with its encrypted form
+func encryptValue(leaf *node, path string, g int, key []byte, random io.Reader) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return fmt.Errorf("could not create nonce: %s", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(leaf.text), valueAD(path, leaf.typ))
+	leaf.text = fmt.Sprintf("%s%d,%s,%s%s", valuePrefix, g, leaf.typ, base64.StdEncoding.EncodeToString(sealed), valueSuffix)
+	leaf.typ = typeString
+	return nil
+}
+
+// decryptValues decrypts every value of a tree in place
+func decryptValues(n *node, path string, groups []*group) error {
+	return n.walk(path, func(path string, leaf *node) error {
+		bad := fmt.Errorf("value at %s is not a valid encrypted value", path)
+		if leaf.typ != typeString || !strings.HasPrefix(leaf.text, valuePrefix) || !strings.HasSuffix(leaf.text, valueSuffix) {
+			return bad
+		}
+		fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(leaf.text, valuePrefix), valueSuffix), ",")
+		if len(fields) != 3 {
+			return bad
+		}
+		g, err := strconv.Atoi(fields[0])
+		if err != nil || g < 0 || g >= len(groups) {
+			return bad
+		}
+		sealed, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return bad
+		}
+		if groups[g].key == nil {
+			return multikey.ErrInsufficientKeys
+		}
+		aead, err := newAEAD(groups[g].key)
+		if err != nil {
+			return err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return bad
+		}
+		nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, ct, valueAD(path, fields[1]))
+		if err != nil {
+			return fmt.Errorf("could not decrypt value at %s", path)
+		}
+		leaf.typ, leaf.text = fields[1], string(plain)
+		return nil
+	})
+}
+
+// valueAD binds an encrypted value to its path and type
+func valueAD(path, typ string) []byte {
+	return []byte(path + "\x00" + typ)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// canonicalBytes serializes an encrypted tree and its groups (but not
+// their MACs) unambiguously, as input to the document MAC
+func canonicalBytes(root *node, groups []*group) []byte {
+	var buf bytes.Buffer
+	field := func(s string) { fmt.Fprintf(&buf, "%d:%s,", len(s), s) }
+	field(macContext)
+	var write func(n *node, path string)
+	write = func(n *node, path string) {
+		field(path)
+		switch n.kind {
+		case mapNode:
+			field("map")
+			field(strconv.Itoa(len(n.values)))
+			for i, k := range n.keys {
+				write(n.values[i], childPath(path, k))
+			}
+		case listNode:
+			field("list")
+			field(strconv.Itoa(len(n.values)))
+			for i, v := range n.values {
+				write(v, childPath(path, strconv.Itoa(i)))
+			}
+		default:
+			field(n.typ)
+			field(n.text)
+		}
+	}
+	write(root, "")
+	for _, g := range groups {
+		field(g.pathRegex)
+		field(g.secret)
+	}
+	return buf.Bytes()
+}
+
+func computeMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func metadataNode(groups []*group) *node {
+	list := &node{kind: listNode}
+	for _, g := range groups {
+		list.values = append(list.values, &node{
+			kind: mapNode,
+			keys: []string{"path_regex", "secret", "mac"},
+			values: []*node{
+				newScalar(typeString, g.pathRegex),
+				newScalar(typeString, g.secret),
+				newScalar(typeString, base64.StdEncoding.EncodeToString(g.mac)),
+			},
+		})
+	}
+	return &node{
+		kind:   mapNode,
+		keys:   []string{"version", "groups"},
+		values: []*node{newScalar(typeInt, metadataVersion), list},
+	}
+}
+
+func parseMetadata(meta *node) ([]*group, error) {
+	bad := errors.New(errMsgBadMetadata)
+	if meta.kind != mapNode {
+		return nil, bad
+	}
+	version, ok := meta.get("version")
+	if !ok || version.kind != scalarNode || version.text != metadataVersion {
+		return nil, fmt.Errorf("%s: unsupported version", errMsgBadMetadata)
+	}
+	list, ok := meta.get("groups")
+	if !ok || list.kind != listNode || len(list.values) == 0 {
+		return nil, bad
+	}
+	groups := []*group{}
+	for _, item := range list.values {
+		if item.kind != mapNode {
+			return nil, bad
+		}
+		fields := map[string]string{}
+		for _, name := range []string{"path_regex", "secret", "mac"} {
+			v, ok := item.get(name)
+			if !ok || v.kind != scalarNode {
+				return nil, bad
+			}
+			fields[name] = v.text
+		}
+		mac, err := base64.StdEncoding.DecodeString(fields["mac"])
+		if err != nil {
+			return nil, bad
+		}
+		groups = append(groups, &group{pathRegex: fields["path_regex"], secret: fields["secret"], mac: mac})
+	}
+	return groups, nil
+}
+
+func parse(doc []byte, format Format) (*node, error) {
+	var root *node
+	var err error
+	switch format {
+	case JSON:
+		root, err = parseJSON(doc)
+	case YAML:
+		root, err = parseYAML(doc)
+	default:
+		return nil, errors.New(errMsgUnknownFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse document: %s", err)
+	}
+	if root.kind != mapNode {
+		return nil, errors.New(errMsgRootNotMap)
+	}
+	return root, nil
+}
+
+func (f Format) format(root *node) ([]byte, error) {
+	switch f {
+	case JSON:
+		return formatJSON(root)
+	case YAML:
+		return formatYAML(root)
+	}
+	return nil, errors.New(errMsgUnknownFormat)
+}
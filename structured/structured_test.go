@@ -0,0 +1,282 @@
+package structured
+
+import (
+	"crypto/rsa"
+	"errors"
+	"flag"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey"
+	"github.com/adrianosela/multikey/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// loadTestKeys loads the named private keys from the repository's testdata
+func loadTestKeys(t *testing.T, names ...string) ([]*rsa.PrivateKey, []*rsa.PublicKey) {
+	privs := []*rsa.PrivateKey{}
+	pubs := []*rsa.PublicKey{}
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join("..", "testdata", name+".pem"))
+		assert.Nil(t, err)
+		priv, err := keys.DecodePrivKeyPEM(raw)
+		assert.Nil(t, err)
+		privs = append(privs, priv)
+		pubs = append(pubs, &priv.PublicKey)
+	}
+	return privs, pubs
+}
+
+func readTestFile(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	assert.Nil(t, err)
+	return data
+}
+
+// testRules encrypts the database for alice and bob together, and
+// everything else for any of alice, bob or carol
+func testRules(t *testing.T) []Rule {
+	_, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	return []Rule{
+		{PathRegex: "^/database/", Keys: pubs[:2], Require: 2},
+		{Keys: pubs, Require: 1},
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	privs, _ := loadTestKeys(t, "alice", "bob", "carol")
+
+	tests := []struct {
+		file   string
+		format Format
+	}{
+		{file: "config.yaml", format: YAML},
+		{file: "config.json", format: JSON},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			plain := readTestFile(t, test.file)
+			enc, err := Encrypt(plain, test.format, testRules(t), nil)
+			assert.Nil(t, err)
+			assert.NotContains(t, string(enc), "hunter2")
+			assert.Contains(t, string(enc), "password")
+
+			dec, err := Decrypt(enc, test.format, privs)
+			assert.Nil(t, err)
+			assert.Equal(t, string(plain), string(dec))
+
+			_, err = Encrypt(enc, test.format, testRules(t), nil)
+			assert.EqualError(t, err, errMsgAlreadyEncrypted)
+		})
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	opts := &Options{Rand: mathrand.New(mathrand.NewSource(1))}
+	enc, err := Encrypt(readTestFile(t, "config.yaml"), YAML, testRules(t), opts)
+	assert.Nil(t, err)
+
+	golden := filepath.Join("testdata", "config.enc.yaml")
+	if *update {
+		assert.Nil(t, os.WriteFile(golden, enc, 0644))
+	}
+	assert.Equal(t, string(readTestFile(t, "config.enc.yaml")), string(enc))
+}
+
+func TestDecryptPath(t *testing.T) {
+	privs, _ := loadTestKeys(t, "alice", "bob", "carol")
+	alice, carol := privs[:1], privs[2:]
+	aliceBob := []*rsa.PrivateKey{privs[0], privs[1]}
+	aliceCarol := []*rsa.PrivateKey{privs[0], privs[2]}
+	enc, err := Encrypt(readTestFile(t, "config.yaml"), YAML, testRules(t), nil)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name  string
+		path  string
+		privs []*rsa.PrivateKey
+		out   string
+		err   error
+	}{
+		{name: "string", path: "/api/token", privs: carol, out: "abc/def+ghi="},
+		{name: "bool", path: "/api/enabled", privs: carol, out: "true"},
+		{name: "map", path: "/api", privs: alice, out: "token: abc/def+ghi=\nenabled: true\ntimeout: 2.5\nproxy: null\nnote: |\n  multiple\n  lines\n"},
+		{name: "list item", path: "/database/replicas/1", privs: aliceBob, out: "db-2.internal"},
+		{name: "group needs two keys", path: "/database/password", privs: aliceCarol, err: multikey.ErrInsufficientKeys},
+		{name: "no keys", path: "/api/token", privs: nil, err: multikey.ErrInsufficientKeys},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := DecryptPath(enc, YAML, test.path, test.privs)
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.out, string(out))
+		})
+	}
+
+	_, err = DecryptPath(enc, YAML, "/api/nope", privs)
+	assert.EqualError(t, err, `path "/api/nope" not found`)
+
+	// decrypting the whole document requires every group
+	_, err = Decrypt(enc, YAML, carol)
+	assert.Equal(t, multikey.ErrInsufficientKeys, err)
+}
+
+func TestDecryptTampered(t *testing.T) {
+	privs, _ := loadTestKeys(t, "alice", "bob", "carol")
+	enc, err := Encrypt(readTestFile(t, "config.json"), JSON, testRules(t), nil)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		tamper func(doc string) string
+	}{
+		{
+			name:   "renamed key",
+			tamper: func(doc string) string { return strings.Replace(doc, `"zeta"`, `"omega"`, 1) },
+		},
+		{
+			name:   "removed value",
+			tamper: func(doc string) string { return strings.Replace(doc, `"empty": {},`, ``, 1) },
+		},
+		{
+			name: "swapped values",
+			tamper: func(doc string) string {
+				root, err := parseJSON([]byte(doc))
+				assert.Nil(t, err)
+				db, _ := root.get("database")
+				pw, _ := db.get("password")
+				port, _ := db.get("port")
+				db.set("password", port)
+				db.set("port", pw)
+				out, err := formatJSON(root)
+				assert.Nil(t, err)
+				return string(out)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := test.tamper(string(enc))
+			assert.NotEqual(t, string(enc), tampered)
+			_, err := Decrypt([]byte(tampered), JSON, privs)
+			assert.Equal(t, ErrTampered, err)
+		})
+	}
+
+	_, err = Decrypt(readTestFile(t, "config.json"), JSON, privs)
+	assert.Equal(t, ErrNotEncrypted, err)
+}
+
+func TestRewrap(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	plain := readTestFile(t, "config.yaml")
+	enc, err := Encrypt(plain, YAML, testRules(t), nil)
+	assert.Nil(t, err)
+
+	// bob is replaced by carol in the database group, the other is kept
+	carolForBob := func(info *multikey.Info) ([]*rsa.PublicKey, int, error) {
+		if len(info.KeyIDs) != 2 {
+			return nil, 0, nil
+		}
+		return []*rsa.PublicKey{pubs[0], pubs[2]}, 2, nil
+	}
+	rewrapped, err := Rewrap(enc, YAML, privs, carolForBob, nil)
+	assert.Nil(t, err)
+	root, err := parse(rewrapped, YAML)
+	assert.Nil(t, err)
+	meta, _ := root.get(metadataKey)
+	groups, err := parseMetadata(meta)
+	assert.Nil(t, err)
+	encRoot, err := parse(enc, YAML)
+	assert.Nil(t, err)
+	encMeta, _ := encRoot.get(metadataKey)
+	encGroups, err := parseMetadata(encMeta)
+	assert.Nil(t, err)
+	assert.NotEqual(t, encGroups[0].secret, groups[0].secret)
+	assert.Equal(t, encGroups[1].secret, groups[1].secret)
+
+	dec, err := Decrypt(rewrapped, YAML, []*rsa.PrivateKey{privs[0], privs[2]})
+	assert.Nil(t, err)
+	assert.Equal(t, string(plain), string(dec))
+	_, err = DecryptPath(rewrapped, YAML, "/database/password", []*rsa.PrivateKey{privs[0], privs[1]})
+	assert.Equal(t, multikey.ErrInsufficientKeys, err)
+
+	// every group must be opened to recompute their MACs
+	_, err = Rewrap(enc, YAML, privs[2:], carolForBob, nil)
+	assert.Equal(t, multikey.ErrInsufficientKeys, err)
+}
+
+func TestEncryptErrors(t *testing.T) {
+	_, pubs := loadTestKeys(t, "alice")
+	onlyDB := []Rule{{PathRegex: "^/database/", Keys: pubs, Require: 1}}
+
+	_, err := Encrypt(readTestFile(t, "config.yaml"), YAML, onlyDB, nil)
+	assert.EqualError(t, err, "no rule matches /api/token")
+
+	_, err = Encrypt([]byte("- a\n- b\n"), YAML, onlyDB, nil)
+	assert.EqualError(t, err, errMsgRootNotMap)
+
+	_, err = Encrypt([]byte(`{}`), JSON, []Rule{{PathRegex: "(", Keys: pubs, Require: 1}}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestFormatFromPath(t *testing.T) {
+	f, err := FormatFromPath("config.YML")
+	assert.Nil(t, err)
+	assert.Equal(t, YAML, f)
+	f, err = FormatFromPath("dir/config.json")
+	assert.Nil(t, err)
+	assert.Equal(t, JSON, f)
+	_, err = FormatFromPath("config.toml")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptContext(t *testing.T) {
+	privs, _ := loadTestKeys(t, "alice", "bob", "carol")
+	plain := readTestFile(t, "config.yaml")
+	prod := &Options{Context: "prod/config.yaml"}
+	enc, err := Encrypt(plain, YAML, testRules(t), prod)
+	assert.Nil(t, err)
+
+	dec, err := DecryptWithOptions(enc, YAML, privs, prod)
+	assert.Nil(t, err)
+	assert.Equal(t, string(plain), string(dec))
+	_, err = DecryptWithOptions(enc, YAML, privs, &Options{Context: "staging/config.yaml"})
+	assert.True(t, errors.Is(err, multikey.ErrAuthenticationFailed))
+	_, err = DecryptPath(enc, YAML, "/database/password", privs)
+	assert.Equal(t, multikey.ErrContextMismatch, err)
+	password, err := DecryptPathWithOptions(enc, YAML, "/database/password", privs, prod)
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2 #not a comment", string(password))
+}
+
+func TestEncryptSigned(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	plain := readTestFile(t, "config.yaml")
+	alice := &Options{Signer: privs[0]}
+	enc, err := Encrypt(plain, YAML, testRules(t), alice)
+	assert.Nil(t, err)
+
+	trustAlice := &Options{Signers: pubs[:1]}
+	dec, err := DecryptWithOptions(enc, YAML, privs, trustAlice)
+	assert.Nil(t, err)
+	assert.Equal(t, string(plain), string(dec))
+	_, err = DecryptPathWithOptions(enc, YAML, "/database/password", privs, &Options{Signers: pubs[1:]})
+	assert.True(t, errors.Is(err, multikey.ErrUntrustedSigner))
+
+	// rewrapping without a signer unsigns the rewrapped groups
+	all := func(info *multikey.Info) ([]*rsa.PublicKey, int, error) { return pubs, 1, nil }
+	unsigned, err := Rewrap(enc, YAML, privs, all, nil)
+	assert.Nil(t, err)
+	_, err = DecryptWithOptions(unsigned, YAML, privs, trustAlice)
+	assert.Equal(t, multikey.ErrUnsigned, err)
+	resigned, err := Rewrap(unsigned, YAML, privs, all, alice)
+	assert.Nil(t, err)
+	_, err = DecryptWithOptions(resigned, YAML, privs, trustAlice)
+	assert.Nil(t, err)
+}
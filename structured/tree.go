@@ -0,0 +1,346 @@
+package structured
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scalar types, which are kept alongside encrypted values so that
+// decryption restores them
+const (
+	typeString = "str"
+	typeInt    = "int"
+	typeFloat  = "float"
+	typeBool   = "bool"
+	typeNull   = "null"
+)
+
+type nodeKind int
+
+const (
+	mapNode nodeKind = iota
+	listNode
+	scalarNode
+)
+
+// node is a JSON or YAML document tree. Unlike map[string]interface{},
+// it keeps the order of keys so that documents round trip unchanged.
+type node struct {
+	kind nodeKind
+
+	keys   []string // mapNode
+	values []*node  // mapNode values, listNode items
+
+	typ  string // scalarNode
+	text string // scalarNode
+}
+
+func newScalar(typ, text string) *node {
+	return &node{kind: scalarNode, typ: typ, text: text}
+}
+
+// get returns the value of a key of a map node
+func (n *node) get(key string) (*node, bool) {
+	for i, k := range n.keys {
+		if k == key {
+			return n.values[i], true
+		}
+	}
+	return nil, false
+}
+
+// remove deletes a key from a map node
+func (n *node) remove(key string) {
+	for i, k := range n.keys {
+		if k == key {
+			n.keys = append(n.keys[:i], n.keys[i+1:]...)
+			n.values = append(n.values[:i], n.values[i+1:]...)
+			return
+		}
+	}
+}
+
+// set adds or replaces a key of a map node
+func (n *node) set(key string, value *node) {
+	for i, k := range n.keys {
+		if k == key {
+			n.values[i] = value
+			return
+		}
+	}
+	n.keys = append(n.keys, key)
+	n.values = append(n.values, value)
+}
+
+// walk calls fn for every scalar in the tree, along with its path
+func (n *node) walk(path string, fn func(path string, leaf *node) error) error {
+	switch n.kind {
+	case mapNode:
+		for i, k := range n.keys {
+			if err := n.values[i].walk(childPath(path, k), fn); err != nil {
+				return err
+			}
+		}
+	case listNode:
+		for i, v := range n.values {
+			if err := v.walk(childPath(path, strconv.Itoa(i)), fn); err != nil {
+				return err
+			}
+		}
+	default:
+		return fn(path, n)
+	}
+	return nil
+}
+
+// lookup returns the node at a JSON pointer (RFC 6901) path
+func (n *node) lookup(path string) (*node, error) {
+	if path == "" {
+		return n, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	cur := n
+	for _, token := range strings.Split(path[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch cur.kind {
+		case mapNode:
+			next, ok := cur.get(token)
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+			cur = next
+		case listNode:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(cur.values) {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+			cur = cur.values[i]
+		default:
+			return nil, fmt.Errorf("path %q not found", path)
+		}
+	}
+	return cur, nil
+}
+
+// childPath returns the JSON pointer of a key or index within path
+func childPath(path, key string) string {
+	return path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// parseJSON parses a JSON document, keeping the order of object keys
+func parseJSON(data []byte) (*node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	root, err := parseJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON document")
+	}
+	return root, nil
+}
+
+func parseJSONValue(dec *json.Decoder) (*node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		n := &node{kind: listNode}
+		if t == '{' {
+			n.kind = mapNode
+		}
+		for dec.More() {
+			if n.kind == mapNode {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			v, err := parseJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return nil, err
+		}
+		return n, nil
+	case string:
+		return newScalar(typeString, t), nil
+	case json.Number:
+		if strings.ContainsAny(t.String(), ".eE") {
+			return newScalar(typeFloat, t.String()), nil
+		}
+		return newScalar(typeInt, t.String()), nil
+	case bool:
+		return newScalar(typeBool, strconv.FormatBool(t)), nil
+	default:
+		return newScalar(typeNull, "null"), nil
+	}
+}
+
+// formatJSON returns the tree as an indented JSON document
+func formatJSON(n *node) ([]byte, error) {
+	var compact bytes.Buffer
+	if err := writeJSON(&compact, n); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, compact.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, n *node) error {
+	switch n.kind {
+	case mapNode, listNode:
+		open, close := byte('['), byte(']')
+		if n.kind == mapNode {
+			open, close = '{', '}'
+		}
+		buf.WriteByte(open)
+		for i, v := range n.values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if n.kind == mapNode {
+				writeJSONString(buf, n.keys[i])
+				buf.WriteByte(':')
+			}
+			if err := writeJSON(buf, v); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(close)
+	default:
+		switch n.typ {
+		case typeString:
+			writeJSONString(buf, n.text)
+		case typeNull:
+			buf.WriteString("null")
+		default:
+			if !json.Valid([]byte(n.text)) {
+				return fmt.Errorf("%s value %q can not be represented in JSON", n.typ, n.text)
+			}
+			buf.WriteString(n.text)
+		}
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)               // strings always encode
+	buf.Truncate(buf.Len() - 1) // trailing newline
+}
+
+// parseYAML parses a YAML document. Comments, anchors and styles are not
+// kept, aliases are expanded.
+func parseYAML(data []byte) (*node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		return nil, fmt.Errorf("empty YAML document")
+	}
+	return fromYAML(&doc)
+}
+
+func fromYAML(y *yaml.Node) (*node, error) {
+	switch y.Kind {
+	case yaml.DocumentNode:
+		return fromYAML(y.Content[0])
+	case yaml.AliasNode:
+		return fromYAML(y.Alias)
+	case yaml.MappingNode:
+		n := &node{kind: mapNode}
+		for i := 0; i+1 < len(y.Content); i += 2 {
+			k := y.Content[i]
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: only scalar keys are supported", k.Line)
+			}
+			v, err := fromYAML(y.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, k.Value)
+			n.values = append(n.values, v)
+		}
+		return n, nil
+	case yaml.SequenceNode:
+		n := &node{kind: listNode}
+		for _, item := range y.Content {
+			v, err := fromYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+		}
+		return n, nil
+	default:
+		switch y.ShortTag() {
+		case "!!int":
+			return newScalar(typeInt, y.Value), nil
+		case "!!float":
+			return newScalar(typeFloat, y.Value), nil
+		case "!!bool":
+			return newScalar(typeBool, y.Value), nil
+		case "!!null":
+			return newScalar(typeNull, "null"), nil
+		default:
+			return newScalar(typeString, y.Value), nil
+		}
+	}
+}
+
+// formatYAML returns the tree as a YAML document
+func formatYAML(n *node) ([]byte, error) {
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(toYAML(n)); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func toYAML(n *node) *yaml.Node {
+	switch n.kind {
+	case mapNode:
+		y := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for i, k := range n.keys {
+			y.Content = append(y.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k},
+				toYAML(n.values[i]))
+		}
+		return y
+	case listNode:
+		y := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, v := range n.values {
+			y.Content = append(y.Content, toYAML(v))
+		}
+		return y
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!" + n.typ, Value: n.text}
+	}
+}
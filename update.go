@@ -0,0 +1,153 @@
+package multikey
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/shamir"
+)
+
+// Update re-encrypts a secret with new contents and/or recipients while
+// changing as little of the encrypted secret as possible, so that it
+// diffs well under version control:
+//
+//   - if neither the plaintext nor the recipients changed, the encrypted
+//     secret is returned as is
+//   - if only the plaintext changed, only its payload changes
+//   - if recipients were added, only their shards change
+//
+// Enough of the secret's keys to decrypt it must be given. Removing a
+// recipient, changing the threshold, or updating a legacy secret
+// re-encrypts it entirely under a new data key, so that the shards of
+// removed recipients, e.g. in version control history, can not decrypt
+// it or any later update of it.
+//
+// The secret is bound to the context of the given options, which must
+// also be the one it was bound to, if any. Its metadata is replaced by
+// that of the given options, if any.
+//
+// Signed secrets are signed again by the signer of the given options, if
+// any, and otherwise lose their signature when they change.
+func Update(enc string, data []byte, privs []*rsa.PrivateKey, pubs []*rsa.PublicKey, require int) (string, error) {
+	return UpdateWithOptions(enc, data, privs, pubs, require, nil)
+}
+
+// UpdateWithOptions is like Update but allows for optional
+// behaviour to be configured through the given options.
+func UpdateWithOptions(enc string, data []byte, privs []*rsa.PrivateKey, pubs []*rsa.PublicKey, require int, opts *EncryptOptions) (string, error) {
+	if require > len(pubs) {
+		return "", errors.New(errMsgRequireTooBig)
+	}
+	if len(data) == 0 {
+		return "", errors.New(errMsgEmptySecretPayload)
+	}
+	s, err := decodePEM(enc)
+	if err != nil {
+		return "", ErrMalformedSecret
+	}
+	if err := opts.metadata().validate(); err != nil {
+		return "", err
+	}
+	opts = opts.keepMetadata(s.metadata)
+	if s.version < currentVersion || s.threshold != require {
+		return EncryptWithOptions(data, pubs, require, opts)
+	}
+	s.context = opts.context()
+
+	// recover the data key and the parts we hold
+	parts := [][]byte{}
+	used := map[int]bool{}
+	for _, sh := range s.shards {
+		used[sh.X] = true
+		if k, ok := getKey(privs, sh.KeyID); ok {
+			decrypted, err := sh.decrypt(k)
+			if err != nil {
+				continue // pass
+			}
+			parts = append(parts, decrypted.Value)
+			used[int(decrypted.Value[len(decrypted.Value)-1])] = true
+		}
+	}
+	if len(parts) < s.threshold {
+		return "", ErrInsufficientKeys
+	}
+	dataKey, err := shamir.Combine(parts)
+	if err != nil {
+		return "", err
+	}
+	plain, err := s.openPayload(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	// keep the shards of remaining recipients, and add shards for new ones
+	wanted := map[string]*rsa.PublicKey{}
+	for _, pub := range pubs {
+		wanted[keys.GetFingerprint(pub)] = pub
+	}
+	shards := []*encryptedShard{}
+	recipientsChanged := false
+	for _, sh := range s.shards {
+		if _, ok := wanted[sh.KeyID]; !ok {
+			// removed recipients know the data key
+			return EncryptWithOptions(data, pubs, require, opts)
+		}
+		if sh.X == 0 {
+			// shards without a recorded x coordinate could clash
+			// with new shards, which need to know all of them
+			return EncryptWithOptions(data, pubs, require, opts)
+		}
+		shards = append(shards, sh)
+		delete(wanted, sh.KeyID)
+	}
+	for _, pub := range pubs {
+		if _, ok := wanted[keys.GetFingerprint(pub)]; !ok {
+			continue // already has a shard, or is a duplicate
+		}
+		delete(wanted, keys.GetFingerprint(pub))
+		x := 1
+		for used[x] {
+			x++
+		}
+		if x > 255 {
+			return "", errors.New(errMsgCouldNotSplitKey)
+		}
+		used[x] = true
+		part, err := shamir.Extend(parts, uint8(x))
+		if err != nil {
+			return "", err
+		}
+		sh, err := (&shard{Value: part}).encryptWithRand(pub, opts.rand())
+		if err != nil {
+			return "", err
+		}
+		sh.X = x
+		shards = append(shards, sh)
+		recipientsChanged = true
+	}
+
+	resign := opts != nil && opts.Signer != nil && (s.signature == nil || s.signer != keys.GetFingerprint(&opts.Signer.PublicKey))
+	ad := s.associatedData()
+	s.bind(opts.context())
+	s.metadata = opts.metadata()
+	if s.metadata.empty() {
+		s.metadata = nil
+	}
+	headersChanged := !bytes.Equal(ad, s.associatedData())
+	if !recipientsChanged && bytes.Equal(plain, data) && !resign && !headersChanged {
+		return enc, nil
+	}
+	s.shards = shards
+	s.sortShards()
+	if !bytes.Equal(plain, data) || headersChanged {
+		if s.payload, err = sealPayload(dataKey, data, s.associatedData(), opts.rand()); err != nil {
+			return "", err
+		}
+	}
+	if err := opts.sign(s); err != nil {
+		return "", err
+	}
+	return s.encodePEM()
+}
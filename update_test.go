@@ -0,0 +1,136 @@
+package multikey
+
+import (
+	"crypto/rsa"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/adrianosela/multikey/keys"
+	"github.com/adrianosela/multikey/shamir"
+	"github.com/stretchr/testify/assert"
+)
+
+// lineDiff returns the lines only in a, and the lines only in b
+func lineDiff(a, b string) ([]string, []string) {
+	count := map[string]int{}
+	for _, l := range strings.Split(a, "\n") {
+		count[l]++
+	}
+	for _, l := range strings.Split(b, "\n") {
+		count[l]--
+	}
+	onlyA, onlyB := []string{}, []string{}
+	for l, n := range count {
+		for ; n > 0; n-- {
+			onlyA = append(onlyA, l)
+		}
+		for ; n < 0; n++ {
+			onlyB = append(onlyB, l)
+		}
+	}
+	return onlyA, onlyB
+}
+
+func TestUpdate(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	alice, bob, carol := privs[0], privs[1], privs[2]
+	enc, err := Encrypt([]byte("v1"), pubs[:2], 2)
+	assert.Nil(t, err)
+
+	// nothing changed
+	updated, err := Update(enc, []byte("v1"), privs, pubs[:2], 2)
+	assert.Nil(t, err)
+	assert.Equal(t, enc, updated)
+
+	// only the plaintext changed: only the payload line changes
+	updated, err = Update(enc, []byte("v2"), privs[:2], pubs[:2], 2)
+	assert.Nil(t, err)
+	removed, added := lineDiff(enc, updated)
+	assert.Len(t, removed, 1)
+	assert.Len(t, added, 1)
+	plain, err := Decrypt(updated, privs[:2])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), plain)
+
+	// a recipient is added: only lines are added
+	withCarol, err := Update(updated, []byte("v2"), privs[:2], pubs, 2)
+	assert.Nil(t, err)
+	removed, added = lineDiff(updated, withCarol)
+	assert.Empty(t, removed)
+	assert.NotEmpty(t, added)
+	for _, pair := range [][]*rsa.PrivateKey{{alice, carol}, {bob, carol}, {alice, bob}} {
+		plain, err := Decrypt(withCarol, pair)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v2"), plain)
+	}
+
+	// a recipient is removed: the secret is re-encrypted under a new data
+	// key, which the removed recipient's shard does not help recover
+	withoutBob, err := Update(withCarol, []byte("v2"), []*rsa.PrivateKey{alice, carol}, []*rsa.PublicKey{pubs[0], pubs[2]}, 2)
+	assert.Nil(t, err)
+	info, err := Inspect(withoutBob)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{keys.GetFingerprint(pubs[0]), keys.GetFingerprint(pubs[2])}, info.KeyIDs)
+	plain, err = Decrypt(withoutBob, []*rsa.PrivateKey{alice, carol})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), plain)
+	old, err := decodePEM(withCarol)
+	assert.Nil(t, err)
+	oldKey := recoverTestDataKey(t, old, alice, bob)
+	s, err := decodePEM(withoutBob)
+	assert.Nil(t, err)
+	_, err = s.openPayload(oldKey)
+	assert.EqualError(t, err, errMsgCouldNotOpenPayload)
+
+	// changing the threshold re-encrypts the secret
+	any1, err := Update(withCarol, []byte("v2"), privs, pubs, 1)
+	assert.Nil(t, err)
+	plain, err = Decrypt(any1, []*rsa.PrivateKey{carol})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), plain)
+}
+
+func TestUpdateErrors(t *testing.T) {
+	privs, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	enc, err := Encrypt([]byte("v1"), pubs, 2)
+	assert.Nil(t, err)
+
+	_, err = Update(enc, []byte("v2"), privs[:1], pubs, 2)
+	assert.Equal(t, ErrInsufficientKeys, err)
+
+	_, err = Update("garbage", []byte("v2"), privs, pubs, 2)
+	assert.Equal(t, ErrMalformedSecret, err)
+
+	_, err = Update(enc, nil, privs, pubs, 2)
+	assert.EqualError(t, err, errMsgEmptySecretPayload)
+
+	_, err = Update(enc, []byte("v2"), privs, pubs, 4)
+	assert.EqualError(t, err, errMsgRequireTooBig)
+}
+
+func TestEncryptCanonicalShardOrder(t *testing.T) {
+	_, pubs := loadTestKeys(t, "alice", "bob", "carol")
+	reversed := []*rsa.PublicKey{pubs[2], pubs[1], pubs[0]}
+	enc, err := Encrypt([]byte("test"), reversed, 2)
+	assert.Nil(t, err)
+	info, err := Inspect(enc)
+	assert.Nil(t, err)
+	assert.True(t, sort.StringsAreSorted(info.KeyIDs))
+}
+
+// recoverTestDataKey combines the shards of a secret which the given keys
+// decrypt into its data key
+func recoverTestDataKey(t *testing.T, s *secret, privs ...*rsa.PrivateKey) []byte {
+	parts := [][]byte{}
+	for _, sh := range s.shards {
+		if k, ok := getKey(privs, sh.KeyID); ok {
+			decrypted, err := sh.decrypt(k)
+			assert.Nil(t, err)
+			parts = append(parts, decrypted.Value)
+		}
+	}
+	dataKey, err := shamir.Combine(parts)
+	assert.Nil(t, err)
+	return dataKey
+}